@@ -4,20 +4,44 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"hft-sim/internal/db"
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
 )
 
 func main() {
 	var (
-		action  = flag.String("action", "", "create|list|delete")
+		action  = flag.String("action", "", "create|list|delete|set-tier|adjust|rebuild-balances|symbol-set|symbol-list|symbol-delete")
 		name    = flag.String("name", "", "Strategy name")
 		desc    = flag.String("desc", "", "Strategy description")
 		balance = flag.Float64("balance", 10000, "Initial balance")
-		apiKey  = flag.String("key", "", "API Key (for delete)")
+		apiKey  = flag.String("key", "", "API Key (for delete/set-tier/adjust/rebuild-balances)")
+		tier    = flag.String("tier", "", "Fee tier defined in config fee_tiers, empty for default rates")
 		dbPath  = flag.String("db", "hft.db", "Database path")
+		info    = flag.String("info", "", "Reason recorded in the ledger (for adjust)")
+		amount  decimal.Decimal
+
+		symbol = flag.String("symbol", "", "Symbol (for symbol-*)")
+		sym    models.Symbol
 	)
+	flag.StringVar(&sym.BaseAsset, "base", "", "Base asset")
+	flag.StringVar(&sym.QuoteAsset, "quote", "USDT", "Quote asset")
+	flag.StringVar(&sym.Status, "status", models.SymbolStatusTrading, "TRADING|BREAK")
+	flag.IntVar(&sym.PricePrecision, "price-precision", 2, "Price precision")
+	flag.IntVar(&sym.QuantityPrecision, "qty-precision", 3, "Quantity precision")
+	flag.TextVar(&sym.MinPrice, "min-price", decimal.RequireFromString("0.01"), "PRICE_FILTER minPrice")
+	flag.TextVar(&sym.MaxPrice, "max-price", decimal.NewFromInt(1000000), "PRICE_FILTER maxPrice")
+	flag.TextVar(&sym.TickSize, "tick-size", decimal.RequireFromString("0.01"), "PRICE_FILTER tickSize")
+	flag.TextVar(&sym.MinQty, "min-qty", decimal.RequireFromString("0.001"), "LOT_SIZE minQty")
+	flag.TextVar(&sym.MaxQty, "max-qty", decimal.NewFromInt(10000), "LOT_SIZE maxQty")
+	flag.TextVar(&sym.StepSize, "step-size", decimal.RequireFromString("0.001"), "LOT_SIZE stepSize")
+	flag.TextVar(&sym.MinNotional, "min-notional", decimal.NewFromInt(5), "MIN_NOTIONAL notional")
+	flag.IntVar(&sym.MaxLeverage, "max-leverage", 125, "Max leverage")
+	flag.TextVar(&amount, "amount", decimal.Zero, "Amount added to available balance, negative to deduct (for adjust)")
 	flag.Parse()
 
 	database, err := db.New(*dbPath)
@@ -26,6 +50,10 @@ func main() {
 	}
 	defer database.Close()
 
+	if err := database.Migrate(); err != nil {
+		log.Fatal(err)
+	}
+
 	switch *action {
 	case "create":
 		createKey(database, *name, *desc, *balance)
@@ -33,6 +61,19 @@ func main() {
 		listKeys(database)
 	case "delete":
 		deleteKey(database, *apiKey)
+	case "set-tier":
+		setFeeTier(database, *apiKey, *tier)
+	case "adjust":
+		adjustBalance(database, *apiKey, amount, *info)
+	case "rebuild-balances":
+		rebuildBalances(database, *apiKey)
+	case "symbol-set":
+		sym.Symbol = *symbol
+		setSymbol(database, &sym)
+	case "symbol-list":
+		listSymbols(database)
+	case "symbol-delete":
+		deleteSymbol(database, *symbol)
 	default:
 		fmt.Println("Usage: admin -action=create -name=\"MyStrategy\" -balance=10000")
 	}
@@ -49,10 +90,11 @@ func createKey(database *db.DB, name, desc string, balance float64) {
 		log.Fatal(err)
 	}
 
-	// 初始化余额记录
-	_, err = database.Exec(
-		"INSERT INTO balances (api_key, available, frozen, total_pnl) VALUES (?, ?, 0, 0)",
-		key, balance)
+	// 初始资金记为入金流水，同时生成余额记录
+	err = store.NewLedgerStore(database.DB).Post(&models.LedgerEntry{
+		APIKey: key, Type: models.LedgerTypeDeposit, Info: "initial balance",
+		Debit: models.LedgerAccountExternal, Credit: models.LedgerAccountAvailable, Amount: decimal.NewFromFloat(balance),
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -73,9 +115,14 @@ func listKeys(database *db.DB) {
 		var key, name, desc string
 		var balance float64
 		var created string
-		rows.Scan(&key, &name, &desc, &balance, &created)
+		if err := rows.Scan(&key, &name, &desc, &balance, &created); err != nil {
+			log.Fatal(err)
+		}
 		fmt.Printf("%-36s %-20s %-15.2f %s\n", key, name, balance, created)
 	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func deleteKey(database *db.DB, key string) {
@@ -85,3 +132,138 @@ func deleteKey(database *db.DB, key string) {
 	}
 	fmt.Println("Deleted")
 }
+
+func setFeeTier(database *db.DB, key, tier string) {
+	if err := store.NewAccountStore(database.DB).SetFeeTier(key, tier); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Fee tier of %s set to %q\n", key, tier)
+}
+
+// adjustBalance 管理员调账，调整金额记入可用余额，不计入累计盈亏
+func adjustBalance(database *db.DB, key string, amount decimal.Decimal, info string) {
+	if key == "" || amount.IsZero() {
+		log.Fatal("-key and a non-zero -amount are required")
+	}
+
+	ledger := store.NewLedgerStore(database.DB)
+	err := ledger.Post(&models.LedgerEntry{
+		APIKey: key, Type: models.LedgerTypeAdjustment, Info: info,
+		Debit: models.LedgerAccountExternal, Credit: models.LedgerAccountAvailable, Amount: amount,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	balance, err := store.NewBalanceStore(database.DB).Get(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Adjusted %s by %s, available %s\n", key, amount, balance.Available)
+}
+
+// rebuildBalances 按资金流水重新计算余额，key 为空时处理所有账户
+func rebuildBalances(database *db.DB, key string) {
+	keys := []string{key}
+	if key == "" {
+		keys = nil
+		rows, err := database.Query("SELECT key FROM api_keys")
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			var k string
+			if err := rows.Scan(&k); err != nil {
+				log.Fatal(err)
+			}
+			keys = append(keys, k)
+		}
+		if err := rows.Err(); err != nil {
+			log.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	ledger := store.NewLedgerStore(database.DB)
+	for _, k := range keys {
+		balance, err := ledger.Rebuild(k)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%-36s available %s frozen %s total_pnl %s\n", k, balance.Available, balance.Frozen, balance.TotalPNL)
+	}
+}
+
+// setSymbol 新增或修改交易对规则；修改时只覆盖命令行中显式指定的参数
+func setSymbol(database *db.DB, sym *models.Symbol) {
+	if sym.Symbol == "" {
+		log.Fatal("-symbol is required")
+	}
+
+	symbols := store.NewSymbolStore(database.DB)
+	existing, err := symbols.Get(sym.Symbol)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if existing != nil {
+		updated := *existing
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "base":
+				updated.BaseAsset = sym.BaseAsset
+			case "quote":
+				updated.QuoteAsset = sym.QuoteAsset
+			case "status":
+				updated.Status = sym.Status
+			case "price-precision":
+				updated.PricePrecision = sym.PricePrecision
+			case "qty-precision":
+				updated.QuantityPrecision = sym.QuantityPrecision
+			case "min-price":
+				updated.MinPrice = sym.MinPrice
+			case "max-price":
+				updated.MaxPrice = sym.MaxPrice
+			case "tick-size":
+				updated.TickSize = sym.TickSize
+			case "min-qty":
+				updated.MinQty = sym.MinQty
+			case "max-qty":
+				updated.MaxQty = sym.MaxQty
+			case "step-size":
+				updated.StepSize = sym.StepSize
+			case "min-notional":
+				updated.MinNotional = sym.MinNotional
+			case "max-leverage":
+				updated.MaxLeverage = sym.MaxLeverage
+			}
+		})
+		sym = &updated
+	} else if sym.BaseAsset == "" {
+		sym.BaseAsset = strings.TrimSuffix(sym.Symbol, sym.QuoteAsset)
+	}
+
+	if err := symbols.Save(sym); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Saved symbol %s\n", sym.Symbol)
+}
+
+func listSymbols(database *db.DB) {
+	symbols, err := store.NewSymbolStore(database.DB).List()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%-12s %-6s %-6s %-8s %-10s %-10s %-10s %-10s %-10s %s\n",
+		"Symbol", "Base", "Quote", "Status", "TickSize", "StepSize", "MinQty", "MaxQty", "Notional", "Leverage")
+	for _, s := range symbols {
+		fmt.Printf("%-12s %-6s %-6s %-8s %-10s %-10s %-10s %-10s %-10s %d\n",
+			s.Symbol, s.BaseAsset, s.QuoteAsset, s.Status, s.TickSize, s.StepSize, s.MinQty, s.MaxQty, s.MinNotional, s.MaxLeverage)
+	}
+}
+
+func deleteSymbol(database *db.DB, symbol string) {
+	if err := store.NewSymbolStore(database.DB).Delete(symbol); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Deleted")
+}
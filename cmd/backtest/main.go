@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"hft-sim/internal/api"
+	"hft-sim/internal/backtest"
+	"hft-sim/internal/clock"
+	"hft-sim/internal/config"
+	"hft-sim/internal/db"
+	"hft-sim/internal/funding"
+	"hft-sim/internal/liquidation"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/models"
+	"hft-sim/internal/replay"
+	"hft-sim/internal/snapshot"
+	"hft-sim/internal/store"
+)
+
+// 回测：用录制的行情回放驱动与实时模拟盘相同的撮合引擎、存储和 API 服务，
+// 策略进程通过 HFT_BASE_URL 访问进程内的 /api/v3 接口，结束后输出 JSON 报告
+func main() {
+	var (
+		dataDir  = flag.String("data", "recordings", "Recorded market data directory")
+		symbols  = flag.String("symbols", "", "Comma separated symbols, empty for supported_symbols in the database")
+		start    = flag.String("start", "", "Replay start (2006-01-02 or RFC3339), empty for the first recorded day")
+		end      = flag.String("end", "", "Replay end, empty for the end of the last recorded day")
+		speed    = flag.String("speed", "max", "Replay speed: 1, 10x, max or step (strategy calls POST /api/replay/step)")
+		dbPath   = flag.String("db", "", "Backtest database path, must not exist; empty for a temporary file")
+		clone    = flag.String("clone", "", "Database to clone into -db instead of starting fresh")
+		apiKey   = flag.String("key", "", "Existing API key in the cloned database, empty to create one")
+		balance  = flag.String("balance", "10000", "Initial balance of the created API key")
+		strategy = flag.String("strategy", "", "Strategy command, run with sh -c")
+		addr     = flag.String("addr", "127.0.0.1:0", "API listen address")
+		interval = flag.Duration("interval", time.Minute, "Equity curve sampling interval on the replay clock")
+		out      = flag.String("out", "", "Report output file, empty for stdout")
+	)
+	flag.Parse()
+
+	initialBalance, err := decimal.NewFromString(*balance)
+	if err != nil {
+		log.Fatalf("invalid -balance %q: %v", *balance, err)
+	}
+
+	path, err := prepareDB(*dbPath, *clone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Backtest database: %s", path)
+
+	database, err := db.New(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer database.Close()
+	if err := database.Migrate(); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := config.New(database)
+	if err := cfg.InitDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if *symbols != "" {
+		if err := cfg.SetStringSlice("supported_symbols", strings.Split(strings.ToUpper(*symbols), ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	symbolList, _ := cfg.GetStringSlice("supported_symbols")
+
+	from, err := replay.ParseTime(*start)
+	if err != nil {
+		log.Fatal(err)
+	}
+	to, err := replay.ParseTime(*end)
+	if err != nil {
+		log.Fatal(err)
+	}
+	replaySpeed, err := replay.ParseSpeed(*speed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source, err := replay.New(*dataDir, symbolList, from, to, replaySpeed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clk := source.Clock()
+
+	key, err := strategyKey(database.DB, *apiKey, initialBalance, clk)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 与实时模拟盘相同的组件，全部使用回放时钟
+	engine := matching.NewEngine(database.DB)
+	engine.SetDepthSource(source)
+	engine.SetClock(clk)
+	engine.SetFillSeed(source.Seed())
+	if err := engine.Load(); err != nil {
+		log.Fatal(err)
+	}
+	source.AddHandler(engine.OnTrade)
+
+	liquidator := liquidation.New(database.DB, engine)
+	liquidator.SetClock(clk)
+	source.AddHandler(liquidator.OnTrade)
+
+	fundingScheduler := funding.New(database.DB, engine, symbolList)
+	fundingScheduler.SetClock(clk)
+	switch rateSource, _ := cfg.Get("funding_rate_source"); rateSource {
+	case funding.RateSourceLive:
+		log.Println("Live funding rates are not available in backtests, using funding_rate")
+	case funding.RateSourceRecorded:
+		premiumPath, _ := cfg.Get("funding_premium_file")
+		recorded, err := funding.LoadRecordedPremium(premiumPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fundingScheduler.SetPremiumSource(recorded)
+	}
+	source.AddHandler(fundingScheduler.OnTrade)
+
+	gin.SetMode(gin.ReleaseMode)
+	server := api.NewServer(database.DB)
+	server.SetCollector(source)
+	server.SetClock(clk)
+	server.SetReplay(source)
+	server.SetEngine(engine)
+	server.SetFunding(fundingScheduler)
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := http.Serve(listener, releaseOnKey(server.Handler(), source, key)); err != nil {
+			log.Printf("API server stopped: %v", err)
+		}
+	}()
+	baseURL := "http://" + listener.Addr().String()
+	log.Printf("API server running on %s, API key %s", baseURL, key)
+
+	snapshotMgr := snapshot.NewManager(database.DB)
+	snapshotMgr.SetClock(clk)
+	snapshotMgr.Start()
+	fundingScheduler.Start()
+
+	// 克隆的数据库中已有的成交和资金费不计入报告，启动时补结算的资金费也已计入曲线的起始权益
+	window, err := backtest.Mark(database.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	curve := backtest.NewCurve(database.DB, key, source, clk, *interval)
+	curve.Start()
+
+	var cmd *exec.Cmd
+	strategyDone := make(chan error, 1)
+	if *strategy != "" {
+		cmd = exec.Command("sh", "-c", *strategy)
+		cmd.Env = append(os.Environ(),
+			"HFT_BASE_URL="+baseURL,
+			"HFT_API_KEY="+key,
+			"HFT_SYMBOLS="+strings.Join(symbolList, ","),
+		)
+		// 报告默认写到标准输出，策略输出转到标准错误
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		// 策略启动需要时间，回放等到策略第一个带其 API Key 的请求或 POST /api/replay/start 才开始，
+		// 否则 max 速度下行情可能在策略下单之前就已回放完
+		source.Hold()
+		if err := cmd.Start(); err != nil {
+			log.Fatal(err)
+		}
+		go func() { strategyDone <- cmd.Wait() }()
+	}
+
+	if err := source.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	select {
+	case <-source.Done():
+		if cmd != nil {
+			stopStrategy(cmd, strategyDone)
+		}
+	case err := <-strategyDone:
+		log.Printf("Strategy exited (%v), stopping replay", err)
+		source.Stop()
+		<-source.Done()
+	}
+
+	snapshotMgr.Stop()
+	fundingScheduler.Stop()
+	curve.Stop()
+	listener.Close()
+
+	report, err := backtest.BuildReport(database.DB, key, window, curve.Points(), *interval)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeReport(report, *out); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Backtest finished: pnl=%s return=%.4f%% fills=%d fees=%s funding=%s maxDrawdown=%s (%.2f%%) sharpe=%.3f",
+		report.PNL.StringFixed(4), report.Return*100, report.TradeCount, report.Fees.StringFixed(4), report.Funding.StringFixed(4),
+		report.MaxDrawdown.StringFixed(4), report.MaxDrawdownPct*100, report.Sharpe)
+}
+
+// releaseOnKey 在带有策略 API Key 的第一个请求到达时开始被 Hold 的回放，其他账户的请求不影响回放
+func releaseOnKey(next http.Handler, source *replay.Source, key string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") == key {
+			source.Release()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// prepareDB 确定回测数据库路径，clone 不为空时把它完整复制过去
+// 为避免覆盖已有数据，指定的路径必须不存在
+func prepareDB(path, clone string) (string, error) {
+	if path == "" {
+		dir, err := os.MkdirTemp("", "hft-backtest-")
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(dir, "backtest.db")
+	} else if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	if clone == "" {
+		return path, nil
+	}
+
+	src, err := sql.Open("sqlite3", clone)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	// VACUUM INTO 生成一致的副本，包含尚未合并到主文件的 WAL 内容
+	if _, err := src.Exec("VACUUM INTO ?", path); err != nil {
+		return "", fmt.Errorf("clone %s: %w", clone, err)
+	}
+	return path, nil
+}
+
+// strategyKey 返回策略使用的 API Key，key 为空时创建一个初始资金为 balance 的新账户
+func strategyKey(database *sql.DB, key string, balance decimal.Decimal, clk clock.Clock) (string, error) {
+	if key != "" {
+		var exists bool
+		err := database.QueryRow("SELECT EXISTS(SELECT 1 FROM api_keys WHERE key = ?)", key).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("api key %s not found", key)
+		}
+		return key, nil
+	}
+
+	key = uuid.New().String()
+	_, err := database.Exec(
+		"INSERT INTO api_keys (key, name, description, initial_balance, created_at) VALUES (?, 'backtest', '', ?, ?)",
+		key, balance.String(), clk.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	ledger := store.NewLedgerStore(database)
+	ledger.SetClock(clk)
+	err = ledger.Post(&models.LedgerEntry{
+		APIKey: key, Type: models.LedgerTypeDeposit, Info: "initial balance",
+		Debit: models.LedgerAccountExternal, Credit: models.LedgerAccountAvailable, Amount: balance,
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// stopStrategy 回放结束后通知策略退出，5 秒内没有退出时强制结束
+func stopStrategy(cmd *exec.Cmd, done <-chan error) {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		log.Println("Strategy did not exit, killing it")
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+func writeReport(report *backtest.Report, path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/models"
+)
+
+// getPositionMode 查询持仓模式 GET /fapi/v1/positionSide/dual
+func (s *Server) getPositionMode(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+
+	dual, err := s.accountStore.GetDualSidePosition(apiKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dualSidePosition": dual})
+}
+
+type PositionModeRequest struct {
+	DualSidePosition string `json:"dualSidePosition" form:"dualSidePosition" binding:"required"`
+}
+
+// setPositionMode 切换单向/双向持仓模式 POST /fapi/v1/positionSide/dual
+// 与币安一致，有持仓或挂单时不允许切换
+func (s *Server) setPositionMode(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+
+	var req PositionModeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter 'dualSidePosition' was not sent, was empty/null, or malformed."})
+		return
+	}
+	dual, err := strconv.ParseBool(req.DualSidePosition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter 'dualSidePosition' was not sent, was empty/null, or malformed."})
+		return
+	}
+
+	current, err := s.accountStore.GetDualSidePosition(apiKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+	if current == dual {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -4059, "msg": "No need to change position side."})
+		return
+	}
+
+	openOrders, err := s.orderStore.CountOpenByAPIKey(apiKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+	if openOrders > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -4067, "msg": "Position side cannot be changed if there exists open orders."})
+		return
+	}
+
+	positions, err := s.positionStore.GetByAPIKey(apiKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+	if len(positions) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -4068, "msg": "Position side cannot be changed if there exists position."})
+		return
+	}
+
+	if err := s.accountStore.SetDualSidePosition(apiKey, dual); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success"})
+}
+
+// resolvePositionSide 按账户持仓模式校验订单的 positionSide
+// 单向持仓只接受空值或 BOTH；双向持仓必须指定 LONG/SHORT，与腿反向的订单为平仓单，
+// 平仓数量加上该腿上其他未成交平仓单的剩余数量不能超过持仓
+// 第二个返回值为 true 表示平仓单，按只减仓处理、不冻结保证金；返回非 0 的 code 表示校验失败
+func (s *Server) resolvePositionSide(apiKey, symbol string, side models.Side, requested string, quantity decimal.Decimal) (models.PositionSide, bool, int, string) {
+	dual, err := s.accountStore.GetDualSidePosition(apiKey)
+	if err != nil {
+		return "", false, -1000, err.Error()
+	}
+
+	positionSide := models.PositionSide(requested)
+	if !dual {
+		if positionSide == "" || positionSide == models.PositionSideBoth {
+			return models.PositionSideBoth, false, 0, ""
+		}
+		return "", false, -4061, "Order's position side does not match user's setting."
+	}
+
+	if positionSide != models.PositionSideLong && positionSide != models.PositionSideShort {
+		return "", false, -4061, "Order's position side does not match user's setting."
+	}
+
+	// 与腿反向的订单为平仓单
+	closing := (positionSide == models.PositionSideLong && side == models.SideSell) ||
+		(positionSide == models.PositionSideShort && side == models.SideBuy)
+	if !closing {
+		return positionSide, false, 0, ""
+	}
+
+	position, err := s.positionStore.Get(apiKey, symbol, positionSide)
+	if err != nil {
+		return "", false, -1000, err.Error()
+	}
+	pending, err := s.orderStore.GetOpenByPositionSide(apiKey, symbol, positionSide, side)
+	if err != nil {
+		return "", false, -1000, err.Error()
+	}
+	for _, o := range pending {
+		quantity = quantity.Add(o.Quantity.Sub(o.ExecutedQty))
+	}
+	if position == nil || quantity.GreaterThan(position.Size) {
+		return "", false, -2022, "ReduceOnly Order is rejected."
+	}
+	return positionSide, true, 0, ""
+}
+
+type MarginTypeRequest struct {
+	Symbol     string `json:"symbol" form:"symbol" binding:"required"`
+	MarginType string `json:"marginType" form:"marginType" binding:"required"`
+}
+
+// setMarginType 切换逐仓/全仓 POST /fapi/v1/marginType
+// 与币安一致，该 symbol 上有持仓或挂单时不允许切换
+func (s *Server) setMarginType(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+
+	var req MarginTypeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter was not sent, was empty/null, or malformed."})
+		return
+	}
+
+	marginType := models.MarginType(req.MarginType)
+	if marginType == "CROSS" {
+		marginType = models.MarginTypeCrossed
+	}
+	if marginType != models.MarginTypeCrossed && marginType != models.MarginTypeIsolated {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -4044, "msg": "The margin type is not supported."})
+		return
+	}
+
+	current, err := s.accountStore.GetMarginType(apiKey, req.Symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+	if current == marginType {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -4046, "msg": "No need to change margin type."})
+		return
+	}
+
+	openOrders, err := s.orderStore.CountOpenBySymbol(apiKey, req.Symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+	if openOrders > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -4047, "msg": "Margin type cannot be changed if there exists open orders."})
+		return
+	}
+
+	positions, err := s.positionStore.GetByAPIKey(apiKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+	for _, p := range positions {
+		if p.Symbol == req.Symbol {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -4048, "msg": "Margin type cannot be changed if there exists position."})
+			return
+		}
+	}
+
+	if err := s.accountStore.SetMarginType(apiKey, req.Symbol, marginType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success"})
+}
+
+type PositionMarginRequest struct {
+	Symbol       string `json:"symbol" form:"symbol" binding:"required"`
+	PositionSide string `json:"positionSide" form:"positionSide"`
+	Amount       string `json:"amount" form:"amount" binding:"required"`
+	Type         int    `json:"type" form:"type" binding:"required"` // 1: 追加保证金，2: 减少保证金
+}
+
+// adjustPositionMargin 调整逐仓保证金 POST /fapi/v1/positionMargin
+func (s *Server) adjustPositionMargin(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+
+	var req PositionMarginRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter was not sent, was empty/null, or malformed."})
+		return
+	}
+
+	amount, err := decimal.NewFromString(req.Amount)
+	if err != nil || !amount.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter 'amount' was not sent, was empty/null, or malformed."})
+		return
+	}
+	switch req.Type {
+	case 1:
+	case 2:
+		amount = amount.Neg()
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter 'type' was not sent, was empty/null, or malformed."})
+		return
+	}
+
+	positionSide := models.PositionSide(req.PositionSide)
+	if positionSide == "" {
+		positionSide = models.PositionSideBoth
+	}
+
+	position, err := s.engine.AdjustIsolatedMargin(apiKey, req.Symbol, positionSide, amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, matching.ErrPositionNotFound), errors.Is(err, matching.ErrNotIsolated):
+			c.JSON(http.StatusBadRequest, gin.H{"code": -4049, "msg": "Add margin only support for isolated position."})
+		case errors.Is(err, matching.ErrMarginInsufficient):
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2019, "msg": "Margin is insufficient."})
+		case errors.Is(err, matching.ErrIsolatedBalanceInsufficient):
+			c.JSON(http.StatusBadRequest, gin.H{"code": -4051, "msg": "Isolated balance insufficient."})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"amount": req.Amount,
+		"code":   200,
+		"msg":    "Successfully modify position margin.",
+		"type":   req.Type,
+		"margin": position.Margin,
+	})
+}
+
+// getFundingRate 资金费率历史 GET /fapi/v1/fundingRate
+func (s *Server) getFundingRate(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter 'limit'."})
+			return
+		}
+		if parsed < 1000 {
+			limit = parsed
+		} else {
+			limit = 1000
+		}
+	}
+
+	startTime, ok := parseMillis(c, "startTime")
+	if !ok {
+		return
+	}
+	endTime, ok := parseMillis(c, "endTime")
+	if !ok {
+		return
+	}
+
+	rates, err := s.fundingStore.GetRates(c.Query("symbol"), startTime, endTime, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(rates))
+	for _, r := range rates {
+		result = append(result, gin.H{
+			"symbol":      r.Symbol,
+			"fundingRate": r.FundingRate.String(),
+			"fundingTime": r.FundingTime.UnixMilli(),
+			"markPrice":   r.MarkPrice.String(),
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// getPremiumIndex 标记价格和预测资金费率 GET /fapi/v1/premiumIndex
+// 指定 symbol 时返回单个对象，否则返回所有交易对
+func (s *Server) getPremiumIndex(c *gin.Context) {
+	if s.funding == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": -1000, "msg": "Funding is not available."})
+		return
+	}
+
+	symbol := c.Query("symbol")
+	symbols := s.funding.Symbols()
+	if symbol != "" {
+		symbols = []string{symbol}
+	}
+
+	result := make([]gin.H, 0, len(symbols))
+	for _, sym := range symbols {
+		premium, err := s.funding.PremiumIndex(sym)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+			return
+		}
+		if premium == nil {
+			if symbol != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"code": -1121, "msg": "Invalid symbol."})
+				return
+			}
+			continue
+		}
+		result = append(result, gin.H{
+			"symbol":               premium.Symbol,
+			"markPrice":            premium.MarkPrice.String(),
+			"indexPrice":           premium.IndexPrice.String(),
+			"estimatedSettlePrice": premium.IndexPrice.String(),
+			"lastFundingRate":      premium.FundingRate.String(),
+			"interestRate":         premium.InterestRate.String(),
+			"nextFundingTime":      premium.NextFundingTime.UnixMilli(),
+			"time":                 premium.Time.UnixMilli(),
+		})
+	}
+
+	if symbol != "" {
+		c.JSON(http.StatusOK, result[0])
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// parseMillis 解析毫秒时间戳参数，未发送时为零值，非法时直接写入错误响应
+func parseMillis(c *gin.Context, name string) (time.Time, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, true
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter '" + name + "'."})
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
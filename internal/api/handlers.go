@@ -1,12 +1,17 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/matching"
 	"hft-sim/internal/models"
+	"hft-sim/internal/risk"
 )
 
 func (s *Server) getAccount(c *gin.Context) {
@@ -19,16 +24,18 @@ func (s *Server) getAccount(c *gin.Context) {
 	}
 
 	positions, _ := s.positionStore.GetByAPIKey(apiKey)
+	makerFee, takerFee := s.engine.FeeRates(apiKey)
 
 	c.JSON(http.StatusOK, gin.H{
-		"makerCommission":  2,
-		"takerCommission":  5,
+		// 与币安一致，单位为万分之一
+		"makerCommission":  makerFee.Shift(4).Round(0).IntPart(),
+		"takerCommission":  takerFee.Shift(4).Round(0).IntPart(),
 		"buyerCommission":  0,
 		"sellerCommission": 0,
 		"canTrade":         true,
 		"canWithdraw":      false,
 		"canDeposit":       false,
-		"updateTime":       time.Now().UnixMilli(),
+		"updateTime":       s.clock.Now().UnixMilli(),
 		"accountType":      "FUTURES",
 		"balances": []gin.H{
 			{
@@ -42,14 +49,20 @@ func (s *Server) getAccount(c *gin.Context) {
 }
 
 type CreateOrderRequest struct {
-	Symbol        string `json:"symbol" binding:"required"`
-	Side          string `json:"side" binding:"required"`
-	Type          string `json:"type" binding:"required"`
-	TimeInForce   string `json:"timeInForce"`
-	Quantity      string `json:"quantity" binding:"required"`
-	Price         string `json:"price" binding:"required"`
-	Leverage      int    `json:"leverage"`
-	ClientOrderID string `json:"newClientOrderId"`
+	Symbol          string `json:"symbol" binding:"required"`
+	Side            string `json:"side" binding:"required"`
+	Type            string `json:"type" binding:"required"`
+	TimeInForce     string `json:"timeInForce"`     // GTC（默认）/IOC/FOK/GTX，市价单忽略
+	Quantity        string `json:"quantity"`        // closePosition=true 时不能发送
+	Price           string `json:"price"`           // LIMIT/STOP/TAKE_PROFIT 必填
+	StopPrice       string `json:"stopPrice"`       // STOP/TAKE_PROFIT 系列必填
+	ActivationPrice string `json:"activationPrice"` // TRAILING_STOP_MARKET 可选，默认立即激活
+	CallbackRate    string `json:"callbackRate"`    // TRAILING_STOP_MARKET 必填，0.1~5（%）
+	ReduceOnly      string `json:"reduceOnly"`      // true/false，双向持仓模式下不能发送
+	ClosePosition   string `json:"closePosition"`   // true/false，仅 STOP_MARKET/TAKE_PROFIT_MARKET，触发后平掉全部持仓
+	Leverage        int    `json:"leverage"`
+	PositionSide    string `json:"positionSide"` // 双向持仓模式下必填 LONG/SHORT
+	ClientOrderID   string `json:"newClientOrderId"`
 }
 
 func (s *Server) createOrder(c *gin.Context) {
@@ -61,17 +74,91 @@ func (s *Server) createOrder(c *gin.Context) {
 		return
 	}
 
-	// 只支持限价单
-	if req.Type != "LIMIT" {
-		c.JSON(http.StatusBadRequest, gin.H{"code": -1106, "msg": "只支持 LIMIT 订单类型"})
+	if req.Type != models.OrderTypeLimit && req.Type != models.OrderTypeMarket && !models.IsConditional(req.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1116, "msg": "Invalid orderType."})
 		return
 	}
 
-	quantity, _ := strconv.ParseFloat(req.Quantity, 64)
-	price, _ := strconv.ParseFloat(req.Price, 64)
+	reduceOnly, err := parseBool(c, "reduceOnly", req.ReduceOnly)
+	if err != nil {
+		return
+	}
+	closePosition, err := parseBool(c, "closePosition", req.ClosePosition)
+	if err != nil {
+		return
+	}
+
+	// closePosition 订单平掉触发时的全部持仓，不能再指定数量或 reduceOnly
+	var quantity decimal.Decimal
+	if closePosition {
+		if req.Type != models.OrderTypeStopMarket && req.Type != models.OrderTypeTakeProfitMarket {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -4136, "msg": fmt.Sprintf("Target strategy invalid for orderType %s,closePosition true", req.Type)})
+			return
+		}
+		if req.Quantity != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1106, "msg": "Parameter 'quantity' sent when not required."})
+			return
+		}
+		if reduceOnly {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1106, "msg": "Parameter 'reduceOnly' sent when not required."})
+			return
+		}
+	} else if quantity, err = parseRequired(c, "quantity", req.Quantity); err != nil {
+		return
+	}
+
+	// 市价类订单忽略价格和 timeInForce，按盘口成交
+	var price, stopPrice, activationPrice, callbackRate decimal.Decimal
+	timeInForce := models.TimeInForceGTC
+	if !models.IsMarketType(req.Type) {
+		if req.TimeInForce != "" {
+			timeInForce = models.TimeInForce(req.TimeInForce)
+		}
+		switch timeInForce {
+		case models.TimeInForceGTC, models.TimeInForceIOC, models.TimeInForceFOK, models.TimeInForceGTX:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1115, "msg": "Invalid timeInForce."})
+			return
+		}
+
+		if price, err = parseRequired(c, "price", req.Price); err != nil {
+			return
+		}
+	}
+
+	switch req.Type {
+	case models.OrderTypeStop, models.OrderTypeStopMarket, models.OrderTypeTakeProfit, models.OrderTypeTakeProfitMarket:
+		if stopPrice, err = parseRequired(c, "stopPrice", req.StopPrice); err != nil {
+			return
+		}
+	case models.OrderTypeTrailingStopMarket:
+		if callbackRate, err = parseRequired(c, "callbackRate", req.CallbackRate); err != nil {
+			return
+		}
+		if callbackRate.LessThan(decimal.RequireFromString("0.1")) || callbackRate.GreaterThan(decimal.NewFromInt(5)) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2007, "msg": "Invalid callBack rate."})
+			return
+		}
+		if req.ActivationPrice != "" {
+			if activationPrice, err = parseRequired(c, "activationPrice", req.ActivationPrice); err != nil {
+				return
+			}
+		}
+	}
 	leverage := req.Leverage
 	if leverage == 0 {
-		leverage = 10 // 默认杠杆
+		leverage = s.risk.DefaultLeverage()
+	}
+
+	positionSide, closing, code, msg := s.resolvePositionSide(apiKey, req.Symbol, models.Side(req.Side), req.PositionSide, quantity)
+	if code != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": code, "msg": msg})
+		return
+	}
+	// 与币安一致，双向持仓模式下平仓单本身就只减仓，不接受 reduceOnly
+	if reduceOnly && positionSide != models.PositionSideBoth {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1106, "msg": "Parameter 'reduceOnly' sent when not required."})
+		return
 	}
 
 	order := &models.Order{
@@ -81,12 +168,45 @@ func (s *Server) createOrder(c *gin.Context) {
 		Type:          req.Type,
 		Price:         price,
 		Quantity:      quantity,
+		StopPrice:     stopPrice,
+		ActivatePrice: activationPrice,
+		PriceRate:     callbackRate,
+		ReduceOnly:    reduceOnly || closing,
+		ClosePosition: closePosition,
+		TimeInForce:   timeInForce,
 		Leverage:      leverage,
+		PositionSide:  positionSide,
 		ClientOrderID: req.ClientOrderID,
 		Status:        models.OrderStatusNew,
 	}
 
-	if err := s.orderStore.Create(order); err != nil {
+	if err := s.risk.Check(order); err != nil {
+		var rejected *risk.Error
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": rejected.Code, "msg": rejected.Msg})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	if err := s.engine.PlaceOrder(order); err != nil {
+		if errors.Is(err, matching.ErrMarginInsufficient) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2019, "msg": "Margin is insufficient."})
+			return
+		}
+		if errors.Is(err, matching.ErrNoLiquidity) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2020, "msg": "Unable to fill."})
+			return
+		}
+		if errors.Is(err, matching.ErrReduceOnlyRejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2022, "msg": "ReduceOnly Order is rejected."})
+			return
+		}
+		if errors.Is(err, matching.ErrWouldTrigger) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2021, "msg": "Order would immediately trigger."})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
 		return
 	}
@@ -94,10 +214,42 @@ func (s *Server) createOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, order)
 }
 
+// parseBool 解析 true/false 参数，未发送时为 false，非法时直接写入错误响应
+func parseBool(c *gin.Context, name, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": fmt.Sprintf("Illegal characters found in parameter '%s'.", name)})
+		return false, err
+	}
+	return v, nil
+}
+
+// parseRequired 解析必填的数值参数，缺失或非法时直接写入错误响应
+func parseRequired(c *gin.Context, name, value string) (decimal.Decimal, error) {
+	if value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": fmt.Sprintf("Mandatory parameter '%s' was not sent, was empty/null, or malformed.", name)})
+		return decimal.Zero, errors.New("missing " + name)
+	}
+	v, err := decimal.NewFromString(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": fmt.Sprintf("Illegal characters found in parameter '%s'.", name)})
+		return decimal.Zero, err
+	}
+	return v, nil
+}
+
 func (s *Server) cancelOrder(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
 	orderID, _ := strconv.ParseInt(c.Query("orderId"), 10, 64)
 
-	if err := s.orderStore.Cancel(orderID); err != nil {
+	if _, err := s.engine.CancelOrder(apiKey, orderID); err != nil {
+		if errors.Is(err, matching.ErrOrderNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -2011, "msg": "Unknown order sent."})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
 		return
 	}
@@ -125,39 +277,242 @@ func (s *Server) getOpenOrders(c *gin.Context) {
 	c.JSON(http.StatusOK, orders)
 }
 
+// getMyTrades 成交历史 GET /api/v3/myTrades，参数 symbol、fromId、limit（默认 500，最大 1000）
 func (s *Server) getMyTrades(c *gin.Context) {
-	// TODO: 实现查询成交历史
-	c.JSON(http.StatusOK, []gin.H{})
+	apiKey := c.GetString("apiKey")
+
+	limit := 500
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter 'limit'."})
+			return
+		}
+		if parsed < 1000 {
+			limit = parsed
+		} else {
+			limit = 1000
+		}
+	}
+	var fromID int64
+	if v := c.Query("fromId"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter 'fromId'."})
+			return
+		}
+		fromID = parsed
+	}
+
+	trades, err := s.orderStore.GetTrades(apiKey, c.Query("symbol"), fromID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(trades))
+	for _, t := range trades {
+		result = append(result, gin.H{
+			"symbol":          t.Symbol,
+			"id":              t.ID,
+			"orderId":         t.OrderID,
+			"orderListId":     -1,
+			"price":           t.Price.String(),
+			"qty":             t.Quantity.String(),
+			"quoteQty":        t.QuoteQty.String(),
+			"commission":      t.Fee.String(),
+			"commissionAsset": t.CommissionAsset,
+			"time":            t.Timestamp.UnixMilli(),
+			"isBuyer":         t.Side == models.SideBuy,
+			"isMaker":         t.IsMaker,
+			"isBestMatch":     true,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// incomeTypes /api/v3/income 可查询的流水类型
+var incomeTypes = map[models.LedgerType]bool{
+	models.LedgerTypeCommission:    true,
+	models.LedgerTypeRealizedPNL:   true,
+	models.LedgerTypeFundingFee:    true,
+	models.LedgerTypeMarginFreeze:  true,
+	models.LedgerTypeMarginRelease: true,
+	models.LedgerTypeDeposit:       true,
+	models.LedgerTypeAdjustment:    true,
+}
+
+// getIncome 资金流水 GET /api/v3/income，格式参考币安 /fapi/v1/income
+// income 为对钱包余额的影响，保证金划转为 0；另外返回借贷科目、金额和记账后的余额，便于逐笔核对盈亏
+func (s *Server) getIncome(c *gin.Context) {
+	apiKey := c.GetString("apiKey")
+
+	incomeType := models.LedgerType(c.Query("incomeType"))
+	if incomeType != "" && !incomeTypes[incomeType] {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter 'incomeType'."})
+		return
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter 'limit'."})
+			return
+		}
+		if parsed < 1000 {
+			limit = parsed
+		} else {
+			limit = 1000
+		}
+	}
+	startTime, ok := parseMillis(c, "startTime")
+	if !ok {
+		return
+	}
+	endTime, ok := parseMillis(c, "endTime")
+	if !ok {
+		return
+	}
+
+	entries, err := s.ledgerStore.List(apiKey, c.Query("symbol"), incomeType, startTime, endTime, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(entries))
+	for _, e := range entries {
+		tradeID := ""
+		if e.TradeID != 0 {
+			tradeID = strconv.FormatInt(e.TradeID, 10)
+		}
+		result = append(result, gin.H{
+			"symbol":         e.Symbol,
+			"incomeType":     e.Type,
+			"income":         e.Income().String(),
+			"asset":          "USDT",
+			"info":           e.Info,
+			"time":           e.Time.UnixMilli(),
+			"tranId":         e.ID,
+			"tradeId":        tradeID,
+			"debit":          e.Debit,
+			"credit":         e.Credit,
+			"amount":         e.Amount.String(),
+			"availableAfter": e.AvailableAfter.String(),
+			"frozenAfter":    e.FrozenAfter.String(),
+			"totalPnlAfter":  e.TotalPNLAfter.String(),
+		})
+	}
+	c.JSON(http.StatusOK, result)
 }
 
+// getExchangeInfo 交易规则，由 symbols 表生成
 func (s *Server) getExchangeInfo(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"symbols": []gin.H{
-			{
-				"symbol":     "BTCUSDT",
-				"status":     "TRADING",
-				"baseAsset":  "BTC",
-				"quoteAsset": "USDT",
-			},
-			{
-				"symbol":     "ETHUSDT",
-				"status":     "TRADING",
-				"baseAsset":  "ETH",
-				"quoteAsset": "USDT",
+	symbols, err := s.risk.Symbols()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1000, "msg": err.Error()})
+		return
+	}
+
+	list := make([]gin.H, 0, len(symbols))
+	for _, sym := range symbols {
+		list = append(list, gin.H{
+			"symbol":             sym.Symbol,
+			"pair":               sym.Symbol,
+			"contractType":       "PERPETUAL",
+			"status":             sym.Status,
+			"baseAsset":          sym.BaseAsset,
+			"quoteAsset":         sym.QuoteAsset,
+			"marginAsset":        sym.QuoteAsset,
+			"pricePrecision":     sym.PricePrecision,
+			"quantityPrecision":  sym.QuantityPrecision,
+			"baseAssetPrecision": sym.QuantityPrecision,
+			"quotePrecision":     sym.PricePrecision,
+			"maxLeverage":        sym.MaxLeverage,
+			"orderTypes": []string{models.OrderTypeLimit, models.OrderTypeMarket, models.OrderTypeStop, models.OrderTypeStopMarket,
+				models.OrderTypeTakeProfit, models.OrderTypeTakeProfitMarket, models.OrderTypeTrailingStopMarket},
+			"timeInForce": []models.TimeInForce{models.TimeInForceGTC, models.TimeInForceIOC, models.TimeInForceFOK, models.TimeInForceGTX},
+			"filters": []gin.H{
+				{
+					"filterType": "PRICE_FILTER",
+					"minPrice":   sym.MinPrice.String(),
+					"maxPrice":   sym.MaxPrice.String(),
+					"tickSize":   sym.TickSize.String(),
+				},
+				{
+					"filterType": "LOT_SIZE",
+					"minQty":     sym.MinQty.String(),
+					"maxQty":     sym.MaxQty.String(),
+					"stepSize":   sym.StepSize.String(),
+				},
+				{
+					// 现货格式为 minNotional，合约格式为 notional，两者都返回以兼容 CCXT
+					"filterType":  "MIN_NOTIONAL",
+					"minNotional": sym.MinNotional.String(),
+					"notional":    sym.MinNotional.String(),
+				},
 			},
-		},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timezone":   "UTC",
+		"serverTime": s.clock.Now().UnixMilli(),
+		"symbols":    list,
 	})
 }
 
+// getDepth 币安 diff-depth 流维护的真实盘口 GET /api/v3/depth
 func (s *Server) getDepth(c *gin.Context) {
-	// TODO: 返回模拟 orderbook
+	symbol := c.Query("symbol")
+	if symbol == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1102, "msg": "Mandatory parameter 'symbol' was not sent, was empty/null, or malformed."})
+		return
+	}
+
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1100, "msg": "Illegal characters found in parameter 'limit'."})
+			return
+		}
+		if parsed < 5000 {
+			limit = parsed
+		} else {
+			limit = 5000
+		}
+	}
+
+	depth, ok := s.snapshotDepth(c, symbol, limit)
+	if !ok {
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"lastUpdateId": time.Now().UnixMilli(),
-		"bids":         [][]string{},
-		"asks":         [][]string{},
+		"lastUpdateId": depth.LastUpdateID,
+		"bids":         depthLevels(depth.Bids),
+		"asks":         depthLevels(depth.Asks),
 	})
 }
 
+// snapshotDepth 读取本地盘口，不可用时直接写入错误响应
+func (s *Server) snapshotDepth(c *gin.Context, symbol string, limit int) (*collector.Depth, bool) {
+	if s.collector == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": -1000, "msg": "Depth is not available."})
+		return nil, false
+	}
+	depth, err := s.collector.Snapshot(symbol, limit)
+	if errors.Is(err, collector.ErrDepthNotSynced) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": -1000, "msg": "Depth is syncing, retry later."})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1121, "msg": "Invalid symbol."})
+		return nil, false
+	}
+	return depth, true
+}
+
 // ========== Dashboard API ==========
 
 func (s *Server) getLeaderboard(c *gin.Context) {
@@ -249,8 +604,11 @@ func (s *Server) getOrderbook(c *gin.Context) {
 		symbol = "BTCUSDT"
 	}
 
-	snapshot := s.orderbook.GetSnapshot(symbol)
-	c.JSON(http.StatusOK, snapshot)
+	depth, ok := s.snapshotDepth(c, symbol, 20)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newOrderbookSnapshot(depth))
 }
 
 // getConfig 获取系统配置
@@ -295,3 +653,23 @@ func (s *Server) getLatestTrades(c *gin.Context) {
 	trades := s.collector.GetLatestTrades()
 	c.JSON(http.StatusOK, trades)
 }
+
+// getCollectorStats 各交易对成交流的缺口、补齐和录制丢弃统计 GET /api/collector/stats
+func (s *Server) getCollectorStats(c *gin.Context) {
+	reporter, ok := s.collector.(collector.GapReporter)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gap stats not available"})
+		return
+	}
+	c.JSON(http.StatusOK, reporter.GapStats())
+}
+
+// getCollectorQueues 成交分发队列的长度、丢弃和合并计数 GET /api/collector/queues
+func (s *Server) getCollectorQueues(c *gin.Context) {
+	reporter, ok := s.collector.(collector.QueueReporter)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Queue stats not available"})
+		return
+	}
+	c.JSON(http.StatusOK, reporter.QueueStats())
+}
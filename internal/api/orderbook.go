@@ -1,12 +1,6 @@
 package api
 
-import (
-	"fmt"
-	"math"
-	"math/rand"
-	"sync"
-	"time"
-)
+import "hft-sim/internal/collector"
 
 // OrderbookLevel 订单簿档位
 type OrderbookLevel struct {
@@ -14,7 +8,7 @@ type OrderbookLevel struct {
 	Quantity string `json:"quantity"`
 }
 
-// Orderbook 订单簿快照
+// OrderbookSnapshot Dashboard 使用的订单簿快照
 type OrderbookSnapshot struct {
 	Symbol       string           `json:"symbol"`
 	LastUpdateID int64            `json:"lastUpdateId"`
@@ -22,84 +16,29 @@ type OrderbookSnapshot struct {
 	Asks         []OrderbookLevel `json:"asks"`
 }
 
-// Orderbook 模拟订单簿
-type Orderbook struct {
-	mu     sync.RWMutex
-	prices map[string]float64 // symbol -> last price
-}
-
-func NewOrderbook() *Orderbook {
-	return &Orderbook{
-		prices: map[string]float64{
-			"BTCUSDT": 67000,
-			"ETHUSDT": 3500,
-		},
-	}
-}
-
-// UpdatePrice 更新最新价格
-func (ob *Orderbook) UpdatePrice(symbol string, price float64) {
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
-	ob.prices[symbol] = price
-}
-
-// GetSnapshot 获取订单簿快照
-func (ob *Orderbook) GetSnapshot(symbol string) *OrderbookSnapshot {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
-
-	basePrice, ok := ob.prices[symbol]
-	if !ok {
-		basePrice = 67000 // 默认价格
-	}
-
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	// 生成买盘 (bids) - 价格低于当前价
-	bids := make([]OrderbookLevel, 10)
-	for i := 0; i < 10; i++ {
-		priceOffset := float64(i+1) * basePrice * 0.0001 * (0.5 + r.Float64())
-		price := basePrice - priceOffset
-		quantity := 0.1 + r.Float64()*2
-		bids[i] = OrderbookLevel{
-			Price:    formatPrice(price),
-			Quantity: formatQuantity(quantity),
-		}
-	}
-
-	// 生成卖盘 (asks) - 价格高于当前价
-	asks := make([]OrderbookLevel, 10)
-	for i := 0; i < 10; i++ {
-		priceOffset := float64(i+1) * basePrice * 0.0001 * (0.5 + r.Float64())
-		price := basePrice + priceOffset
-		quantity := 0.1 + r.Float64()*2
-		asks[i] = OrderbookLevel{
-			Price:    formatPrice(price),
-			Quantity: formatQuantity(quantity),
-		}
-	}
-
+// newOrderbookSnapshot 把 collector 维护的本地盘口转换为 Dashboard 格式
+func newOrderbookSnapshot(depth *collector.Depth) *OrderbookSnapshot {
 	return &OrderbookSnapshot{
-		Symbol:       symbol,
-		LastUpdateID: time.Now().UnixMilli(),
-		Bids:         bids,
-		Asks:         asks,
+		Symbol:       depth.Symbol,
+		LastUpdateID: depth.LastUpdateID,
+		Bids:         orderbookLevels(depth.Bids),
+		Asks:         orderbookLevels(depth.Asks),
 	}
 }
 
-func formatPrice(price float64) string {
-	if price >= 1000 {
-		return formatWithPrecision(price, 2)
+func orderbookLevels(levels []collector.DepthLevel) []OrderbookLevel {
+	result := make([]OrderbookLevel, len(levels))
+	for i, l := range levels {
+		result[i] = OrderbookLevel{Price: l.Price.String(), Quantity: l.Quantity.String()}
 	}
-	return formatWithPrecision(price, 4)
-}
-
-func formatQuantity(qty float64) string {
-	return formatWithPrecision(qty, 4)
+	return result
 }
 
-func formatWithPrecision(val float64, prec int) string {
-	p := math.Pow(10, float64(prec))
-	return fmt.Sprintf("%.*f", prec, math.Round(val*p)/p)
+// depthLevels 币安 /api/v3/depth 格式的 [price, quantity] 数组
+func depthLevels(levels []collector.DepthLevel) [][2]string {
+	result := make([][2]string, len(levels))
+	for i, l := range levels {
+		result[i] = [2]string{l.Price.String(), l.Quantity.String()}
+	}
+	return result
 }
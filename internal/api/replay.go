@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getReplayStatus 回放进度 GET /api/replay
+func (s *Server) getReplayStatus(c *gin.Context) {
+	if s.replay == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not in replay mode"})
+		return
+	}
+	c.JSON(http.StatusOK, s.replay.Status())
+}
+
+// startReplay 开始等待策略就绪的回放（回测），回放已经开始时无效果 POST /api/replay/start
+func (s *Server) startReplay(c *gin.Context) {
+	if s.replay == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not in replay mode"})
+		return
+	}
+	s.replay.Release()
+	c.JSON(http.StatusOK, s.replay.Status())
+}
+
+// stepReplay 单步模式下放行 count 个事件（默认 1，最大 10000） POST /api/replay/step
+func (s *Server) stepReplay(c *gin.Context) {
+	if s.replay == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not in replay mode"})
+		return
+	}
+
+	count := 1
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and 10000"})
+			return
+		}
+		count = n
+	}
+	s.replay.Step(count)
+	c.JSON(http.StatusOK, s.replay.Status())
+}
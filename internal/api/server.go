@@ -2,10 +2,16 @@ package api
 
 import (
 	"database/sql"
+	"net/http"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"hft-sim/internal/clock"
 	"hft-sim/internal/collector"
+	"hft-sim/internal/funding"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/replay"
+	"hft-sim/internal/risk"
 	"hft-sim/internal/store"
 )
 
@@ -15,10 +21,17 @@ type Server struct {
 	orderStore       *store.OrderStore
 	balanceStore     *store.BalanceStore
 	positionStore    *store.PositionStore
+	accountStore     *store.AccountStore
 	leaderboardStore *store.LeaderboardStore
 	snapshotStore    *store.SnapshotStore
-	orderbook        *Orderbook
-	collector        *collector.Collector
+	fundingStore     *store.FundingStore
+	ledgerStore      *store.LedgerStore
+	collector        collector.MarketSource
+	engine           *matching.Engine
+	risk             *risk.Checker
+	funding          *funding.Scheduler
+	replay           *replay.Source
+	clock            clock.Clock
 }
 
 func NewServer(db *sql.DB) *Server {
@@ -28,9 +41,13 @@ func NewServer(db *sql.DB) *Server {
 		orderStore:       store.NewOrderStore(db),
 		balanceStore:     store.NewBalanceStore(db),
 		positionStore:    store.NewPositionStore(db),
+		accountStore:     store.NewAccountStore(db),
 		leaderboardStore: store.NewLeaderboardStore(db),
 		snapshotStore:    store.NewSnapshotStore(db),
-		orderbook:        NewOrderbook(),
+		fundingStore:     store.NewFundingStore(db),
+		ledgerStore:      store.NewLedgerStore(db),
+		risk:             risk.New(db),
+		clock:            clock.Real{},
 	}
 
 	s.setupRoutes()
@@ -51,13 +68,39 @@ func (s *Server) setupRoutes() {
 		api.GET("/order", s.getOrder)
 		api.GET("/openOrders", s.getOpenOrders)
 		api.GET("/myTrades", s.getMyTrades)
+		api.GET("/income", s.getIncome)
+	}
+
+	// 合约接口 (Binance USDⓈ-M Futures 兼容)
+	fapi := s.router.Group("/fapi/v1")
+	{
+		fapi.Use(s.authMiddleware())
+
+		fapi.GET("/positionSide/dual", s.getPositionMode)
+		fapi.POST("/positionSide/dual", s.setPositionMode)
+		fapi.POST("/marginType", s.setMarginType)
+		fapi.POST("/positionMargin", s.adjustPositionMargin)
+	}
+
+	// 回放控制会推进模拟时钟，触发所有账户的资金费结算、强平和快照，需要 API Key
+	replayControl := s.router.Group("/api/replay")
+	{
+		replayControl.Use(s.authMiddleware())
+
+		replayControl.POST("/start", s.startReplay)
+		replayControl.POST("/step", s.stepReplay)
 	}
 
 	// Public endpoints
 	s.router.GET("/api/v3/exchangeInfo", s.getExchangeInfo)
 	s.router.GET("/api/v3/depth", s.getDepth)
+	s.router.GET("/fapi/v1/fundingRate", s.getFundingRate)
+	s.router.GET("/fapi/v1/premiumIndex", s.getPremiumIndex)
 	s.router.GET("/api/config", s.getConfig)
 	s.router.GET("/api/latestTrades", s.getLatestTrades)
+	s.router.GET("/api/collector/stats", s.getCollectorStats)
+	s.router.GET("/api/collector/queues", s.getCollectorQueues)
+	s.router.GET("/api/replay", s.getReplayStatus)
 
 	// Dashboard API (公开访问)
 	dashboard := s.router.Group("/api/dashboard")
@@ -95,6 +138,35 @@ func (s *Server) Run(addr string) error {
 	return s.router.Run(addr)
 }
 
-func (s *Server) SetCollector(collector *collector.Collector) {
+// Handler 返回路由，用于在自定义的 http.Server 或测试中挂载
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+func (s *Server) SetCollector(collector collector.MarketSource) {
 	s.collector = collector
 }
+
+func (s *Server) SetEngine(engine *matching.Engine) {
+	s.engine = engine
+}
+
+func (s *Server) SetFunding(scheduler *funding.Scheduler) {
+	s.funding = scheduler
+}
+
+// SetClock 设置时间来源，接口返回的时间和写入的时间戳都取自该时钟
+// 所有写入时间戳的 store 都使用该时钟；fundingStore、accountStore 不写入时间戳，没有时钟
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+	s.orderStore.SetClock(c)
+	s.balanceStore.SetClock(c)
+	s.positionStore.SetClock(c)
+	s.snapshotStore.SetClock(c)
+	s.ledgerStore.SetClock(c)
+}
+
+// SetReplay 回放模式下设置回放源，用于查询进度和手动单步
+func (s *Server) SetReplay(source *replay.Source) {
+	s.replay = source
+}
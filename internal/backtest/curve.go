@@ -0,0 +1,132 @@
+package backtest
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/liquidation"
+	"hft-sim/internal/store"
+)
+
+// Point 权益曲线上的一个采样点
+type Point struct {
+	Time   time.Time       `json:"time"`
+	Equity decimal.Decimal `json:"equity"` // 钱包余额 + 按最新成交价计算的未实现盈亏
+	PNL    decimal.Decimal `json:"pnl"`    // 相对第一个采样点（回放开始时）权益的盈亏
+}
+
+// Curve 在回放时钟上按固定间隔采样账户权益，采样在回放 goroutine 中同步执行，结果可复现
+type Curve struct {
+	apiKey        string
+	balanceStore  *store.BalanceStore
+	positionStore *store.PositionStore
+	source        collector.MarketSource
+	clock         clock.Clock
+	interval      time.Duration
+
+	mu      sync.Mutex
+	points  []Point
+	timer   clock.Timer
+	stopped bool
+}
+
+func NewCurve(db *sql.DB, apiKey string, source collector.MarketSource, c clock.Clock, interval time.Duration) *Curve {
+	return &Curve{
+		apiKey:        apiKey,
+		balanceStore:  store.NewBalanceStore(db),
+		positionStore: store.NewPositionStore(db),
+		source:        source,
+		clock:         c,
+		interval:      interval,
+	}
+}
+
+// Start 记录初始权益并开始定时采样
+func (c *Curve) Start() {
+	c.Sample()
+	c.schedule()
+}
+
+// Stop 停止定时采样并记录最后一个点
+func (c *Curve) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+
+	c.Sample()
+}
+
+// Points 已采样的权益曲线
+func (c *Curve) Points() []Point {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Point(nil), c.points...)
+}
+
+// Sample 按当前时钟时间记录一个点，与上一个点时间相同时覆盖
+func (c *Curve) Sample() {
+	equity, err := c.equity()
+	if err != nil {
+		log.Printf("Error sampling equity for %s: %v", c.apiKey, err)
+		return
+	}
+	p := Point{Time: c.clock.Now(), Equity: equity}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.points) > 0 {
+		p.PNL = equity.Sub(c.points[0].Equity)
+	}
+	if n := len(c.points); n > 0 && c.points[n-1].Time.Equal(p.Time) {
+		c.points[n-1] = p
+		return
+	}
+	c.points = append(c.points, p)
+}
+
+func (c *Curve) schedule() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.timer = c.clock.AfterFunc(c.interval, func() {
+		c.Sample()
+		c.schedule()
+	})
+}
+
+// equity 钱包余额（可用 + 冻结）加上所有持仓按最新成交价计算的未实现盈亏
+func (c *Curve) equity() (decimal.Decimal, error) {
+	balance, err := c.balanceStore.Get(c.apiKey)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	positions, err := c.positionStore.GetByAPIKey(c.apiKey)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	equity := balance.Available.Add(balance.Frozen)
+	trades := c.source.GetLatestTrades()
+	for i := range positions {
+		p := &positions[i]
+		mark := p.EntryPrice
+		if trade, ok := trades[p.Symbol]; ok {
+			if price, err := decimal.NewFromString(trade.Price); err == nil && price.IsPositive() {
+				mark = price
+			}
+		}
+		equity = equity.Add(liquidation.UnrealizedPNL(p, mark))
+	}
+	return equity, nil
+}
@@ -0,0 +1,167 @@
+package backtest
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+// maxRows 报告中读取成交和资金费记录的上限
+const maxRows = 1 << 30
+
+// Report 回测结果，金额按十进制计算，收益率、回撤比例和夏普比率为浮点数
+type Report struct {
+	APIKey         string          `json:"apiKey"`
+	Start          time.Time       `json:"start"`
+	End            time.Time       `json:"end"`
+	InitialBalance decimal.Decimal `json:"initialBalance"` // 回放开始时的权益
+	FinalEquity    decimal.Decimal `json:"finalEquity"`
+	PNL            decimal.Decimal `json:"pnl"`
+	Return         float64         `json:"return"` // PNL / 回放开始时的权益
+
+	TradeCount       int             `json:"tradeCount"`
+	MakerCount       int             `json:"makerCount"`
+	LiquidationCount int             `json:"liquidationCount"`
+	Volume           decimal.Decimal `json:"volume"`  // 成交额
+	Fees             decimal.Decimal `json:"fees"`    // 手续费合计，maker 返佣为负
+	Funding          decimal.Decimal `json:"funding"` // 资金费合计，正数为净收入
+
+	MaxDrawdown    decimal.Decimal `json:"maxDrawdown"`    // 权益从峰值回落的最大金额
+	MaxDrawdownPct float64         `json:"maxDrawdownPct"` // 相对峰值的最大回撤比例
+	Sharpe         float64         `json:"sharpe"`         // 按采样间隔收益率年化的夏普比率（无风险利率取 0）
+
+	Curve []Point        `json:"curve"`
+	Fills []models.Trade `json:"fills"`
+}
+
+// Window 回放开始前成交和资金费记录的最大 ID，报告只统计之后新增的记录
+// 克隆已有数据库回测时，账户在回放之前的成交和资金费不计入报告
+type Window struct {
+	TradeID   int64
+	PaymentID int64
+}
+
+// Mark 记录当前成交和资金费记录的最大 ID，需在回放开始前调用
+func Mark(db *sql.DB) (Window, error) {
+	var w Window
+	if err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM trades").Scan(&w.TradeID); err != nil {
+		return w, err
+	}
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM funding_payments").Scan(&w.PaymentID)
+	return w, err
+}
+
+// BuildReport 按权益曲线和账户在回放期间的成交、资金费记录生成回测报告，interval 为曲线采样间隔
+// 盈亏和收益率以曲线第一个点（回放开始时的权益）为基准
+func BuildReport(db *sql.DB, apiKey string, window Window, curve []Point, interval time.Duration) (*Report, error) {
+	fills, err := store.NewOrderStore(db).GetTrades(apiKey, "", window.TradeID+1, maxRows)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := store.NewFundingStore(db).GetPaymentsByAPIKey(apiKey, maxRows)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		APIKey:     apiKey,
+		TradeCount: len(fills),
+		Curve:      curve,
+		Fills:      fills,
+	}
+	if r.Fills == nil {
+		r.Fills = []models.Trade{}
+	}
+	if len(curve) > 0 {
+		r.Start = curve[0].Time
+		r.End = curve[len(curve)-1].Time
+		r.InitialBalance = curve[0].Equity
+		r.FinalEquity = curve[len(curve)-1].Equity
+	}
+	r.PNL = r.FinalEquity.Sub(r.InitialBalance)
+	if r.InitialBalance.IsPositive() {
+		r.Return = r.PNL.Div(r.InitialBalance).InexactFloat64()
+	}
+
+	for _, f := range fills {
+		r.Volume = r.Volume.Add(f.QuoteQty)
+		r.Fees = r.Fees.Add(f.Fee)
+		if f.IsMaker {
+			r.MakerCount++
+		}
+		if f.IsLiquidation {
+			r.LiquidationCount++
+		}
+	}
+	for _, p := range payments {
+		if p.ID > window.PaymentID {
+			r.Funding = r.Funding.Add(p.Amount)
+		}
+	}
+
+	r.MaxDrawdown, r.MaxDrawdownPct = MaxDrawdown(curve)
+	r.Sharpe = Sharpe(curve, interval)
+	return r, nil
+}
+
+// MaxDrawdown 权益曲线的最大回撤金额和相对峰值的比例
+func MaxDrawdown(curve []Point) (decimal.Decimal, float64) {
+	var (
+		peak, maxDD decimal.Decimal
+		maxPct      float64
+	)
+	for i, p := range curve {
+		if i == 0 || p.Equity.GreaterThan(peak) {
+			peak = p.Equity
+		}
+		dd := peak.Sub(p.Equity)
+		if dd.GreaterThan(maxDD) {
+			maxDD = dd
+		}
+		if peak.IsPositive() {
+			if pct := dd.Div(peak).InexactFloat64(); pct > maxPct {
+				maxPct = pct
+			}
+		}
+	}
+	return maxDD, maxPct
+}
+
+// Sharpe 按相邻采样点的收益率计算年化夏普比率，样本不足或收益率无波动时返回 0
+func Sharpe(curve []Point, interval time.Duration) float64 {
+	if len(curve) < 3 || interval <= 0 {
+		return 0
+	}
+
+	returns := make([]float64, 0, len(curve)-1)
+	for i := 1; i < len(curve); i++ {
+		prev := curve[i-1].Equity
+		if !prev.IsPositive() {
+			return 0
+		}
+		returns = append(returns, curve[i].Equity.Div(prev).InexactFloat64()-1)
+	}
+
+	mean := 0.0
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+
+	periodsPerYear := float64(365*24*time.Hour) / float64(interval)
+	return mean / std * math.Sqrt(periodsPerYear)
+}
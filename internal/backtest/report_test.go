@@ -0,0 +1,111 @@
+package backtest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/collector"
+	"hft-sim/internal/collector/collectortest"
+	"hft-sim/internal/db"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/models"
+	"hft-sim/internal/recorder"
+	"hft-sim/internal/replay"
+)
+
+// points 按权益序列生成曲线，采样间隔 1 小时
+func points(equities ...string) []Point {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	curve := make([]Point, len(equities))
+	for i, e := range equities {
+		curve[i] = Point{Time: start.Add(time.Duration(i) * time.Hour), Equity: decimal.RequireFromString(e)}
+	}
+	return curve
+}
+
+func TestMaxDrawdownAndSharpe(t *testing.T) {
+	dd, pct := MaxDrawdown(points("100", "120", "90", "110", "95"))
+	assert.Equal(t, "30", dd.String())
+	assert.InDelta(t, 0.25, pct, 1e-12)
+
+	// 收益率恒定时没有波动
+	assert.Equal(t, 0.0, Sharpe(points("100", "110", "121"), time.Hour))
+
+	assert.Greater(t, Sharpe(points("100", "101", "103", "104"), time.Hour), 0.0)
+	assert.Less(t, Sharpe(points("104", "103", "101", "100"), time.Hour), 0.0)
+}
+
+func TestBacktest_Report(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	rec := recorder.New(filepath.Join(dir, "data"))
+	rec.Start()
+	for i, price := range []string{"100", "99", "98", "101", "97"} {
+		at := start.Add(time.Duration(i+1) * time.Minute).UnixMilli()
+		rec.OnRaw(collectortest.Raw(t, collector.RawTrade, "BTCUSDT", at, collector.Trade{EventType: "trade", EventTime: at, TradeTime: at, Symbol: "BTCUSDT",
+			TradeID: int64(i), Price: price, Quantity: "10"}))
+	}
+	rec.Stop()
+
+	database, err := db.New(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('bt', 'bt', 1000)")
+	require.NoError(t, err)
+	// 克隆的账户回放前已有成交、资金费，权益也不等于建账时的初始资金
+	_, err = database.Exec("INSERT INTO balances (api_key, available) VALUES ('bt', 1500)")
+	require.NoError(t, err)
+	_, err = database.Exec(`INSERT INTO trades (order_id, api_key, symbol, side, price, quantity, quote_qty, fee)
+		VALUES (1, 'bt', 'BTCUSDT', 'BUY', '50', '2', '100', '0.05')`)
+	require.NoError(t, err)
+	_, err = database.Exec(`INSERT INTO funding_payments (api_key, symbol, position_side, position_size, mark_price, funding_rate, amount, funding_time)
+		VALUES ('bt', 'BTCUSDT', 'BOTH', '1', '50', '0.0001', '-0.005', '2024-02-29 16:00:00')`)
+	require.NoError(t, err)
+	window, err := Mark(database.DB)
+	require.NoError(t, err)
+
+	source, err := replay.New(filepath.Join(dir, "data"), []string{"BTCUSDT"}, start, time.Time{}, replay.Max)
+	require.NoError(t, err)
+	engine := matching.NewEngine(database.DB)
+	engine.SetClock(source.Clock())
+	source.AddHandler(engine.OnTrade)
+
+	// 99 的买单在第二笔成交时被撮合（排队模型，没有盘口时前方无排队）
+	require.NoError(t, engine.PlaceOrder(&models.Order{APIKey: "bt", Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: decimal.NewFromInt(99), Quantity: decimal.NewFromInt(1), Leverage: 10}))
+
+	curve := NewCurve(database.DB, "bt", source, source.Clock(), time.Minute)
+	curve.Start()
+	require.NoError(t, source.Start())
+	<-source.Done()
+	curve.Stop()
+
+	report, err := BuildReport(database.DB, "bt", window, curve.Points(), time.Minute)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, report.TradeCount)
+	assert.True(t, start.Add(2*time.Minute).Equal(report.Fills[0].Timestamp))
+	assert.Equal(t, 1, report.MakerCount)
+	assert.Equal(t, "0.0198", report.Fees.String())
+	assert.Equal(t, "99", report.Volume.String())
+	assert.True(t, report.Funding.IsZero())
+
+	// 开始时一个点，之后每分钟一个点
+	require.Len(t, report.Curve, 6)
+	assert.True(t, start.Equal(report.Start))
+	assert.True(t, start.Add(5*time.Minute).Equal(report.End))
+	// 最后价格 97，持仓亏损 2，再扣手续费；盈亏以回放开始时的权益为基准
+	assert.Equal(t, "1500", report.InitialBalance.String())
+	assert.Equal(t, "1497.9802", report.FinalEquity.String())
+	assert.Equal(t, "-2.0198", report.PNL.String())
+	assert.Equal(t, "-2.0198", report.Curve[len(report.Curve)-1].PNL.String())
+	// 定时采样在同一时刻的成交之前执行，结束时的采样覆盖 5 分钟的点，峰值为初始资金
+	assert.Equal(t, "2.0198", report.MaxDrawdown.String())
+}
@@ -0,0 +1,98 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 时间来源：实时运行时为系统时间，回放时为录制数据中的事件时间
+type Clock interface {
+	Now() time.Time
+	// AfterFunc 在 d 之后调用 f，返回的 Timer 可以取消
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer 可取消的定时任务，Stop 在任务已执行或已取消时返回 false
+type Timer interface {
+	Stop() bool
+}
+
+// Real 系统时钟
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Sim 模拟时钟，时间只在 Set 时前进，不会倒退
+// 到期的定时任务在 Set 中按到期时间顺序同步执行，执行时 Now 返回任务的到期时间，
+// 这样回放中的定时任务（如资金费结算）总在同一笔事件之前发生，结果可复现
+type Sim struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*simTimer // 按到期时间升序
+}
+
+type simTimer struct {
+	clock *Sim
+	at    time.Time
+	f     func()
+}
+
+func NewSim(start time.Time) *Sim {
+	return &Sim{now: start}
+}
+
+func (c *Sim) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Sim) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &simTimer{clock: c, at: c.now.Add(d), f: f}
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].at.After(t.at) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	return t
+}
+
+// Set 把时间推进到 t，依次执行期间到期的定时任务；t 早于当前时间时只执行已到期的任务
+func (c *Sim) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].at.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		if timer.at.After(c.now) {
+			c.now = timer.at
+		}
+		c.mu.Unlock()
+
+		timer.f()
+	}
+}
+
+func (t *simTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
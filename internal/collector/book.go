@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrDepthGap diff-depth 事件与本地盘口的 update id 不连续，需要重新拉取快照
+var ErrDepthGap = errors.New("depth update id gap")
+
+// ErrDepthNotSynced 本地盘口还没有用快照完成同步
+var ErrDepthNotSynced = errors.New("depth book not synced")
+
+// maxBufferedUpdates 快照到达前最多缓存的 diff-depth 事件数
+const maxBufferedUpdates = 1000
+
+// DepthUpdate 币安 <symbol>@depth@100ms 事件
+type DepthUpdate struct {
+	EventType     string      `json:"e"`
+	EventTime     int64       `json:"E"`
+	Symbol        string      `json:"s"`
+	FirstUpdateID int64       `json:"U"`
+	FinalUpdateID int64       `json:"u"`
+	Bids          [][2]string `json:"b"`
+	Asks          [][2]string `json:"a"`
+}
+
+// LocalBook 按币安 diff-depth 流维护的单个 symbol 本地盘口
+// 同步流程：快照到达前缓存事件；快照到达后丢弃 u <= lastUpdateId 的事件，
+// 之后每个事件的 U 必须不大于 lastUpdateId+1，否则说明丢了事件，需要重新拉取快照
+type LocalBook struct {
+	symbol string
+
+	mu           sync.RWMutex
+	bids         map[string]DepthLevel // 规范化的价格字符串 -> 档位
+	asks         map[string]DepthLevel
+	lastUpdateID int64
+	synced       bool
+	syncing      bool
+	buffer       []DepthUpdate
+}
+
+func NewLocalBook(symbol string) *LocalBook {
+	return &LocalBook{
+		symbol: symbol,
+		bids:   make(map[string]DepthLevel),
+		asks:   make(map[string]DepthLevel),
+	}
+}
+
+// Apply 应用一个 diff-depth 事件；未同步时缓存，不连续时返回 ErrDepthGap 并转为未同步
+func (b *LocalBook) Apply(update DepthUpdate) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.synced {
+		b.bufferUpdate(update)
+		return nil
+	}
+	if err := b.apply(update); err != nil {
+		b.invalidate()
+		b.bufferUpdate(update)
+		return err
+	}
+	return nil
+}
+
+// Reset 用 REST 快照重建盘口并应用缓存的事件
+// 缓存的第一个有效事件与快照不连续时返回 ErrDepthGap，需要重新拉取快照
+func (b *LocalBook) Reset(snapshot *Depth) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bids = make(map[string]DepthLevel, len(snapshot.Bids))
+	b.asks = make(map[string]DepthLevel, len(snapshot.Asks))
+	for _, l := range snapshot.Bids {
+		b.bids[l.Price.String()] = l
+	}
+	for _, l := range snapshot.Asks {
+		b.asks[l.Price.String()] = l
+	}
+	b.lastUpdateID = snapshot.LastUpdateID
+
+	buffered := b.buffer
+	b.buffer = nil
+	for i, update := range buffered {
+		if err := b.apply(update); err != nil {
+			b.buffer = buffered[i:]
+			return err
+		}
+	}
+	b.synced = true
+	return nil
+}
+
+// Invalidate 标记为未同步，等待重新拉取快照
+func (b *LocalBook) Invalidate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.invalidate()
+}
+
+// Synced 是否已经完成同步
+func (b *LocalBook) Synced() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.synced
+}
+
+// Snapshot 返回前 limit 档盘口，limit <= 0 时返回全部
+func (b *LocalBook) Snapshot(limit int) (*Depth, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.synced {
+		return nil, ErrDepthNotSynced
+	}
+	return &Depth{
+		Symbol:       b.symbol,
+		LastUpdateID: b.lastUpdateID,
+		Bids:         sortedLevels(b.bids, true, limit),
+		Asks:         sortedLevels(b.asks, false, limit),
+	}, nil
+}
+
+// startSync 标记开始拉取快照，已经在拉取时返回 false
+func (b *LocalBook) startSync() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.syncing {
+		return false
+	}
+	b.syncing = true
+	return true
+}
+
+func (b *LocalBook) endSync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.syncing = false
+}
+
+// apply 按 update id 顺序应用事件，调用方需持有 b.mu
+func (b *LocalBook) apply(update DepthUpdate) error {
+	if update.FinalUpdateID <= b.lastUpdateID {
+		return nil
+	}
+	if update.FirstUpdateID > b.lastUpdateID+1 {
+		return ErrDepthGap
+	}
+	if err := applyLevels(b.bids, update.Bids); err != nil {
+		return err
+	}
+	if err := applyLevels(b.asks, update.Asks); err != nil {
+		return err
+	}
+	b.lastUpdateID = update.FinalUpdateID
+	return nil
+}
+
+func (b *LocalBook) invalidate() {
+	b.synced = false
+	b.buffer = nil
+}
+
+// bufferUpdate 缓存未同步时收到的事件，超过上限丢弃最早的事件
+func (b *LocalBook) bufferUpdate(update DepthUpdate) {
+	if len(b.buffer) >= maxBufferedUpdates {
+		b.buffer = b.buffer[1:]
+	}
+	b.buffer = append(b.buffer, update)
+}
+
+// applyLevels 数量为 0 的档位删除，其余覆盖；"100.10" 和 "100.1" 是同一档位
+func applyLevels(levels map[string]DepthLevel, raw [][2]string) error {
+	for _, l := range raw {
+		level, err := parseLevel(l)
+		if err != nil {
+			return err
+		}
+		key := level.Price.String()
+		if level.Quantity.IsZero() {
+			delete(levels, key)
+		} else {
+			levels[key] = level
+		}
+	}
+	return nil
+}
+
+// sortedLevels 买盘按价格从高到低、卖盘从低到高排序
+func sortedLevels(levels map[string]DepthLevel, desc bool, limit int) []DepthLevel {
+	result := make([]DepthLevel, 0, len(levels))
+	for _, l := range levels {
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if desc {
+			return result[i].Price.GreaterThan(result[j].Price)
+		}
+		return result[i].Price.LessThan(result[j].Price)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// parseLevel 解析币安 [价格, 数量] 字符串档位
+func parseLevel(raw [2]string) (DepthLevel, error) {
+	price, err := decimal.NewFromString(raw[0])
+	if err != nil {
+		return DepthLevel{}, err
+	}
+	qty, err := decimal.NewFromString(raw[1])
+	if err != nil {
+		return DepthLevel{}, err
+	}
+	return DepthLevel{Price: price, Quantity: qty}, nil
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func update(first, final int64, bids, asks [][2]string) DepthUpdate {
+	return DepthUpdate{EventType: "depthUpdate", Symbol: "BTCUSDT", FirstUpdateID: first, FinalUpdateID: final, Bids: bids, Asks: asks}
+}
+
+func level(price, qty string) DepthLevel {
+	return DepthLevel{Price: decimal.RequireFromString(price), Quantity: decimal.RequireFromString(qty)}
+}
+
+func TestLocalBook_Sync(t *testing.T) {
+	book := NewLocalBook("BTCUSDT")
+
+	// 快照到达前的事件被缓存，未同步时不能读取
+	require.NoError(t, book.Apply(update(90, 100, [][2]string{{"99", "5"}}, nil)))
+	require.NoError(t, book.Apply(update(101, 110, [][2]string{{"99", "0"}, {"98", "2"}}, [][2]string{{"101", "3"}})))
+	_, err := book.Snapshot(10)
+	assert.ErrorIs(t, err, ErrDepthNotSynced)
+
+	// 快照 lastUpdateId=105：丢弃 u<=105 的事件，U<=106<=u 的事件继续应用
+	require.NoError(t, book.Reset(&Depth{Symbol: "BTCUSDT", LastUpdateID: 105,
+		Bids: []DepthLevel{level("99", "1"), level("97", "4")},
+		Asks: []DepthLevel{level("100", "1")}}))
+
+	depth, err := book.Snapshot(10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(110), depth.LastUpdateID)
+	assert.Equal(t, []DepthLevel{level("98", "2"), level("97", "4")}, depth.Bids)
+	assert.Equal(t, []DepthLevel{level("100", "1"), level("101", "3")}, depth.Asks)
+
+	depth, err = book.Snapshot(1)
+	require.NoError(t, err)
+	assert.Len(t, depth.Bids, 1)
+
+	// 连续事件正常应用
+	require.NoError(t, book.Apply(update(111, 112, nil, [][2]string{{"100", "0"}})))
+	depth, err = book.Snapshot(10)
+	require.NoError(t, err)
+	assert.Equal(t, []DepthLevel{level("101", "3")}, depth.Asks)
+
+	// 丢失事件后转为未同步，等待重新拉取快照
+	assert.ErrorIs(t, book.Apply(update(120, 125, nil, nil)), ErrDepthGap)
+	assert.False(t, book.Synced())
+}
+
+func TestLocalBook_StaleSnapshot(t *testing.T) {
+	book := NewLocalBook("BTCUSDT")
+	require.NoError(t, book.Apply(update(200, 210, [][2]string{{"99", "1"}}, nil)))
+
+	// 快照早于第一个缓存事件，无法衔接
+	assert.ErrorIs(t, book.Reset(&Depth{Symbol: "BTCUSDT", LastUpdateID: 150}), ErrDepthGap)
+	assert.False(t, book.Synced())
+
+	// 更新的快照可以衔接保留的缓存事件
+	require.NoError(t, book.Reset(&Depth{Symbol: "BTCUSDT", LastUpdateID: 205}))
+	depth, err := book.Snapshot(0)
+	require.NoError(t, err)
+	assert.Equal(t, []DepthLevel{level("99", "1")}, depth.Bids)
+}
+
+func TestCollector_SyncBookRecordsSnapshot(t *testing.T) {
+	body := `{"lastUpdateId":205,"bids":[["99","1"]],"asks":[["101","2"]]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	c := New("", []string{"BTCUSDT"})
+	c.EnableDepth(server.URL)
+	var events []RawEvent
+	c.AddRawHandler(func(event RawEvent) bool {
+		events = append(events, event)
+		return true
+	})
+
+	book := c.books["BTCUSDT"]
+	c.syncBook(book)
+
+	// 快照在应用之前以 REST 返回的原始内容交给 raw 处理函数
+	require.Len(t, events, 1)
+	assert.Equal(t, RawSnapshot, events[0].Kind)
+	assert.Equal(t, "BTCUSDT", events[0].Symbol)
+	assert.JSONEq(t, body, string(events[0].Data))
+	assert.True(t, book.Synced())
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -25,25 +26,68 @@ type Trade struct {
 	IsBuyerMM  bool   `json:"m"`
 }
 
+// 原始消息类型
+const (
+	RawTrade    = "trade"    // websocket 推送的成交
+	RawBackfill = "backfill" // 从 REST aggTrades 补齐的成交，Data 为返回数组中的一项
+	RawDepth    = "depth"    // websocket 推送的 diff-depth 事件
+	RawSnapshot = "snapshot" // 同步本地盘口时拉取的 REST 深度快照
+)
+
+// RawEvent 币安原始消息，按被使用的顺序交给 raw 处理函数：
+// 成交为去重、补齐后实际分发的成交，快照为每次同步本地盘口时拉取的快照
+type RawEvent struct {
+	Kind   string
+	Symbol string
+	Time   int64  // 事件时间（毫秒），快照为拉取时间
+	Data   []byte // 原始 JSON
+}
+
+// MarketSource 行情来源：实时连接币安的 Collector，或回放录制数据的 replay.Source
+type MarketSource interface {
+	AddHandler(handler func(Trade))
+	AddDepthHandler(handler func(DepthUpdate))
+	GetLatestTrades() map[string]Trade
+	// Depth 返回前 100 档盘口，实现 matching.DepthSource
+	Depth(symbol string) (*Depth, error)
+	Snapshot(symbol string, limit int) (*Depth, error)
+	Start() error
+	Stop()
+}
+
 type Collector struct {
-	wsURL          string
-	symbols        []string
-	conn           *websocket.Conn
-	trades         chan Trade
-	stop           chan struct{}
-	mu             sync.RWMutex
-	handlers       []func(Trade)
-	latestTrades   map[string]Trade // symbol -> latest trade
+	wsURL        string
+	symbols      []string
+	conn         *websocket.Conn
+	trades       chan tradeMessage
+	stop         chan struct{}
+	mu           sync.RWMutex
+	handlers     []func(Trade)
+	rawSubs      []func(RawEvent) bool
+	dispatcher   *Dispatcher      // 按 symbol、处理函数分开的有序队列
+	latestTrades map[string]Trade // symbol -> latest trade
+
+	// 盘口深度：订阅 <symbol>@depth@100ms 并用 REST 快照同步本地盘口
+	restURL    string
+	httpClient *http.Client
+	books      map[string]*LocalBook // symbol -> 本地盘口，未开启深度时为 nil
+	depthSubs  []func(DepthUpdate)
+
+	// 成交 ID 连续性：发现缺口时从 REST aggTrades 补齐
+	backfillURL string
+	sequences   map[string]*GapStats // symbol -> 连续性统计
 }
 
 func New(wsURL string, symbols []string) *Collector {
 	return &Collector{
 		wsURL:        wsURL,
 		symbols:      symbols,
-		trades:       make(chan Trade, 1000),
+		trades:       make(chan tradeMessage, 1000),
 		stop:         make(chan struct{}),
 		handlers:     make([]func(Trade), 0),
+		dispatcher:   NewDispatcher(DefaultQueueSize, OverflowBlock),
 		latestTrades: make(map[string]Trade),
+		sequences:    make(map[string]*GapStats),
 	}
 }
 
@@ -60,10 +104,85 @@ func (c *Collector) GetLatestTrades() map[string]Trade {
 	return result
 }
 
+// EnableDepth 开启盘口深度订阅，restURL 用于拉取同步所需的深度快照，需在 Start 之前调用
+func (c *Collector) EnableDepth(restURL string) {
+	c.restURL = strings.TrimRight(restURL, "/")
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	c.books = make(map[string]*LocalBook, len(c.symbols))
+	for _, symbol := range c.symbols {
+		symbol = strings.ToUpper(symbol)
+		c.books[symbol] = NewLocalBook(symbol)
+	}
+}
+
+// Depth 返回本地盘口前 100 档，实现 matching.DepthSource
+func (c *Collector) Depth(symbol string) (*Depth, error) {
+	return c.Snapshot(symbol, 100)
+}
+
+// Snapshot 返回本地盘口前 limit 档
+func (c *Collector) Snapshot(symbol string, limit int) (*Depth, error) {
+	book, ok := c.books[strings.ToUpper(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("no depth for %s", symbol)
+	}
+	return book.Snapshot(limit)
+}
+
+// SetDispatch 设置分发队列长度和队列满时的策略，需在 Start 之前调用
+func (c *Collector) SetDispatch(capacity int, policy OverflowPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dispatcher = NewDispatcher(capacity, policy)
+	for _, handler := range c.handlers {
+		c.dispatcher.AddHandler(handler)
+	}
+}
+
+// AddHandler 注册成交处理函数，同一 symbol 的成交按顺序逐笔交给它，前一笔处理完才会收到下一笔
 func (c *Collector) AddHandler(handler func(Trade)) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.handlers = append(c.handlers, handler)
+	c.dispatcher.AddHandler(handler)
+}
+
+// QueueStats 返回各分发队列的长度、丢弃和合并计数
+func (c *Collector) QueueStats() []QueueStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dispatcher.QueueStats()
+}
+
+// AddRawHandler 订阅原始消息（用于录制），处理函数在读取和分发中同步调用，不能阻塞
+// 处理函数无法接收时返回 false，计入该 symbol 的 rawDropped 统计
+func (c *Collector) AddRawHandler(handler func(RawEvent) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rawSubs = append(c.rawSubs, handler)
+}
+
+func (c *Collector) onRaw(event RawEvent) {
+	c.mu.RLock()
+	subs := c.rawSubs
+	c.mu.RUnlock()
+	for _, handler := range subs {
+		if handler(event) {
+			continue
+		}
+		c.mu.Lock()
+		c.gapStats(event.Symbol).RawDropped++
+		c.mu.Unlock()
+	}
+}
+
+// AddDepthHandler 订阅 diff-depth 事件，处理函数在读取循环中按到达顺序同步调用，不能阻塞
+func (c *Collector) AddDepthHandler(handler func(DepthUpdate)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.depthSubs = append(c.depthSubs, handler)
 }
 
 func (c *Collector) connect() error {
@@ -73,7 +192,10 @@ func (c *Collector) connect() error {
 		if i > 0 {
 			streams += "/"
 		}
-		streams += fmt.Sprintf("%s@trade", strings.ToLower(symbol))
+		streams += fmt.Sprintf("%s@aggTrade", strings.ToLower(symbol))
+		if c.books != nil {
+			streams += fmt.Sprintf("/%s@depth@100ms", strings.ToLower(symbol))
+		}
 	}
 
 	url := fmt.Sprintf("%s/%s", c.wsURL, streams)
@@ -94,6 +216,7 @@ func (c *Collector) Start() error {
 
 	go c.readLoop()
 	go c.dispatchLoop()
+	c.syncBooks()
 	return nil
 }
 
@@ -113,37 +236,108 @@ func (c *Collector) readLoop() {
 				continue
 			}
 
-			var trade Trade
-			if err := json.Unmarshal(message, &trade); err != nil {
+			var event struct {
+				EventType string `json:"e"`
+			}
+			if err := json.Unmarshal(message, &event); err != nil {
 				log.Printf("Unmarshal error: %v", err)
 				continue
 			}
 
-			if trade.EventType == "trade" {
+			switch event.EventType {
+			case "aggTrade", "trade":
+				var trade Trade
+				if err := json.Unmarshal(message, &trade); err != nil {
+					log.Printf("Unmarshal error: %v", err)
+					continue
+				}
+				// 不丢弃成交：分发队列满时按 dispatch_overflow 策略处理，block 时在这里等待
 				select {
-				case c.trades <- trade:
-				default:
-					log.Println("Trade channel full, dropping trade")
+				case c.trades <- tradeMessage{trade: trade, raw: message}:
+				case <-c.stop:
+					return
+				}
+			case "depthUpdate":
+				var update DepthUpdate
+				if err := json.Unmarshal(message, &update); err != nil {
+					log.Printf("Unmarshal error: %v", err)
+					continue
 				}
+				c.onRaw(RawEvent{Kind: RawDepth, Symbol: update.Symbol, Time: update.EventTime, Data: message})
+				c.onDepthUpdate(update)
 			}
 		}
 	}
 }
 
+// tradeMessage websocket 收到的成交及其原始消息
+type tradeMessage struct {
+	trade Trade
+	raw   []byte
+}
+
 func (c *Collector) dispatchLoop() {
-	for trade := range c.trades {
-		// Save latest trade for each symbol
-		c.mu.Lock()
-		c.latestTrades[trade.Symbol] = trade
-		c.mu.Unlock()
+	for m := range c.trades {
+		c.onTrade(m.trade, m.raw)
+	}
+}
 
-		c.mu.RLock()
-		handlers := c.handlers
-		c.mu.RUnlock()
+// onDepthUpdate 把 diff-depth 事件应用到本地盘口，update id 不连续时重新同步
+func (c *Collector) onDepthUpdate(update DepthUpdate) {
+	c.mu.RLock()
+	subs := c.depthSubs
+	c.mu.RUnlock()
+	for _, handler := range subs {
+		handler(update)
+	}
 
-		for _, handler := range handlers {
-			go handler(trade)
+	book, ok := c.books[update.Symbol]
+	if !ok {
+		return
+	}
+	if err := book.Apply(update); err != nil {
+		log.Printf("Depth %s out of sync: %v", update.Symbol, err)
+		go c.syncBook(book)
+	}
+}
+
+// syncBooks 为所有交易对重新拉取快照同步本地盘口
+func (c *Collector) syncBooks() {
+	for _, book := range c.books {
+		book.Invalidate()
+		go c.syncBook(book)
+	}
+}
+
+// syncBook 拉取 REST 快照并应用缓存的事件，快照早于缓存事件时稍后重试
+func (c *Collector) syncBook(book *LocalBook) {
+	if !book.startSync() {
+		return
+	}
+	defer book.endSync()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
 		}
+
+		// 等待 websocket 缓存一些事件，保证快照不会晚于第一个缓存事件太多
+		time.Sleep(time.Second)
+		snapshot, raw, err := FetchDepth(c.httpClient, c.restURL, book.symbol, 1000)
+		if err != nil {
+			log.Printf("Error fetching depth snapshot for %s: %v", book.symbol, err)
+			continue
+		}
+		// 每个拉取到的快照都先交给 raw 处理函数，回放时按同样的顺序重建盘口
+		c.onRaw(RawEvent{Kind: RawSnapshot, Symbol: book.symbol, Time: time.Now().UnixMilli(), Data: raw})
+		if err := book.Reset(snapshot); err != nil {
+			log.Printf("Depth snapshot for %s is stale, retrying: %v", book.symbol, err)
+			continue
+		}
+		log.Printf("Depth book synced for %s at update %d", book.symbol, snapshot.LastUpdateID)
+		return
 	}
 }
 
@@ -152,6 +346,7 @@ func (c *Collector) reconnect() {
 	for {
 		if err := c.connect(); err == nil {
 			log.Println("Reconnected to Binance")
+			c.syncBooks()
 			return
 		}
 		log.Println("Reconnect failed, retrying...")
@@ -161,6 +356,9 @@ func (c *Collector) reconnect() {
 
 func (c *Collector) Stop() {
 	close(c.stop)
+	c.mu.RLock()
+	c.dispatcher.Close()
+	c.mu.RUnlock()
 	if c.conn != nil {
 		c.conn.Close()
 	}
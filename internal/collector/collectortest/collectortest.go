@@ -0,0 +1,19 @@
+// Package collectortest 提供测试中构造 collector 消息的辅助函数
+package collectortest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/collector"
+)
+
+// Raw 把事件编码为 collector 收到的原始消息
+func Raw(t testing.TB, kind, symbol string, at int64, event any) collector.RawEvent {
+	t.Helper()
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+	return collector.RawEvent{Kind: kind, Symbol: symbol, Time: at, Data: data}
+}
@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// DepthLevel 盘口档位
+type DepthLevel struct {
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+}
+
+// Depth 币安盘口深度快照
+type Depth struct {
+	Symbol       string
+	LastUpdateID int64
+	Bids         []DepthLevel // 价格从高到低
+	Asks         []DepthLevel // 价格从低到高
+}
+
+// depthResponse 币安 /api/v3/depth 返回格式
+type depthResponse struct {
+	LastUpdateID int64       `json:"lastUpdateId"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+// FetchDepth 请求币安 REST 深度快照，同时返回原始响应用于录制
+func FetchDepth(client *http.Client, restURL, symbol string, limit int) (*Depth, []byte, error) {
+	url := fmt.Sprintf("%s/api/v3/depth?symbol=%s&limit=%d", restURL, strings.ToUpper(symbol), limit)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("depth snapshot %s: HTTP %d", symbol, resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	depth, err := ParseDepth(symbol, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return depth, raw, nil
+}
+
+// ParseDepth 解析币安 /api/v3/depth 返回的深度快照
+func ParseDepth(symbol string, raw []byte) (*Depth, error) {
+	var data depthResponse
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	bids, err := parseLevels(data.Bids)
+	if err != nil {
+		return nil, err
+	}
+	asks, err := parseLevels(data.Asks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Depth{
+		Symbol:       strings.ToUpper(symbol),
+		LastUpdateID: data.LastUpdateID,
+		Bids:         bids,
+		Asks:         asks,
+	}, nil
+}
+
+func parseLevels(raw [][2]string) ([]DepthLevel, error) {
+	levels := make([]DepthLevel, 0, len(raw))
+	for _, l := range raw {
+		level, err := parseLevel(l)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
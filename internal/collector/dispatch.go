@@ -0,0 +1,256 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultQueueSize 每个 symbol 每个处理函数的默认队列长度
+const DefaultQueueSize = 1000
+
+// OverflowPolicy 队列满时的处理方式
+type OverflowPolicy string
+
+const (
+	OverflowBlock      OverflowPolicy = "block"       // 阻塞分发，背压传到 websocket 读取
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // 丢弃队列中最早的成交
+	OverflowCoalesce   OverflowPolicy = "coalesce"    // 把新成交合并进队尾的成交，成交量累加，价格取最新
+)
+
+// ParseOverflowPolicy 解析 dispatch_overflow 配置，空值为 block
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case "":
+		return OverflowBlock, nil
+	case OverflowBlock, OverflowDropOldest, OverflowCoalesce:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid overflow policy %q", s)
+}
+
+// QueueStats 单个分发队列的统计
+type QueueStats struct {
+	Symbol    string `json:"symbol"`
+	Handler   int    `json:"handler"` // 处理函数按注册顺序的序号
+	Depth     int    `json:"depth"`
+	MaxDepth  int    `json:"maxDepth"`
+	Capacity  int    `json:"capacity"`
+	Delivered int64  `json:"delivered"`
+	Dropped   int64  `json:"dropped"`   // drop_oldest 丢弃的成交数
+	Coalesced int64  `json:"coalesced"` // coalesce 合并进队尾的成交数
+}
+
+// QueueReporter 提供分发队列统计
+type QueueReporter interface {
+	QueueStats() []QueueStats
+}
+
+// Dispatcher 为每个 symbol 的每个处理函数维护一个有界队列和一个 goroutine，
+// 同一处理函数按到达顺序逐笔处理同一 symbol 的成交，不同 symbol、不同处理函数之间互不阻塞
+type Dispatcher struct {
+	capacity int
+	policy   OverflowPolicy
+
+	mu       sync.Mutex
+	handlers []func(Trade)
+	queues   map[string][]*tradeQueue // symbol -> 按处理函数顺序的队列
+	closed   bool
+}
+
+func NewDispatcher(capacity int, policy OverflowPolicy) *Dispatcher {
+	if capacity <= 0 {
+		capacity = DefaultQueueSize
+	}
+	return &Dispatcher{
+		capacity: capacity,
+		policy:   policy,
+		queues:   make(map[string][]*tradeQueue),
+	}
+}
+
+// AddHandler 注册处理函数，已有的 symbol 同时为它创建队列
+func (d *Dispatcher) AddHandler(handler func(Trade)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers = append(d.handlers, handler)
+	for symbol, queues := range d.queues {
+		d.queues[symbol] = append(queues, d.newQueue(symbol, len(d.handlers)-1, handler))
+	}
+}
+
+// Dispatch 把成交放入该 symbol 所有处理函数的队列，block 策略下队列满时等待
+func (d *Dispatcher) Dispatch(trade Trade) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	queues, ok := d.queues[trade.Symbol]
+	if !ok {
+		queues = make([]*tradeQueue, 0, len(d.handlers))
+		for i, handler := range d.handlers {
+			queues = append(queues, d.newQueue(trade.Symbol, i, handler))
+		}
+		d.queues[trade.Symbol] = queues
+	}
+	d.mu.Unlock()
+
+	for _, q := range queues {
+		q.push(trade)
+	}
+}
+
+// QueueStats 按 symbol、处理函数顺序返回所有队列的统计
+func (d *Dispatcher) QueueStats() []QueueStats {
+	d.mu.Lock()
+	var queues []*tradeQueue
+	for _, qs := range d.queues {
+		queues = append(queues, qs...)
+	}
+	d.mu.Unlock()
+
+	stats := make([]QueueStats, 0, len(queues))
+	for _, q := range queues {
+		stats = append(stats, q.snapshot())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Symbol != stats[j].Symbol {
+			return stats[i].Symbol < stats[j].Symbol
+		}
+		return stats[i].Handler < stats[j].Handler
+	})
+	return stats
+}
+
+// Close 停止接收新成交，唤醒阻塞的分发；队列中剩余的成交处理完后 goroutine 退出
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	d.closed = true
+	var queues []*tradeQueue
+	for _, qs := range d.queues {
+		queues = append(queues, qs...)
+	}
+	d.mu.Unlock()
+
+	for _, q := range queues {
+		q.close()
+	}
+}
+
+func (d *Dispatcher) newQueue(symbol string, index int, handler func(Trade)) *tradeQueue {
+	q := &tradeQueue{
+		handler: handler,
+		policy:  d.policy,
+		buf:     make([]Trade, d.capacity),
+		stats:   QueueStats{Symbol: symbol, Handler: index, Capacity: d.capacity},
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// tradeQueue 环形缓冲的有界成交队列
+type tradeQueue struct {
+	handler func(Trade)
+	policy  OverflowPolicy
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	buf      []Trade
+	head     int
+	n        int
+	closed   bool
+	stats    QueueStats
+}
+
+func (q *tradeQueue) push(trade Trade) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.n == len(q.buf) {
+		switch q.policy {
+		case OverflowDropOldest:
+			q.head = (q.head + 1) % len(q.buf)
+			q.n--
+			q.stats.Dropped++
+		case OverflowCoalesce:
+			tail := &q.buf[(q.head+q.n-1)%len(q.buf)]
+			*tail = coalesce(*tail, trade)
+			q.stats.Coalesced++
+			return
+		default:
+			for q.n == len(q.buf) && !q.closed {
+				q.notFull.Wait()
+			}
+		}
+	}
+	if q.closed {
+		return
+	}
+
+	q.buf[(q.head+q.n)%len(q.buf)] = trade
+	q.n++
+	if q.n > q.stats.MaxDepth {
+		q.stats.MaxDepth = q.n
+	}
+	q.notEmpty.Signal()
+}
+
+// coalesce 把 next 合并进队尾的成交 tail：价格、成交 ID、方向和时间取 next，成交量为两者之和，
+// 按成交量推进排队位置的成交模型不会因合并少算成交量；FirstTrade 保留 tail 的，覆盖合并的全部成交
+func coalesce(tail, next Trade) Trade {
+	merged := next
+	merged.FirstTrade = tail.FirstTrade
+	a, errA := decimal.NewFromString(tail.Quantity)
+	b, errB := decimal.NewFromString(next.Quantity)
+	if errA == nil && errB == nil {
+		merged.Quantity = a.Add(b).String()
+	}
+	return merged
+}
+
+func (q *tradeQueue) run() {
+	for {
+		q.mu.Lock()
+		for q.n == 0 && !q.closed {
+			q.notEmpty.Wait()
+		}
+		if q.n == 0 {
+			q.mu.Unlock()
+			return
+		}
+		trade := q.buf[q.head]
+		q.buf[q.head] = Trade{}
+		q.head = (q.head + 1) % len(q.buf)
+		q.n--
+		q.notFull.Signal()
+		q.mu.Unlock()
+
+		q.handler(trade)
+
+		q.mu.Lock()
+		q.stats.Delivered++
+		q.mu.Unlock()
+	}
+}
+
+func (q *tradeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+func (q *tradeQueue) snapshot() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = q.n
+	return stats
+}
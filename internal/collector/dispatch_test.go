@@ -0,0 +1,175 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler 第一笔成交到达后阻塞，直到 release 被关闭，用来把队列填满
+func blockingHandler(release <-chan struct{}) (func(Trade), func() []int64) {
+	var mu sync.Mutex
+	var ids []int64
+	started := false
+	handler := func(trade Trade) {
+		mu.Lock()
+		first := !started
+		started = true
+		mu.Unlock()
+		if first {
+			<-release
+		}
+		mu.Lock()
+		ids = append(ids, trade.TradeID)
+		mu.Unlock()
+	}
+	received := func() []int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int64(nil), ids...)
+	}
+	return handler, received
+}
+
+func waitDepth(t *testing.T, d *Dispatcher, depth int) {
+	require.Eventually(t, func() bool {
+		stats := d.QueueStats()
+		return len(stats) == 1 && stats[0].Depth == depth
+	}, time.Second, time.Millisecond)
+}
+
+func TestDispatcher_Ordered(t *testing.T) {
+	d := NewDispatcher(4, OverflowBlock)
+	defer d.Close()
+
+	var mu sync.Mutex
+	got := map[string][]int64{}
+	d.AddHandler(func(trade Trade) {
+		mu.Lock()
+		got[trade.Symbol] = append(got[trade.Symbol], trade.TradeID)
+		mu.Unlock()
+	})
+
+	// block 策略下队列满时等待，不丢成交，每个 symbol 内保持顺序
+	for i := int64(1); i <= 200; i++ {
+		d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: i})
+		d.Dispatch(Trade{Symbol: "ETHUSDT", TradeID: i})
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got["BTCUSDT"]) == 200 && len(got["ETHUSDT"]) == 200
+	}, time.Second, time.Millisecond)
+
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		for i, id := range got[symbol] {
+			require.Equal(t, int64(i+1), id)
+		}
+	}
+	stats := d.QueueStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "BTCUSDT", stats[0].Symbol)
+	assert.Equal(t, int64(200), stats[0].Delivered)
+	assert.Zero(t, stats[0].Dropped)
+	assert.LessOrEqual(t, stats[0].MaxDepth, 4)
+}
+
+func TestDispatcher_DropOldest(t *testing.T) {
+	d := NewDispatcher(3, OverflowDropOldest)
+	defer d.Close()
+	release := make(chan struct{})
+	handler, received := blockingHandler(release)
+	d.AddHandler(handler)
+
+	d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: 1})
+	waitDepth(t, d, 0) // 1 已被取出，处理函数阻塞
+	for i := int64(2); i <= 7; i++ {
+		d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: i})
+	}
+	close(release)
+
+	require.Eventually(t, func() bool { return len(received()) == 4 }, time.Second, time.Millisecond)
+	assert.Equal(t, []int64{1, 5, 6, 7}, received())
+	stats := d.QueueStats()[0]
+	assert.Equal(t, int64(3), stats.Dropped)
+	assert.Equal(t, 3, stats.MaxDepth)
+}
+
+func TestDispatcher_Coalesce(t *testing.T) {
+	d := NewDispatcher(3, OverflowCoalesce)
+	defer d.Close()
+	release := make(chan struct{})
+	handler, received := blockingHandler(release)
+	var (
+		mu   sync.Mutex
+		last Trade
+	)
+	d.AddHandler(func(trade Trade) {
+		handler(trade)
+		mu.Lock()
+		last = trade
+		mu.Unlock()
+	})
+
+	d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: 1, FirstTrade: 1, Price: "100", Quantity: "1"})
+	waitDepth(t, d, 0)
+	for i := int64(2); i <= 7; i++ {
+		d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: i, FirstTrade: i, Price: fmt.Sprintf("%d", 100+i), Quantity: "0.5"})
+	}
+	close(release)
+
+	// 4 到 7 合并进队尾：价格和成交 ID 取最新，成交量累加
+	require.Eventually(t, func() bool { return len(received()) == 4 }, time.Second, time.Millisecond)
+	assert.Equal(t, []int64{1, 2, 3, 7}, received())
+	assert.Equal(t, int64(3), d.QueueStats()[0].Coalesced)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "107", last.Price)
+	assert.Equal(t, "2", last.Quantity)
+	assert.Equal(t, int64(4), last.FirstTrade)
+}
+
+func TestDispatcher_CloseUnblocks(t *testing.T) {
+	d := NewDispatcher(1, OverflowBlock)
+	release := make(chan struct{})
+	defer close(release)
+	handler, _ := blockingHandler(release)
+	d.AddHandler(handler)
+
+	d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: 1})
+	waitDepth(t, d, 0)
+	d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: 2})
+
+	done := make(chan struct{})
+	go func() {
+		d.Dispatch(Trade{Symbol: "BTCUSDT", TradeID: 3})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("dispatch should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	d.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close should unblock dispatch")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	p, err := ParseOverflowPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, OverflowBlock, p)
+	p, err = ParseOverflowPolicy("coalesce")
+	require.NoError(t, err)
+	assert.Equal(t, OverflowCoalesce, p)
+	_, err = ParseOverflowPolicy("drop")
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PremiumIndex 币安合约的标记价格和现货指数价格
+type PremiumIndex struct {
+	Symbol     string
+	MarkPrice  decimal.Decimal
+	IndexPrice decimal.Decimal
+	Time       time.Time
+}
+
+// premiumIndexResponse 币安 /fapi/v1/premiumIndex 返回格式
+type premiumIndexResponse struct {
+	Symbol     string `json:"symbol"`
+	MarkPrice  string `json:"markPrice"`
+	IndexPrice string `json:"indexPrice"`
+	Time       int64  `json:"time"`
+}
+
+// ParsePremiumIndex 解析一条 /fapi/v1/premiumIndex 格式的 JSON
+func ParsePremiumIndex(data []byte) (*PremiumIndex, error) {
+	var resp premiumIndexResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	mark, err := decimal.NewFromString(resp.MarkPrice)
+	if err != nil {
+		return nil, err
+	}
+	index, err := decimal.NewFromString(resp.IndexPrice)
+	if err != nil {
+		return nil, err
+	}
+	return &PremiumIndex{
+		Symbol:     strings.ToUpper(resp.Symbol),
+		MarkPrice:  mark,
+		IndexPrice: index,
+		Time:       time.UnixMilli(resp.Time),
+	}, nil
+}
+
+// PremiumIndexClient 通过币安合约 REST 接口获取实时溢价指数
+type PremiumIndexClient struct {
+	restURL    string
+	httpClient *http.Client
+}
+
+func NewPremiumIndexClient(restURL string) *PremiumIndexClient {
+	return &PremiumIndexClient{
+		restURL:    strings.TrimRight(restURL, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// PremiumIndex 返回 symbol 当前的溢价指数，实时数据忽略 at
+func (c *PremiumIndexClient) PremiumIndex(symbol string, at time.Time) (*PremiumIndex, error) {
+	url := fmt.Sprintf("%s/fapi/v1/premiumIndex?symbol=%s", c.restURL, strings.ToUpper(symbol))
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("premium index %s: HTTP %d", symbol, resp.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	return ParsePremiumIndex(raw)
+}
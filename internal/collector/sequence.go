@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBackfillTrades REST aggTrades 单次请求的最大条数
+const maxBackfillTrades = 1000
+
+// GapStats 单个 symbol 的成交 ID 连续性统计
+type GapStats struct {
+	LastTradeID    int64     `json:"lastTradeId"`
+	Gaps           int64     `json:"gaps"`           // 发现的缺口次数
+	MissedTrades   int64     `json:"missedTrades"`   // 缺口中缺失的成交数
+	Backfilled     int64     `json:"backfilled"`     // 通过 REST 补齐的成交数
+	BackfillErrors int64     `json:"backfillErrors"` // 补齐失败次数，失败的缺口不再补齐
+	Duplicates     int64     `json:"duplicates"`     // 重复或已补齐、被丢弃的成交数
+	RawDropped     int64     `json:"rawDropped"`     // 原始消息处理函数（录制）队列满、未能接收的消息数
+	LastGapTime    time.Time `json:"lastGapTime,omitempty"`
+}
+
+// GapReporter 提供按 symbol 的成交 ID 连续性和录制丢弃统计
+type GapReporter interface {
+	GapStats() map[string]GapStats
+}
+
+// aggTradeResponse 币安 /api/v3/aggTrades 返回格式
+type aggTradeResponse struct {
+	TradeID    int64  `json:"a"`
+	Price      string `json:"p"`
+	Quantity   string `json:"q"`
+	FirstTrade int64  `json:"f"`
+	LastTrade  int64  `json:"l"`
+	TradeTime  int64  `json:"T"`
+	IsBuyerMM  bool   `json:"m"`
+}
+
+// EnableBackfill 开启成交缺口补齐，restURL 为币安 REST 地址，需在 Start 之前调用
+func (c *Collector) EnableBackfill(restURL string) {
+	c.backfillURL = strings.TrimRight(restURL, "/")
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: 5 * time.Second}
+	}
+}
+
+// GapStats 返回各 symbol 的成交 ID 连续性统计
+func (c *Collector) GapStats() map[string]GapStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make(map[string]GapStats, len(c.sequences))
+	for symbol, stats := range c.sequences {
+		result[symbol] = *stats
+	}
+	return result
+}
+
+// gapStats 返回 symbol 的统计，不存在时创建，调用方需持有 c.mu
+func (c *Collector) gapStats(symbol string) *GapStats {
+	stats, ok := c.sequences[symbol]
+	if !ok {
+		stats = &GapStats{}
+		c.sequences[symbol] = stats
+	}
+	return stats
+}
+
+// onTrade 按归集成交 ID 检查连续性：丢弃重复的成交，发现缺口时先补齐缺失的成交再分发当前成交
+// raw 为 websocket 推送的原始消息
+func (c *Collector) onTrade(trade Trade, raw []byte) {
+	c.mu.Lock()
+	stats := c.gapStats(trade.Symbol)
+	last := stats.LastTradeID
+	gap := false
+	if trade.TradeID > 0 && last > 0 {
+		if trade.TradeID <= last {
+			stats.Duplicates++
+			c.mu.Unlock()
+			return
+		}
+		if trade.TradeID > last+1 {
+			gap = true
+			stats.Gaps++
+			stats.MissedTrades += trade.TradeID - last - 1
+			stats.LastGapTime = time.Now()
+		}
+	}
+	c.mu.Unlock()
+
+	if gap {
+		log.Printf("Trade gap for %s: missing %d..%d", trade.Symbol, last+1, trade.TradeID-1)
+		c.backfill(trade.Symbol, last+1, trade.TradeID-1)
+	}
+	c.dispatch(trade, RawEvent{Kind: RawTrade, Symbol: trade.Symbol, Time: trade.EventTime, Data: raw})
+}
+
+// backfill 从 REST aggTrades 接口按 ID 顺序补齐 [from, to] 的成交并依次分发
+func (c *Collector) backfill(symbol string, from, to int64) {
+	if c.backfillURL == "" {
+		return
+	}
+
+	for from <= to {
+		trades, raws, err := FetchAggTrades(c.httpClient, c.backfillURL, symbol, from, maxBackfillTrades)
+		if err != nil {
+			log.Printf("Error backfilling %s trades from %d: %v", symbol, from, err)
+			c.mu.Lock()
+			c.sequences[symbol].BackfillErrors++
+			c.mu.Unlock()
+			return
+		}
+		if len(trades) == 0 {
+			return
+		}
+
+		n := int64(0)
+		for i, t := range trades {
+			if t.TradeID < from {
+				continue
+			}
+			if t.TradeID > to {
+				break
+			}
+			c.dispatch(t, RawEvent{Kind: RawBackfill, Symbol: t.Symbol, Time: t.EventTime, Data: raws[i]})
+			n++
+			from = t.TradeID + 1
+		}
+		c.mu.Lock()
+		c.sequences[symbol].Backfilled += n
+		c.mu.Unlock()
+		log.Printf("Backfilled %d %s trades", n, symbol)
+
+		if n == 0 || trades[len(trades)-1].TradeID > to {
+			return
+		}
+	}
+}
+
+// dispatch 记录最新成交和最后的成交 ID，把原始消息交给 raw 处理函数，再放入分发队列，
+// 补齐的成交因此按 ID 顺序排在当前成交之前
+func (c *Collector) dispatch(trade Trade, raw RawEvent) {
+	c.mu.Lock()
+	c.latestTrades[trade.Symbol] = trade
+	if stats, ok := c.sequences[trade.Symbol]; ok && trade.TradeID > stats.LastTradeID {
+		stats.LastTradeID = trade.TradeID
+	}
+	dispatcher := c.dispatcher
+	c.mu.Unlock()
+
+	c.onRaw(raw)
+	dispatcher.Dispatch(trade)
+}
+
+// FetchAggTrades 请求币安 REST 归集成交，返回 ID 不小于 fromID 的最多 limit 条，以及每条成交的原始 JSON
+func FetchAggTrades(client *http.Client, restURL, symbol string, fromID int64, limit int) ([]Trade, []json.RawMessage, error) {
+	symbol = strings.ToUpper(symbol)
+	url := fmt.Sprintf("%s/api/v3/aggTrades?symbol=%s&fromId=%d&limit=%d", restURL, symbol, fromID, limit)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("aggTrades %s: HTTP %d", symbol, resp.StatusCode)
+	}
+
+	var raws []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raws); err != nil {
+		return nil, nil, err
+	}
+
+	trades := make([]Trade, 0, len(raws))
+	for _, raw := range raws {
+		trade, err := ParseAggTrade(symbol, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		trades = append(trades, trade)
+	}
+	return trades, raws, nil
+}
+
+// ParseAggTrade 把 REST aggTrades 返回的一条成交转为与 websocket 推送相同的 Trade，事件时间取成交时间
+func ParseAggTrade(symbol string, raw []byte) (Trade, error) {
+	var t aggTradeResponse
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return Trade{}, err
+	}
+	return Trade{
+		EventType:  "aggTrade",
+		EventTime:  t.TradeTime,
+		Symbol:     strings.ToUpper(symbol),
+		TradeID:    t.TradeID,
+		Price:      t.Price,
+		Quantity:   t.Quantity,
+		FirstTrade: t.FirstTrade,
+		LastTrade:  t.LastTrade,
+		TradeTime:  t.TradeTime,
+		IsBuyerMM:  t.IsBuyerMM,
+	}, nil
+}
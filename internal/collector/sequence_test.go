@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aggTradesStub 模拟 /api/v3/aggTrades，每页最多返回 2 条，用来覆盖分页补齐
+func aggTradesStub(t *testing.T, last int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/aggTrades", r.URL.Path)
+		assert.Equal(t, "BTCUSDT", r.URL.Query().Get("symbol"))
+		from, err := strconv.ParseInt(r.URL.Query().Get("fromId"), 10, 64)
+		require.NoError(t, err)
+
+		data := []aggTradeResponse{}
+		for id := from; id <= last && len(data) < 2; id++ {
+			data = append(data, aggTradeResponse{TradeID: id, Price: strconv.FormatInt(100+id, 10), Quantity: "1", TradeTime: id * 1000})
+		}
+		json.NewEncoder(w).Encode(data)
+	}))
+}
+
+func TestCollector_GapBackfill(t *testing.T) {
+	stub := aggTradesStub(t, 10)
+	defer stub.Close()
+
+	c := New("", []string{"BTCUSDT"})
+	c.EnableBackfill(stub.URL)
+
+	var mu sync.Mutex
+	var ids []int64
+	c.AddHandler(func(trade Trade) {
+		mu.Lock()
+		ids = append(ids, trade.TradeID)
+		mu.Unlock()
+	})
+	var kinds []string
+	c.AddRawHandler(func(event RawEvent) bool {
+		kinds = append(kinds, event.Kind)
+		return true
+	})
+
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 1, Price: "101", Quantity: "1"}, nil)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ids) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 2..5 缺失：按 ID 顺序补齐后再分发 6
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 6, Price: "106", Quantity: "1"}, nil)
+	// 重复和已补齐的成交被丢弃
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 6, Price: "106", Quantity: "1"}, nil)
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 3, Price: "103", Quantity: "1"}, nil)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ids) == 6
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []int64{1, 2, 3, 4, 5, 6}, ids)
+	mu.Unlock()
+	assert.Equal(t, "106", c.GetLatestTrades()["BTCUSDT"].Price)
+	// 原始消息只包含实际分发的成交，补齐的成交带 REST 返回的原始 JSON
+	assert.Equal(t, []string{RawTrade, RawBackfill, RawBackfill, RawBackfill, RawBackfill, RawTrade}, kinds)
+
+	stats := c.GapStats()["BTCUSDT"]
+	assert.Equal(t, int64(6), stats.LastTradeID)
+	assert.Equal(t, int64(1), stats.Gaps)
+	assert.Equal(t, int64(4), stats.MissedTrades)
+	assert.Equal(t, int64(4), stats.Backfilled)
+	assert.Equal(t, int64(2), stats.Duplicates)
+	assert.Zero(t, stats.BackfillErrors)
+	assert.False(t, stats.LastGapTime.IsZero())
+}
+
+func TestCollector_GapBackfillError(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer stub.Close()
+
+	c := New("", []string{"BTCUSDT"})
+	c.EnableBackfill(stub.URL)
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 1, Price: "101", Quantity: "1"}, nil)
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 4, Price: "104", Quantity: "1"}, nil)
+
+	// 补齐失败时仍然分发当前成交，缺口计入统计
+	stats := c.GapStats()["BTCUSDT"]
+	assert.Equal(t, int64(4), stats.LastTradeID)
+	assert.Equal(t, int64(2), stats.MissedTrades)
+	assert.Equal(t, int64(1), stats.BackfillErrors)
+	assert.Zero(t, stats.Backfilled)
+}
+
+func TestCollector_RawDropped(t *testing.T) {
+	c := New("", []string{"BTCUSDT"})
+	accepted := 0
+	c.AddRawHandler(func(event RawEvent) bool {
+		accepted++
+		return accepted <= 1
+	})
+
+	// 原始消息处理函数无法接收时不阻塞分发，丢弃计入统计
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 1, Price: "101", Quantity: "1"}, nil)
+	c.onTrade(Trade{Symbol: "BTCUSDT", TradeID: 2, Price: "102", Quantity: "1"}, nil)
+	c.onRaw(RawEvent{Kind: RawDepth, Symbol: "BTCUSDT"})
+
+	stats := c.GapStats()["BTCUSDT"]
+	assert.Equal(t, int64(2), stats.LastTradeID)
+	assert.Equal(t, int64(2), stats.RawDropped)
+}
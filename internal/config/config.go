@@ -45,15 +45,37 @@ func (c *Config) SetStringSlice(key string, value []string) error {
 // 默认配置
 func (c *Config) InitDefaults() error {
 	defaults := map[string]string{
-		"supported_symbols":       `["BTCUSDT","ETHUSDT"]`,
-		"max_leverage":            "125",
-		"default_leverage":        "10",
-		"maintenance_margin_rate": "0.005",
-		"trade_fee_maker":         "0.0002",
-		"trade_fee_taker":         "0.0005",
-		"binance_ws_url":          "wss://stream.binance.com:9443/ws",
-		"max_orders_per_api_key":  "100",
-		"order_expire_hours":      "168",
+		"supported_symbols":        `["BTCUSDT","ETHUSDT"]`,
+		"max_leverage":             "125",
+		"default_leverage":         "10",
+		"account_max_leverage":     "{}",
+		"maintenance_margin_rate":  "0.005",
+		"liquidation_price_mode":   "bankruptcy",
+		"trade_fee_maker":          "0.0002",
+		"trade_fee_taker":          "0.0005",
+		"fee_tiers":                "{}",
+		"binance_ws_url":           "wss://stream.binance.com:9443/ws",
+		"binance_rest_url":         "https://api.binance.com",
+		"max_orders_per_api_key":   "100",
+		"order_expire_hours":       "168",
+		"fill_model":               "queue",
+		"symbol_fill_models":       "{}",
+		"fill_touch_probability":   "0.5",
+		"fill_touch_seed":          "1",
+		"funding_interval_hours":   "8",
+		"funding_rate_source":      "constant",
+		"funding_rate":             "0.0001",
+		"funding_interest_rate":    "0.0001",
+		"funding_premium_file":     "",
+		"binance_futures_rest_url": "https://fapi.binance.com",
+		"recorder_dir":             "",
+		"dispatch_queue_size":      "1000",
+		"dispatch_overflow":        "block",
+		"market_source":            "live",
+		"replay_dir":               "recordings",
+		"replay_start":             "",
+		"replay_end":               "",
+		"replay_speed":             "1",
 	}
 
 	for key, value := range defaults {
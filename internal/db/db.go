@@ -2,97 +2,201 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/shopspring/decimal"
 )
 
 type DB struct {
 	*sql.DB
 }
 
+// New 打开 SQLite 数据库，写事务在 BEGIN 时即获取写锁，锁被占用时最多等待 5 秒
 func New(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
 	return &DB{db}, nil
 }
 
-func (db *DB) Migrate() error {
-	schema := `
-CREATE TABLE IF NOT EXISTS api_keys (
-    key TEXT PRIMARY KEY,
-    name TEXT NOT NULL,
-    description TEXT,
-    initial_balance DECIMAL NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS config (
-    key TEXT PRIMARY KEY,
-    value TEXT NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS orders (
+// ordersColumns 等为各表结构，重建旧表时复用；金额和数量列为 TEXT，按十进制字符串原样保存
+const ordersColumns = `(
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     api_key TEXT NOT NULL,
     symbol TEXT NOT NULL,
     side TEXT NOT NULL CHECK (side IN ('BUY', 'SELL')),
-    type TEXT NOT NULL CHECK (type = 'LIMIT'),
-    price DECIMAL NOT NULL,
-    quantity DECIMAL NOT NULL,
-    executed_qty DECIMAL DEFAULT 0,
+    type TEXT NOT NULL,
+    price TEXT NOT NULL,
+    avg_price TEXT DEFAULT '0',
+    quantity TEXT NOT NULL,
+    executed_qty TEXT DEFAULT '0',
+    stop_price TEXT DEFAULT '0',
+    activate_price TEXT DEFAULT '0',
+    price_rate TEXT DEFAULT '0',
+    trigger_time TIMESTAMP,
+    time_in_force TEXT DEFAULT 'GTC',
+    reduce_only INTEGER DEFAULT 0,
+    close_position INTEGER DEFAULT 0,
     leverage INTEGER DEFAULT 1,
-    status TEXT DEFAULT 'NEW' CHECK (status IN ('NEW', 'PARTIALLY_FILLED', 'FILLED', 'CANCELLED')),
+    position_side TEXT DEFAULT 'BOTH',
+    status TEXT DEFAULT 'NEW' CHECK (status IN ('NEW', 'PARTIALLY_FILLED', 'FILLED', 'CANCELLED', 'EXPIRED')),
     client_order_id TEXT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (api_key) REFERENCES api_keys(key)
-);
+)`
 
-CREATE INDEX IF NOT EXISTS idx_orders_api_key ON orders(api_key);
-CREATE INDEX IF NOT EXISTS idx_orders_symbol_status ON orders(symbol, status);
-
-CREATE TABLE IF NOT EXISTS trades (
+const tradesColumns = `(
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     order_id INTEGER NOT NULL,
     api_key TEXT NOT NULL,
     symbol TEXT NOT NULL,
     side TEXT NOT NULL,
-    price DECIMAL NOT NULL,
-    quantity DECIMAL NOT NULL,
-    quote_qty DECIMAL NOT NULL,
-    fee DECIMAL NOT NULL,
+    price TEXT NOT NULL,
+    quantity TEXT NOT NULL,
+    quote_qty TEXT NOT NULL,
+    fee TEXT NOT NULL,
+    commission_asset TEXT DEFAULT 'USDT',
+    is_maker INTEGER DEFAULT 0,
+    fill_model TEXT,
+    is_liquidation INTEGER DEFAULT 0,
     timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    fill_key TEXT,
     FOREIGN KEY (order_id) REFERENCES orders(id),
     FOREIGN KEY (api_key) REFERENCES api_keys(key)
-);
-
-CREATE INDEX IF NOT EXISTS idx_trades_api_key ON trades(api_key);
-CREATE INDEX IF NOT EXISTS idx_trades_symbol ON trades(symbol);
+)`
 
-CREATE TABLE IF NOT EXISTS positions (
+const positionsColumns = `(
     api_key TEXT NOT NULL,
     symbol TEXT NOT NULL,
+    position_side TEXT NOT NULL DEFAULT 'BOTH' CHECK (position_side IN ('BOTH', 'LONG', 'SHORT')),
     side TEXT NOT NULL CHECK (side IN ('LONG', 'SHORT')),
-    entry_price DECIMAL NOT NULL,
-    size DECIMAL NOT NULL,
+    entry_price TEXT NOT NULL,
+    size TEXT NOT NULL,
     leverage INTEGER NOT NULL,
-    margin DECIMAL NOT NULL,
-    unrealized_pnl DECIMAL DEFAULT 0,
+    margin_type TEXT NOT NULL DEFAULT 'CROSSED' CHECK (margin_type IN ('CROSSED', 'ISOLATED')),
+    margin TEXT NOT NULL,
+    unrealized_pnl TEXT DEFAULT '0',
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    PRIMARY KEY (api_key, symbol),
+    PRIMARY KEY (api_key, symbol, position_side),
     FOREIGN KEY (api_key) REFERENCES api_keys(key)
-);
+)`
+
+const symbolsColumns = `(
+    symbol TEXT PRIMARY KEY,
+    base_asset TEXT NOT NULL,
+    quote_asset TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'TRADING',
+    price_precision INTEGER NOT NULL,
+    quantity_precision INTEGER NOT NULL,
+    min_price TEXT NOT NULL,
+    max_price TEXT NOT NULL,
+    tick_size TEXT NOT NULL,
+    min_qty TEXT NOT NULL,
+    max_qty TEXT NOT NULL,
+    step_size TEXT NOT NULL,
+    min_notional TEXT NOT NULL,
+    max_leverage INTEGER NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
 
-CREATE TABLE IF NOT EXISTS balances (
+const balancesColumns = `(
     api_key TEXT PRIMARY KEY,
-    available DECIMAL NOT NULL,
-    frozen DECIMAL DEFAULT 0,
-    total_pnl DECIMAL DEFAULT 0,
+    available TEXT NOT NULL,
+    frozen TEXT DEFAULT '0',
+    total_pnl TEXT DEFAULT '0',
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (api_key) REFERENCES api_keys(key)
+)`
+
+const fundingRatesColumns = `(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    symbol TEXT NOT NULL,
+    funding_rate TEXT NOT NULL,
+    mark_price TEXT NOT NULL,
+    funding_time TIMESTAMP NOT NULL
+)`
+
+const fundingPaymentsColumns = `(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key TEXT NOT NULL,
+    symbol TEXT NOT NULL,
+    position_side TEXT NOT NULL,
+    position_size TEXT NOT NULL,
+    mark_price TEXT NOT NULL,
+    funding_rate TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    funding_time TIMESTAMP NOT NULL,
+    FOREIGN KEY (api_key) REFERENCES api_keys(key)
+)`
+
+const ledgerColumns = `(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key TEXT NOT NULL,
+    type TEXT NOT NULL,
+    symbol TEXT NOT NULL DEFAULT '',
+    debit_account TEXT NOT NULL CHECK (debit_account IN ('AVAILABLE', 'FROZEN', 'EXTERNAL')),
+    credit_account TEXT NOT NULL CHECK (credit_account IN ('AVAILABLE', 'FROZEN', 'EXTERNAL')),
+    amount TEXT NOT NULL,
+    available_after TEXT NOT NULL,
+    frozen_after TEXT NOT NULL,
+    total_pnl_after TEXT NOT NULL,
+    trade_id INTEGER NOT NULL DEFAULT 0,
+    info TEXT NOT NULL DEFAULT '',
+    time TIMESTAMP NOT NULL,
+    FOREIGN KEY (api_key) REFERENCES api_keys(key)
+)`
+
+func (db *DB) Migrate() error {
+	schema := `
+CREATE TABLE IF NOT EXISTS api_keys (
+    key TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    initial_balance DECIMAL NOT NULL,
+    fee_tier TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS config (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders ` + ordersColumns + `;
+
+CREATE INDEX IF NOT EXISTS idx_orders_api_key ON orders(api_key);
+CREATE INDEX IF NOT EXISTS idx_orders_symbol_status ON orders(symbol, status);
+
+CREATE TABLE IF NOT EXISTS trades ` + tradesColumns + `;
+
+CREATE INDEX IF NOT EXISTS idx_trades_api_key ON trades(api_key);
+CREATE INDEX IF NOT EXISTS idx_trades_symbol ON trades(symbol);
+
+CREATE TABLE IF NOT EXISTS positions ` + positionsColumns + `;
+
+CREATE TABLE IF NOT EXISTS account_settings (
+    api_key TEXT PRIMARY KEY,
+    dual_side_position INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (api_key) REFERENCES api_keys(key)
 );
 
+CREATE TABLE IF NOT EXISTS symbol_settings (
+    api_key TEXT NOT NULL,
+    symbol TEXT NOT NULL,
+    margin_type TEXT NOT NULL DEFAULT 'CROSSED' CHECK (margin_type IN ('CROSSED', 'ISOLATED')),
+    PRIMARY KEY (api_key, symbol),
+    FOREIGN KEY (api_key) REFERENCES api_keys(key)
+);
+
+CREATE TABLE IF NOT EXISTS symbols ` + symbolsColumns + `;
+
+CREATE TABLE IF NOT EXISTS balances ` + balancesColumns + `;
+
 CREATE TABLE IF NOT EXISTS pnl_snapshots (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     api_key TEXT NOT NULL,
@@ -105,7 +209,352 @@ CREATE TABLE IF NOT EXISTS pnl_snapshots (
 
 CREATE INDEX IF NOT EXISTS idx_pnl_snapshots_api_key ON pnl_snapshots(api_key);
 CREATE INDEX IF NOT EXISTS idx_pnl_snapshots_time ON pnl_snapshots(snapshot_at);
+
+CREATE TABLE IF NOT EXISTS funding_rates ` + fundingRatesColumns + `;
+
+CREATE TABLE IF NOT EXISTS funding_payments ` + fundingPaymentsColumns + `;
+
+CREATE INDEX IF NOT EXISTS idx_funding_payments_api_key ON funding_payments(api_key, funding_time);
+
+CREATE TABLE IF NOT EXISTS ledger ` + ledgerColumns + `;
+
+CREATE INDEX IF NOT EXISTS idx_ledger_api_key_time ON ledger(api_key, time);
 `
-	_, err := db.Exec(schema)
+	legacyPositions, err := db.renameLegacyPositions()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	if legacyPositions {
+		if err := db.copyLegacyPositions(); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range columnMigrations {
+		if err := db.addColumn(m.table, m.column, m.definition); err != nil {
+			return err
+		}
+	}
+
+	rebuilt, err := db.rebuildLegacyOrders()
+	if err != nil {
+		return err
+	}
+	for _, t := range decimalTables {
+		ok, err := db.rebuildDecimalTable(t.table, t.columns)
+		if err != nil {
+			return err
+		}
+		rebuilt = rebuilt || ok
+	}
+	if rebuilt {
+		// 旧表的索引随旧表删除，重新创建
+		if _, err := db.Exec(schema); err != nil {
+			return err
+		}
+	}
+
+	// 旧库的 fill_key 列由上面的迁移补上后才能建索引；NULL 不参与唯一性比较
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_trades_fill_key ON trades(fill_key)`); err != nil {
+		return err
+	}
+	// 每个持仓在同一结算时间只收付一次资金费，重复触发的结算由 ON CONFLICT 跳过
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_funding_payments_settlement
+		ON funding_payments(api_key, symbol, position_side, funding_time)`); err != nil {
+		return fmt.Errorf("funding_payments has duplicate settlements: %w", err)
+	}
+	if err := db.uniqueFundingRates(); err != nil {
+		return err
+	}
+	if err := db.openLedger(); err != nil {
+		return err
+	}
+	return db.seedSymbols()
+}
+
+// openLedger 为还没有任何流水的余额补记期初分录，使 balances 能由 ledger 重新计算：
+// 入金为钱包余额减去累计盈亏，累计盈亏（含手续费和资金费）整体记为一笔实现盈亏，冻结部分再划入保证金
+func (db *DB) openLedger() error {
+	rows, err := db.Query(`
+		SELECT api_key, available, frozen, total_pnl, updated_at FROM balances
+		WHERE api_key NOT IN (SELECT DISTINCT api_key FROM ledger)`)
+	if err != nil {
+		return err
+	}
+	type opening struct {
+		apiKey                 string
+		available, frozen, pnl decimal.Decimal
+		updatedAt              sql.NullTime
+	}
+	var openings []opening
+	for rows.Next() {
+		var o opening
+		if err := rows.Scan(&o.apiKey, &o.available, &o.frozen, &o.pnl, &o.updatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		openings = append(openings, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, o := range openings {
+		at := o.updatedAt.Time.UTC()
+		if !o.updatedAt.Valid {
+			at = time.Now().UTC()
+		}
+		deposit := o.available.Add(o.frozen).Sub(o.pnl)
+		steps := []struct {
+			typ, debit, credit     string
+			amount                 decimal.Decimal
+			available, frozen, pnl decimal.Decimal // 记账后的余额
+		}{
+			{"DEPOSIT", "EXTERNAL", "AVAILABLE", deposit, deposit, decimal.Zero, decimal.Zero},
+			{"REALIZED_PNL", "EXTERNAL", "AVAILABLE", o.pnl, deposit.Add(o.pnl), decimal.Zero, o.pnl},
+			{"MARGIN_FREEZE", "AVAILABLE", "FROZEN", o.frozen, o.available, o.frozen, o.pnl},
+		}
+		for _, st := range steps {
+			if st.amount.IsZero() {
+				continue
+			}
+			if st.amount.IsNegative() {
+				st.debit, st.credit, st.amount = st.credit, st.debit, st.amount.Neg()
+			}
+			_, err := tx.Exec(`
+				INSERT INTO ledger (api_key, type, debit_account, credit_account, amount,
+					available_after, frozen_after, total_pnl_after, info, time)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'opening balance', ?)`,
+				o.apiKey, st.typ, st.debit, st.credit, st.amount, st.available, st.frozen, st.pnl, at)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// seedSymbols symbols 表为空时写入默认交易对规则（参考币安 U 本位合约）
+func (db *DB) seedSymbols() error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM symbols").Scan(&count); err != nil || count > 0 {
+		return err
+	}
+
+	_, err := db.Exec(`
+INSERT INTO symbols (symbol, base_asset, quote_asset, price_precision, quantity_precision,
+    min_price, max_price, tick_size, min_qty, max_qty, step_size, min_notional, max_leverage)
+VALUES
+    ('BTCUSDT', 'BTC', 'USDT', 1, 3, 0.1, 1000000, 0.1, 0.001, 1000, 0.001, 5, 125),
+    ('ETHUSDT', 'ETH', 'USDT', 2, 3, 0.01, 100000, 0.01, 0.001, 10000, 0.001, 5, 100)`)
+	return err
+}
+
+// columnMigrations 旧数据库上需要补充的列，新库已在建表语句中包含
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"trades", "fill_model", "TEXT"},
+	{"orders", "position_side", "TEXT DEFAULT 'BOTH'"},
+	{"trades", "is_liquidation", "INTEGER DEFAULT 0"},
+	{"positions", "margin_type", "TEXT NOT NULL DEFAULT 'CROSSED'"},
+	{"orders", "avg_price", "TEXT DEFAULT '0'"},
+	{"orders", "time_in_force", "TEXT DEFAULT 'GTC'"},
+	{"api_keys", "fee_tier", "TEXT DEFAULT ''"},
+	{"trades", "commission_asset", "TEXT DEFAULT 'USDT'"},
+	{"trades", "is_maker", "INTEGER DEFAULT 0"},
+	{"orders", "stop_price", "TEXT DEFAULT '0'"},
+	{"orders", "activate_price", "TEXT DEFAULT '0'"},
+	{"orders", "price_rate", "TEXT DEFAULT '0'"},
+	{"orders", "trigger_time", "TIMESTAMP"},
+	{"orders", "reduce_only", "INTEGER DEFAULT 0"},
+	{"orders", "close_position", "INTEGER DEFAULT 0"},
+	{"trades", "fill_key", "TEXT"},
+}
+
+// uniqueFundingRates 每个 symbol 在同一结算时间只记录一次资金费率，由唯一索引代替原来的普通索引
+// 旧库中重复结算写入的费率记录只保留最早的一条
+func (db *DB) uniqueFundingRates() error {
+	_, err := db.Exec(`DELETE FROM funding_rates WHERE id NOT IN (SELECT MIN(id) FROM funding_rates GROUP BY symbol, funding_time)`)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DROP INDEX IF EXISTS idx_funding_rates_symbol_time`); err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_funding_rates_settlement ON funding_rates(symbol, funding_time)`)
+	return err
+}
+
+// addColumn 列不存在时执行 ALTER TABLE ADD COLUMN
+func (db *DB) addColumn(table, column, definition string) error {
+	ok, err := db.hasColumn(table, column)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
+
+// hasColumn 判断表中是否存在某列，表不存在时返回 false
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	columns, err := db.columns(table)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range columns {
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// columns 返回表的全部列名
+func (db *DB) columns(table string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// renameLegacyPositions 旧版 positions 表主键为 (api_key, symbol)，
+// 先改名为 positions_old，建表后再由 copyLegacyPositions 迁移数据
+func (db *DB) renameLegacyPositions() (bool, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name='positions'").Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := db.hasColumn("positions", "position_side")
+	if err != nil || ok {
+		return false, err
+	}
+
+	_, err = db.Exec("ALTER TABLE positions RENAME TO positions_old")
+	return err == nil, err
+}
+
+// copyLegacyPositions 旧数据都是单向持仓，position_side 记为 BOTH
+func (db *DB) copyLegacyPositions() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO positions (api_key, symbol, position_side, side, entry_price, size, leverage, margin, unrealized_pnl, updated_at)
+		SELECT api_key, symbol, 'BOTH', side, entry_price, size, leverage, margin, unrealized_pnl, updated_at FROM positions_old`)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DROP TABLE positions_old"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebuildLegacyOrders SQLite 不能修改 CHECK 约束，旧版 orders 表只允许 LIMIT 订单、
+// 没有 EXPIRED 状态，需要按新结构建表、复制数据后替换
+func (db *DB) rebuildLegacyOrders() (bool, error) {
+	var ddl string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name='orders'").Scan(&ddl)
+	if err != nil {
+		return false, err
+	}
+	if !strings.Contains(ddl, "type = 'LIMIT'") && strings.Contains(ddl, "'EXPIRED'") {
+		return false, nil
+	}
+	return true, db.rebuildTable("orders", ordersColumns)
+}
+
+// decimalTables 含金额和数量列的表，旧库中这些列为 DECIMAL
+var decimalTables = []struct {
+	table   string
+	columns string
+}{
+	{"orders", ordersColumns},
+	{"trades", tradesColumns},
+	{"positions", positionsColumns},
+	{"symbols", symbolsColumns},
+	{"balances", balancesColumns},
+	{"funding_rates", fundingRatesColumns},
+	{"funding_payments", fundingPaymentsColumns},
+}
+
+// rebuildDecimalTable 旧版表的金额列为 DECIMAL，SQLite 会按数值亲和性把写入的字符串转成浮点数，
+// 需要按 TEXT 列重建；旧数据中已存成浮点数的值转为其十进制表示
+func (db *DB) rebuildDecimalTable(table, columns string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE type = 'DECIMAL'", table).Scan(&count)
+	if err != nil || count == 0 {
+		return false, err
+	}
+	return true, db.rebuildTable(table, columns)
+}
+
+// rebuildTable 按 columns 新建表、复制数据后替换旧表，旧表的索引需由调用方重建
+func (db *DB) rebuildTable(table, columns string) error {
+	existing, err := db.columns(table)
+	if err != nil {
+		return err
+	}
+	list := strings.Join(existing, ", ")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 先建新表再改名，避免其他表的外键随 RENAME 指向旧表
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s_new %s", table, columns),
+		fmt.Sprintf("INSERT INTO %s_new (%s) SELECT %s FROM %s", table, list, list, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s", table, table),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
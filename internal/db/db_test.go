@@ -2,6 +2,7 @@ package db
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +30,172 @@ func TestDB_Migrate(t *testing.T) {
 		assert.Equal(t, table, name)
 	}
 }
+
+func TestDB_MigrateAddsColumns(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "old.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 旧版本的 trades 表没有 fill_model 列
+	_, err = db.Exec(`CREATE TABLE trades (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		order_id INTEGER NOT NULL,
+		api_key TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		side TEXT NOT NULL,
+		price DECIMAL NOT NULL,
+		quantity DECIMAL NOT NULL,
+		quote_qty DECIMAL NOT NULL,
+		fee DECIMAL NOT NULL,
+		timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrate())
+	// 重复迁移不应报错
+	require.NoError(t, db.Migrate())
+
+	_, err = db.Exec("SELECT fill_model FROM trades")
+	assert.NoError(t, err)
+}
+
+func TestDB_MigrateLegacyPositions(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "old.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 旧版 positions 表以 (api_key, symbol) 为主键
+	_, err = db.Exec(`CREATE TABLE positions (
+		api_key TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		side TEXT NOT NULL CHECK (side IN ('LONG', 'SHORT')),
+		entry_price DECIMAL NOT NULL,
+		size DECIMAL NOT NULL,
+		leverage INTEGER NOT NULL,
+		margin DECIMAL NOT NULL,
+		unrealized_pnl DECIMAL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (api_key, symbol)
+	)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO positions (api_key, symbol, side, entry_price, size, leverage, margin)
+		VALUES ('k', 'BTCUSDT', 'LONG', 100, 1, 10, 10)`)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrate())
+
+	var positionSide string
+	err = db.QueryRow("SELECT position_side FROM positions WHERE api_key = 'k'").Scan(&positionSide)
+	require.NoError(t, err)
+	assert.Equal(t, "BOTH", positionSide)
+
+	// 迁移后同一 symbol 可以再有一条空头腿
+	_, err = db.Exec(`INSERT INTO positions (api_key, symbol, position_side, side, entry_price, size, leverage, margin)
+		VALUES ('k', 'BTCUSDT', 'SHORT', 'SHORT', 100, 1, 10, 10)`)
+	assert.NoError(t, err)
+}
+
+func TestDB_MigrateLegacyOrders(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "old.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 旧版 orders 表只允许 LIMIT 订单
+	_, err = db.Exec(`CREATE TABLE orders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		api_key TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		side TEXT NOT NULL CHECK (side IN ('BUY', 'SELL')),
+		type TEXT NOT NULL CHECK (type = 'LIMIT'),
+		price DECIMAL NOT NULL,
+		quantity DECIMAL NOT NULL,
+		executed_qty DECIMAL DEFAULT 0,
+		leverage INTEGER DEFAULT 1,
+		status TEXT DEFAULT 'NEW' CHECK (status IN ('NEW', 'PARTIALLY_FILLED', 'FILLED', 'CANCELLED')),
+		client_order_id TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO orders (api_key, symbol, side, type, price, quantity) VALUES ('k', 'BTCUSDT', 'BUY', 'LIMIT', 100, 1)`)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrate())
+	require.NoError(t, db.Migrate())
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM orders WHERE position_side = 'BOTH'").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	_, err = db.Exec(`INSERT INTO orders (api_key, symbol, side, type, price, quantity, status) VALUES ('k', 'BTCUSDT', 'SELL', 'MARKET', 0, 1, 'EXPIRED')`)
+	assert.NoError(t, err)
+
+	var index string
+	err = db.QueryRow("SELECT name FROM sqlite_master WHERE type='index' AND name='idx_orders_symbol_status'").Scan(&index)
+	assert.NoError(t, err)
+}
+
+func TestDB_MigrateOpensLedger(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "old.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Migrate())
+
+	// 记账之前创建的账户只有余额，没有流水
+	_, err = db.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('k', 'k', 1000)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO balances (api_key, available, frozen, total_pnl) VALUES ('k', '990', '5', '-5')")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrate())
+	require.NoError(t, db.Migrate())
+
+	rows, err := db.Query("SELECT type, debit_account, credit_account, amount FROM ledger WHERE api_key = 'k' ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	var entries []string
+	for rows.Next() {
+		var typ, debit, credit, amount string
+		require.NoError(t, rows.Scan(&typ, &debit, &credit, &amount))
+		entries = append(entries, typ+" "+debit+"->"+credit+" "+amount)
+	}
+	assert.Equal(t, []string{
+		"DEPOSIT EXTERNAL->AVAILABLE 1000",
+		"REALIZED_PNL AVAILABLE->EXTERNAL 5",
+		"MARGIN_FREEZE AVAILABLE->FROZEN 5",
+	}, entries)
+
+	var available, frozen, pnl string
+	err = db.QueryRow("SELECT available_after, frozen_after, total_pnl_after FROM ledger WHERE api_key = 'k' ORDER BY id DESC LIMIT 1").
+		Scan(&available, &frozen, &pnl)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"990", "5", "-5"}, []string{available, frozen, pnl})
+}
+
+func TestDB_MigrateDedupesFundingRates(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "old.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 旧版本的 funding_rates 没有唯一索引，重试结算会写入重复的费率
+	_, err = db.Exec(`CREATE TABLE funding_rates ` + fundingRatesColumns)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO funding_rates (symbol, funding_rate, mark_price, funding_time) VALUES
+		('BTCUSDT', '0.0001', '100', '2024-01-01 08:00:00+00:00'),
+		('BTCUSDT', '0.0002', '101', '2024-01-01 08:00:00+00:00'),
+		('ETHUSDT', '0.0001', '10', '2024-01-01 08:00:00+00:00')`)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrate())
+	require.NoError(t, db.Migrate())
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM funding_rates").Scan(&count))
+	assert.Equal(t, 2, count)
+	var rate string
+	require.NoError(t, db.QueryRow("SELECT funding_rate FROM funding_rates WHERE symbol = 'BTCUSDT'").Scan(&rate))
+	assert.Equal(t, "0.0001", rate)
+
+	_, err = db.Exec(`INSERT INTO funding_rates (symbol, funding_rate, mark_price, funding_time) VALUES ('BTCUSDT', '0.0003', '102', '2024-01-01 08:00:00+00:00')`)
+	assert.Error(t, err)
+}
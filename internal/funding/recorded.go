@@ -0,0 +1,58 @@
+package funding
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"time"
+
+	"hft-sim/internal/collector"
+)
+
+// RecordedPremium 从录制文件回放溢价指数
+// 文件每行一条币安 /fapi/v1/premiumIndex 格式的 JSON，查询时取 at 之前最近的一条
+type RecordedPremium struct {
+	indexes map[string][]collector.PremiumIndex // symbol -> 按时间升序
+}
+
+// LoadRecordedPremium 读取溢价指数录制文件
+func LoadRecordedPremium(path string) (*RecordedPremium, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &RecordedPremium{indexes: make(map[string][]collector.PremiumIndex)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		index, err := collector.ParsePremiumIndex(line)
+		if err != nil {
+			return nil, err
+		}
+		r.indexes[index.Symbol] = append(r.indexes[index.Symbol], *index)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, indexes := range r.indexes {
+		sort.Slice(indexes, func(i, j int) bool { return indexes[i].Time.Before(indexes[j].Time) })
+	}
+	return r, nil
+}
+
+// PremiumIndex 返回 at 之前最近的一条记录，没有记录时返回 nil
+func (r *RecordedPremium) PremiumIndex(symbol string, at time.Time) (*collector.PremiumIndex, error) {
+	indexes := r.indexes[symbol]
+	i := sort.Search(len(indexes), func(i int) bool { return indexes[i].Time.After(at) })
+	if i == 0 {
+		return nil, nil
+	}
+	index := indexes[i-1]
+	return &index, nil
+}
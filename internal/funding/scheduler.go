@@ -0,0 +1,274 @@
+package funding
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/store"
+)
+
+// 资金费率来源
+const (
+	RateSourceConstant = "constant" // 固定费率 funding_rate
+	RateSourceLive     = "live"     // 币安合约实时溢价指数
+	RateSourceRecorded = "recorded" // 录制的溢价指数文件
+)
+
+// 币安资金费率公式中的利率差夹逼范围
+var clampRange = decimal.RequireFromString("0.0005")
+
+// PremiumSource 提供标记价格和指数价格，用于按溢价计算资金费率
+type PremiumSource interface {
+	PremiumIndex(symbol string, at time.Time) (*collector.PremiumIndex, error)
+}
+
+// Premium 当前的标记价格、指数价格和预测资金费率
+type Premium struct {
+	Symbol          string
+	MarkPrice       decimal.Decimal
+	IndexPrice      decimal.Decimal
+	FundingRate     decimal.Decimal
+	InterestRate    decimal.Decimal
+	NextFundingTime time.Time
+	Time            time.Time
+}
+
+// Scheduler 按固定间隔（默认 8 小时，对齐 UTC 00:00/08:00/16:00）结算所有持仓的资金费
+// 没有溢价指数来源时使用固定费率，标记价格取最新成交价；结算时还没有价格的 symbol 在收到第一笔行情时补结算
+type Scheduler struct {
+	engine       *matching.Engine
+	fundingStore *store.FundingStore
+	configStore  *store.ConfigStore
+	symbols      []string
+	source       PremiumSource
+	clock        clock.Clock
+
+	interval     time.Duration
+	constantRate decimal.Decimal
+	interestRate decimal.Decimal
+
+	mu      sync.Mutex
+	prices  map[string]decimal.Decimal // symbol -> 最新成交价
+	missed  map[string]time.Time       // symbol -> 因没有价格尚未结算的结算时间
+	timer   clock.Timer
+	stopped bool
+}
+
+func New(db *sql.DB, engine *matching.Engine, symbols []string) *Scheduler {
+	s := &Scheduler{
+		engine:       engine,
+		fundingStore: store.NewFundingStore(db),
+		configStore:  store.NewConfigStore(db),
+		symbols:      symbols,
+		interval:     8 * time.Hour,
+		constantRate: decimal.RequireFromString("0.0001"),
+		interestRate: decimal.RequireFromString("0.0001"),
+		clock:        clock.Real{},
+		prices:       make(map[string]decimal.Decimal),
+		missed:       make(map[string]time.Time),
+	}
+
+	if hours := s.floatConfig("funding_interval_hours", 0); hours > 0 {
+		s.interval = time.Duration(hours * float64(time.Hour))
+	}
+	s.constantRate = s.decimalConfig("funding_rate", s.constantRate)
+	s.interestRate = s.decimalConfig("funding_interest_rate", s.interestRate)
+	return s
+}
+
+// SetPremiumSource 设置溢价指数来源，设置后按溢价计算资金费率
+func (s *Scheduler) SetPremiumSource(source PremiumSource) {
+	s.source = source
+}
+
+// SetClock 设置时间来源，回放时使用模拟时钟，需在 Start 之前调用
+func (s *Scheduler) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// OnTrade 作为 collector 的行情处理函数，记录最新成交价，并补结算该 symbol 因没有价格而错过的结算
+func (s *Scheduler) OnTrade(trade collector.Trade) {
+	price, err := decimal.NewFromString(trade.Price)
+	if err != nil || !price.IsPositive() {
+		return
+	}
+	s.mu.Lock()
+	s.prices[trade.Symbol] = price
+	fundingTime, missed := s.missed[trade.Symbol]
+	delete(s.missed, trade.Symbol)
+	s.mu.Unlock()
+
+	if missed {
+		s.settle(trade.Symbol, fundingTime)
+	}
+}
+
+// Start 补结算停机期间错过的上一个结算时间，然后启动定时结算任务
+func (s *Scheduler) Start() {
+	log.Printf("Starting funding scheduler, interval %s", s.interval)
+	s.catchUp()
+	s.schedule()
+}
+
+// catchUp 上一个结算时间没有费率记录的 symbol 说明结算时服务没有运行，立即补结算
+// 费率和收付记录按结算时间去重，已经结算过的持仓不会重复收付
+func (s *Scheduler) catchUp() {
+	last := s.clock.Now().UTC().Truncate(s.interval)
+	for _, symbol := range s.symbols {
+		settled, err := s.fundingStore.HasRate(symbol, last)
+		if err != nil {
+			log.Printf("Error checking funding for %s: %v", symbol, err)
+			continue
+		}
+		if !settled {
+			log.Printf("Funding for %s at %s was missed, settling now", symbol, last.Format(time.RFC3339))
+			s.settle(symbol, last)
+		}
+	}
+}
+
+// Stop 停止定时任务
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// schedule 在下一个结算时间触发结算，结算完成后继续安排下一次
+func (s *Scheduler) schedule() {
+	now := s.clock.Now()
+	next := s.NextFundingTime(now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.timer = s.clock.AfterFunc(next.Sub(now), func() {
+		s.Settle(next)
+		s.schedule()
+	})
+}
+
+// NextFundingTime now 之后的下一个结算时间
+func (s *Scheduler) NextFundingTime(now time.Time) time.Time {
+	return now.UTC().Truncate(s.interval).Add(s.interval)
+}
+
+// Settle 在 fundingTime 结算所有 symbol 的资金费
+func (s *Scheduler) Settle(fundingTime time.Time) {
+	for _, symbol := range s.symbols {
+		s.settle(symbol, fundingTime)
+	}
+}
+
+// settle 结算一个 symbol 的资金费，还没有标记价格时等到该 symbol 的第一笔行情再结算
+func (s *Scheduler) settle(symbol string, fundingTime time.Time) {
+	premium, err := s.premium(symbol, fundingTime)
+	if err != nil {
+		log.Printf("Error getting funding rate for %s: %v", symbol, err)
+		return
+	}
+	if premium == nil {
+		log.Printf("No mark price for %s, funding deferred to the first trade", symbol)
+		s.mu.Lock()
+		s.missed[symbol] = fundingTime
+		s.mu.Unlock()
+		return
+	}
+
+	payments, err := s.engine.SettleFunding(symbol, premium.FundingRate, premium.MarkPrice, fundingTime)
+	if err != nil {
+		log.Printf("Error settling funding for %s: %v", symbol, err)
+		return
+	}
+	log.Printf("Funding settled for %s: rate=%s mark=%s positions=%d", symbol, premium.FundingRate, premium.MarkPrice, len(payments))
+}
+
+// PremiumIndex 当前的标记价格和下一次结算的预测资金费率，没有价格时返回 nil
+func (s *Scheduler) PremiumIndex(symbol string) (*Premium, error) {
+	return s.premium(symbol, s.clock.Now())
+}
+
+// Symbols 参与资金费结算的交易对
+func (s *Scheduler) Symbols() []string {
+	return s.symbols
+}
+
+// premium 按溢价指数来源或固定费率计算 at 时刻的资金费率
+func (s *Scheduler) premium(symbol string, at time.Time) (*Premium, error) {
+	p := &Premium{
+		Symbol:          symbol,
+		FundingRate:     s.constantRate,
+		InterestRate:    s.interestRate,
+		NextFundingTime: s.NextFundingTime(at),
+		Time:            at,
+	}
+
+	if s.source != nil {
+		index, err := s.source.PremiumIndex(symbol, at)
+		if err != nil {
+			return nil, err
+		}
+		if index != nil {
+			p.MarkPrice = index.MarkPrice
+			p.IndexPrice = index.IndexPrice
+			p.FundingRate = Rate(index.MarkPrice, index.IndexPrice, s.interestRate)
+			return p, nil
+		}
+	}
+
+	s.mu.Lock()
+	price, ok := s.prices[symbol]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	p.MarkPrice = price
+	p.IndexPrice = price
+	return p, nil
+}
+
+// Rate 币安资金费率公式：溢价 + clamp(利率 - 溢价, -0.05%, 0.05%)
+func Rate(markPrice, indexPrice, interestRate decimal.Decimal) decimal.Decimal {
+	if !indexPrice.IsPositive() {
+		return interestRate
+	}
+	premium := markPrice.Sub(indexPrice).Div(indexPrice)
+	diff := interestRate.Sub(premium)
+	if diff.GreaterThan(clampRange) {
+		diff = clampRange
+	} else if diff.LessThan(clampRange.Neg()) {
+		diff = clampRange.Neg()
+	}
+	return premium.Add(diff)
+}
+
+func (s *Scheduler) floatConfig(key string, def float64) float64 {
+	if v, err := s.configStore.Get(key); err == nil {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func (s *Scheduler) decimalConfig(key string, def decimal.Decimal) decimal.Decimal {
+	if v, err := s.configStore.Get(key); err == nil {
+		if d, err := decimal.NewFromString(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
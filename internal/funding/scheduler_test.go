@@ -0,0 +1,201 @@
+package funding
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/db"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestRate(t *testing.T) {
+	// 溢价在 ±0.05% 以内时费率等于利率
+	assert.Equal(t, "0.0001", Rate(dec("100.02"), dec("100"), dec("0.0001")).String())
+	// 溢价超出夹逼范围时按溢价减去 0.05%
+	assert.Equal(t, "0.0095", Rate(dec("101"), dec("100"), dec("0.0001")).String())
+	assert.Equal(t, "-0.0095", Rate(dec("99"), dec("100"), dec("0.0001")).String())
+}
+
+func TestScheduler_Settle(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	for _, key := range []string{"long", "short"} {
+		_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES (?, ?, 1000)", key, key)
+		require.NoError(t, err)
+		_, err = database.Exec("INSERT INTO balances (api_key, available, frozen) VALUES (?, 990, 10)", key)
+		require.NoError(t, err)
+	}
+	_, err = database.Exec("INSERT INTO config (key, value) VALUES ('funding_rate', '0.001')")
+	require.NoError(t, err)
+
+	positions := store.NewPositionStore(database.DB)
+	require.NoError(t, positions.Save(&models.Position{APIKey: "long", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: dec("100"), Size: dec("1"), Leverage: 10, MarginType: models.MarginTypeCrossed, Margin: dec("10")}))
+	require.NoError(t, positions.Save(&models.Position{APIKey: "short", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideShort,
+		EntryPrice: dec("100"), Size: dec("1"), Leverage: 10, MarginType: models.MarginTypeIsolated, Margin: dec("10")}))
+
+	scheduler := New(database.DB, matching.NewEngine(database.DB), []string{"BTCUSDT", "ETHUSDT"})
+	assert.Equal(t, time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC),
+		scheduler.NextFundingTime(time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)))
+
+	// 费率 0.1%，标记价格 200：多头支付 0.2，空头收取 0.2；ETHUSDT 没有价格不结算
+	scheduler.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "200", Quantity: "1"})
+	fundingTime := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	scheduler.Settle(fundingTime)
+	// 重试同一结算时间不重复记录费率，也不重复收付
+	scheduler.Settle(fundingTime)
+
+	balances := store.NewBalanceStore(database.DB)
+	long, err := balances.Get("long")
+	require.NoError(t, err)
+	assert.Equal(t, "989.8", long.Available.String())
+	assert.Equal(t, "-0.2", long.TotalPNL.String())
+
+	// 逐仓从持仓保证金收付
+	short, err := balances.Get("short")
+	require.NoError(t, err)
+	assert.Equal(t, "990", short.Available.String())
+	assert.Equal(t, "10.2", short.Frozen.String())
+	p, err := positions.Get("short", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Equal(t, "10.2", p.Margin.String())
+
+	funding := store.NewFundingStore(database.DB)
+	payments, err := funding.GetPaymentsByAPIKey("long", 10)
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, "-0.2", payments[0].Amount.String())
+	assert.True(t, payments[0].FundingTime.Equal(fundingTime))
+
+	rates, err := funding.GetRates("", time.Time{}, time.Time{}, 100)
+	require.NoError(t, err)
+	require.Len(t, rates, 1)
+	assert.Equal(t, "BTCUSDT", rates[0].Symbol)
+	assert.Equal(t, "0.001", rates[0].FundingRate.String())
+}
+
+func TestScheduler_CatchUp(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('long', 'long', 1000)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available, frozen) VALUES ('long', 990, 10)")
+	require.NoError(t, err)
+	require.NoError(t, store.NewPositionStore(database.DB).Save(&models.Position{APIKey: "long", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: dec("100"), Size: dec("1"), Leverage: 10, MarginType: models.MarginTypeCrossed, Margin: dec("10")}))
+
+	// 08:00 的结算时服务没有运行，09:30 启动时补结算；此时还没有价格，等到第一笔行情
+	clk := clock.NewSim(time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC))
+	scheduler := New(database.DB, matching.NewEngine(database.DB), []string{"BTCUSDT"})
+	scheduler.SetClock(clk)
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	funding := store.NewFundingStore(database.DB)
+	rates, err := funding.GetRates("BTCUSDT", time.Time{}, time.Time{}, 100)
+	require.NoError(t, err)
+	assert.Empty(t, rates)
+
+	scheduler.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "100", Quantity: "1"})
+	scheduler.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "100", Quantity: "1"})
+	rates, err = funding.GetRates("BTCUSDT", time.Time{}, time.Time{}, 100)
+	require.NoError(t, err)
+	require.Len(t, rates, 1)
+	assert.True(t, rates[0].FundingTime.Equal(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)))
+	payments, err := funding.GetPaymentsByAPIKey("long", 10)
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, "-0.01", payments[0].Amount.String())
+
+	// 已结算过的结算时间再次启动时不补结算
+	restarted := New(database.DB, matching.NewEngine(database.DB), []string{"BTCUSDT"})
+	restarted.SetClock(clk)
+	restarted.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "100", Quantity: "1"})
+	restarted.Start()
+	defer restarted.Stop()
+	payments, err = funding.GetPaymentsByAPIKey("long", 10)
+	require.NoError(t, err)
+	assert.Len(t, payments, 1)
+}
+
+func TestScheduler_RateRollback(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('long', 'long', 1000)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available, frozen) VALUES ('long', 990, 10)")
+	require.NoError(t, err)
+	require.NoError(t, store.NewPositionStore(database.DB).Save(&models.Position{APIKey: "long", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: dec("100"), Size: dec("1"), Leverage: 10, MarginType: models.MarginTypeCrossed, Margin: dec("10")}))
+
+	// 收付记账失败时费率记录随结算一起回滚，之后可以重新结算
+	_, err = database.Exec(`CREATE TRIGGER fail_ledger BEFORE INSERT ON ledger BEGIN SELECT RAISE(ABORT, 'boom'); END`)
+	require.NoError(t, err)
+	scheduler := New(database.DB, matching.NewEngine(database.DB), []string{"BTCUSDT"})
+	scheduler.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "100", Quantity: "1"})
+	fundingTime := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	scheduler.Settle(fundingTime)
+
+	funding := store.NewFundingStore(database.DB)
+	settled, err := funding.HasRate("BTCUSDT", fundingTime)
+	require.NoError(t, err)
+	assert.False(t, settled)
+
+	_, err = database.Exec(`DROP TRIGGER fail_ledger`)
+	require.NoError(t, err)
+	scheduler.Settle(fundingTime)
+	settled, err = funding.HasRate("BTCUSDT", fundingTime)
+	require.NoError(t, err)
+	assert.True(t, settled)
+}
+
+func TestRecordedPremium(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "premium.ndjson")
+	data := `{"symbol":"BTCUSDT","markPrice":"101","indexPrice":"100","time":2000}
+{"symbol":"BTCUSDT","markPrice":"100.5","indexPrice":"100","time":1000}
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+	recorded, err := LoadRecordedPremium(path)
+	require.NoError(t, err)
+
+	index, err := recorded.PremiumIndex("BTCUSDT", time.UnixMilli(500))
+	require.NoError(t, err)
+	assert.Nil(t, index)
+
+	index, err = recorded.PremiumIndex("BTCUSDT", time.UnixMilli(1500))
+	require.NoError(t, err)
+	require.NotNil(t, index)
+	assert.Equal(t, "100.5", index.MarkPrice.String())
+
+	index, err = recorded.PremiumIndex("BTCUSDT", time.UnixMilli(3000))
+	require.NoError(t, err)
+	assert.Equal(t, "101", index.MarkPrice.String())
+}
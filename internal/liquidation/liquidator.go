@@ -0,0 +1,329 @@
+package liquidation
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+// 强平价格取值方式
+const (
+	PriceModeMark       = "mark"       // 按最新成交价平仓
+	PriceModeBankruptcy = "bankruptcy" // 按破产价平仓，亏损恰好等于保证金
+)
+
+// pnlSaveInterval 未实现盈亏写回数据库的最小间隔，避免每笔行情都写库
+const pnlSaveInterval = time.Second
+
+// refreshInterval 持仓和余额快照从数据库重新读取的最小间隔
+// 每笔行情只在内存中按最新价重新计算，新的持仓变动最迟一个间隔后生效；强平前 Engine.Liquidate 会按最新持仓再确认
+const refreshInterval = time.Second
+
+// Liquidator 按最新成交价重新计算持仓盈亏和保证金率，跌破维持保证金时强平
+// 逐仓持仓单独判断；全仓持仓按账户汇总判断，所有全仓持仓共享钱包余额
+// 持仓和余额使用内存快照，每笔行情不读数据库
+type Liquidator struct {
+	engine        *matching.Engine
+	positionStore *store.PositionStore
+	balanceStore  *store.BalanceStore
+	configStore   *store.ConfigStore
+	clock         clock.Clock
+
+	maintenanceMarginRate decimal.Decimal
+	priceMode             string
+
+	mu        sync.Mutex
+	prices    map[string]decimal.Decimal   // symbol -> 最新成交价
+	lastSaved map[string]time.Time         // symbol -> 上次写回未实现盈亏的时间
+	positions map[string][]models.Position // symbol -> 持仓快照
+	available map[string]decimal.Decimal   // apiKey -> 可用余额快照，只包含有全仓持仓的账户
+	loadedAt  time.Time                    // 快照读取时间，为零时下一笔行情重新读取
+}
+
+func New(db *sql.DB, engine *matching.Engine) *Liquidator {
+	l := &Liquidator{
+		engine:                engine,
+		positionStore:         store.NewPositionStore(db),
+		balanceStore:          store.NewBalanceStore(db),
+		configStore:           store.NewConfigStore(db),
+		clock:                 clock.Real{},
+		maintenanceMarginRate: decimal.RequireFromString("0.005"),
+		priceMode:             PriceModeBankruptcy,
+		prices:                make(map[string]decimal.Decimal),
+		lastSaved:             make(map[string]time.Time),
+	}
+
+	if v, err := l.configStore.Get("maintenance_margin_rate"); err == nil {
+		if rate, err := decimal.NewFromString(v); err == nil {
+			l.maintenanceMarginRate = rate
+		}
+	}
+	if v, err := l.configStore.Get("liquidation_price_mode"); err == nil && v != "" {
+		l.priceMode = v
+	}
+	return l
+}
+
+// SetClock 设置时间来源，回放时使用模拟时钟
+func (l *Liquidator) SetClock(c clock.Clock) {
+	l.clock = c
+	l.positionStore.SetClock(c)
+	l.balanceStore.SetClock(c)
+}
+
+// OnTrade 作为 collector 的行情处理函数
+func (l *Liquidator) OnTrade(trade collector.Trade) {
+	price, err := decimal.NewFromString(trade.Price)
+	if err != nil || !price.IsPositive() {
+		return
+	}
+
+	l.mu.Lock()
+	l.prices[trade.Symbol] = price
+	if err := l.refresh(); err != nil {
+		log.Printf("Error loading positions: %v", err)
+	}
+	positions := append([]models.Position(nil), l.positions[trade.Symbol]...)
+	l.mu.Unlock()
+
+	save := l.shouldSave(trade.Symbol)
+	crossAccounts := make(map[string]bool)
+	for i := range positions {
+		p := &positions[i]
+		p.UnrealizedPNL = UnrealizedPNL(p, price)
+
+		if p.MarginType == models.MarginTypeIsolated && Breached(p, price, l.maintenanceMarginRate) {
+			log.Printf("Maintenance margin breached: %s %s %s margin=%s upnl=%s",
+				p.APIKey, p.Symbol, p.PositionSide, p.Margin, p.UnrealizedPNL)
+			if err := l.engine.Liquidate(p, l.isolatedCheck(price)); err != nil {
+				log.Printf("Error liquidating %s %s: %v", p.APIKey, p.Symbol, err)
+			}
+			l.invalidate()
+			continue
+		}
+		if p.MarginType != models.MarginTypeIsolated {
+			crossAccounts[p.APIKey] = true
+		}
+
+		if save {
+			if err := l.positionStore.UpdateUnrealizedPNL(p.APIKey, p.Symbol, p.PositionSide, p.UnrealizedPNL); err != nil {
+				log.Printf("Error updating unrealized pnl: %v", err)
+			}
+		}
+	}
+
+	for apiKey := range crossAccounts {
+		if err := l.checkCross(apiKey); err != nil {
+			log.Printf("Error checking cross margin for %s: %v", apiKey, err)
+		}
+	}
+}
+
+// isolatedCheck 按最新持仓重新判断逐仓持仓在 mark 价格下是否仍低于维持保证金，并按最新保证金计算破产价
+func (l *Liquidator) isolatedCheck(mark decimal.Decimal) matching.LiquidationCheck {
+	return func(current *models.Position) (decimal.Decimal, bool) {
+		if current.MarginType != models.MarginTypeIsolated || !Breached(current, mark, l.maintenanceMarginRate) {
+			return decimal.Zero, false
+		}
+		if l.priceMode == PriceModeBankruptcy {
+			return BankruptcyPrice(current), true
+		}
+		return mark, true
+	}
+}
+
+// checkCross 全仓保证金余额 = 可用余额 + 全仓持仓保证金 + 全仓未实现盈亏
+// 低于全部全仓持仓的维持保证金之和时，强平该账户所有全仓持仓
+func (l *Liquidator) checkCross(apiKey string) error {
+	l.mu.Lock()
+	var positions []models.Position
+	for _, symbolPositions := range l.positions {
+		for _, p := range symbolPositions {
+			if p.APIKey == apiKey {
+				positions = append(positions, p)
+			}
+		}
+	}
+	available := l.available[apiKey]
+	l.mu.Unlock()
+
+	cross, equity, maintenance := l.crossMargin(positions, available)
+	if len(cross) == 0 || equity.GreaterThan(maintenance) {
+		return nil
+	}
+
+	log.Printf("Cross maintenance margin breached: %s equity=%s maintenance=%s", apiKey, equity, maintenance)
+	defer l.invalidate()
+	for i := range cross {
+		if err := l.engine.Liquidate(&cross[i], l.crossCheck(apiKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// crossCheck 按账户的最新持仓和余额重新判断全仓是否仍低于维持保证金
+// 平仓价格按 liquidation_price_mode 取持仓 symbol 的最新成交价或全仓破产价；前面的持仓强平后账户可能已经恢复，剩余的持仓不再强平
+func (l *Liquidator) crossCheck(apiKey string) matching.LiquidationCheck {
+	return func(current *models.Position) (decimal.Decimal, bool) {
+		if current.MarginType == models.MarginTypeIsolated {
+			return decimal.Zero, false
+		}
+		positions, err := l.positionStore.GetByAPIKey(apiKey)
+		if err != nil {
+			log.Printf("Error checking cross margin for %s: %v", apiKey, err)
+			return decimal.Zero, false
+		}
+		balance, err := l.balanceStore.Get(apiKey)
+		if err != nil {
+			log.Printf("Error checking cross margin for %s: %v", apiKey, err)
+			return decimal.Zero, false
+		}
+		cross, equity, maintenance := l.crossMargin(positions, balance.Available)
+		if len(cross) == 0 || equity.GreaterThan(maintenance) {
+			return decimal.Zero, false
+		}
+		mark := l.markPrice(current)
+		if l.priceMode == PriceModeBankruptcy {
+			return CrossBankruptcyPrice(current, mark, equity), true
+		}
+		return mark, true
+	}
+}
+
+// crossMargin 按账户的持仓和可用余额返回全仓持仓、全仓保证金余额和维持保证金之和
+func (l *Liquidator) crossMargin(positions []models.Position, available decimal.Decimal) ([]models.Position, decimal.Decimal, decimal.Decimal) {
+	var cross []models.Position
+	equity := available
+	maintenance := decimal.Zero
+	for _, p := range positions {
+		if p.MarginType == models.MarginTypeIsolated {
+			continue
+		}
+		mark := l.markPrice(&p)
+		equity = equity.Add(p.Margin).Add(UnrealizedPNL(&p, mark))
+		maintenance = maintenance.Add(p.Size.Mul(mark).Mul(l.maintenanceMarginRate))
+		cross = append(cross, p)
+	}
+	return cross, equity, maintenance
+}
+
+// refresh 快照超过 refreshInterval 时重新读取全部持仓和有全仓持仓的账户的可用余额，调用方需持有 l.mu
+func (l *Liquidator) refresh() error {
+	now := l.clock.Now()
+	if !l.loadedAt.IsZero() && now.Sub(l.loadedAt) < refreshInterval {
+		return nil
+	}
+
+	all, err := l.positionStore.GetAll()
+	if err != nil {
+		return err
+	}
+	positions := make(map[string][]models.Position)
+	available := make(map[string]decimal.Decimal)
+	for _, p := range all {
+		positions[p.Symbol] = append(positions[p.Symbol], p)
+		if p.MarginType == models.MarginTypeIsolated {
+			continue
+		}
+		if _, ok := available[p.APIKey]; ok {
+			continue
+		}
+		balance, err := l.balanceStore.Get(p.APIKey)
+		if err != nil {
+			return err
+		}
+		available[p.APIKey] = balance.Available
+	}
+	l.positions, l.available, l.loadedAt = positions, available, now
+	return nil
+}
+
+// invalidate 强平后丢弃快照，下一笔行情重新读取
+func (l *Liquidator) invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadedAt = time.Time{}
+}
+
+// markPrice 返回持仓 symbol 的最新成交价，还没有行情时用开仓均价
+func (l *Liquidator) markPrice(p *models.Position) decimal.Decimal {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if price, ok := l.prices[p.Symbol]; ok {
+		return price
+	}
+	return p.EntryPrice
+}
+
+// shouldSave 每个 symbol 每秒最多写回一次未实现盈亏
+func (l *Liquidator) shouldSave(symbol string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Sub(l.lastSaved[symbol]) < pnlSaveInterval {
+		return false
+	}
+	l.lastSaved[symbol] = now
+	return true
+}
+
+// UnrealizedPNL 按标记价格计算未实现盈亏
+func UnrealizedPNL(p *models.Position, markPrice decimal.Decimal) decimal.Decimal {
+	pnl := p.Size.Mul(markPrice.Sub(p.EntryPrice))
+	if p.Side == models.PositionSideShort {
+		pnl = pnl.Neg()
+	}
+	return pnl
+}
+
+// MarginRatio 逐仓保证金率 = 维持保证金 / 保证金余额，>= 1 时触发强平
+func MarginRatio(p *models.Position, markPrice, maintenanceMarginRate decimal.Decimal) decimal.Decimal {
+	equity := p.Margin.Add(UnrealizedPNL(p, markPrice))
+	if !equity.IsPositive() {
+		return decimal.NewFromInt(1)
+	}
+	return p.Size.Mul(markPrice).Mul(maintenanceMarginRate).Div(equity)
+}
+
+// Breached 保证金余额是否已低于维持保证金
+func Breached(p *models.Position, markPrice, maintenanceMarginRate decimal.Decimal) bool {
+	return MarginRatio(p, markPrice, maintenanceMarginRate).GreaterThanOrEqual(decimal.NewFromInt(1))
+}
+
+// BankruptcyPrice 破产价：亏损恰好耗尽持仓保证金的价格
+func BankruptcyPrice(p *models.Position) decimal.Decimal {
+	if !p.Size.IsPositive() {
+		return p.EntryPrice
+	}
+	if p.Side == models.PositionSideShort {
+		return p.EntryPrice.Add(p.Margin.Div(p.Size))
+	}
+	return p.EntryPrice.Sub(p.Margin.Div(p.Size))
+}
+
+// CrossBankruptcyPrice 全仓破产价：其他全仓持仓按最新价计算时，p 在 mark 之后的亏损恰好耗尽账户全仓保证金余额 equity 的价格
+// 按该价格平仓后全仓保证金余额为 0；余额不足以由 p 单独耗尽（多头破产价不为正）时按 mark 平仓，由剩余持仓继续承担
+func CrossBankruptcyPrice(p *models.Position, mark, equity decimal.Decimal) decimal.Decimal {
+	if !p.Size.IsPositive() {
+		return mark
+	}
+	if p.Side == models.PositionSideShort {
+		return mark.Add(equity.Div(p.Size))
+	}
+	if price := mark.Sub(equity.Div(p.Size)); price.IsPositive() {
+		return price
+	}
+	return mark
+}
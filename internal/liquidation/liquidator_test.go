@@ -0,0 +1,252 @@
+package liquidation
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/db"
+	"hft-sim/internal/matching"
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+func TestBankruptcyPrice(t *testing.T) {
+	long := &models.Position{Side: models.PositionSideLong, EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(2), Margin: decimal.NewFromInt(4)}
+	assert.Equal(t, "98", BankruptcyPrice(long).String())
+
+	short := &models.Position{Side: models.PositionSideShort, EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(2), Margin: decimal.NewFromInt(4)}
+	assert.Equal(t, "102", BankruptcyPrice(short).String())
+}
+
+func TestLiquidator_ForceClose(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('k', 'test', 1000)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available) VALUES ('k', 1000)")
+	require.NoError(t, err)
+
+	positions := store.NewPositionStore(database.DB)
+	// 50 倍逐仓多单 1 @ 100，保证金 2
+	require.NoError(t, positions.Save(&models.Position{APIKey: "k", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(1), Leverage: 50, MarginType: models.MarginTypeIsolated, Margin: decimal.NewFromInt(2)}))
+
+	engine := matching.NewEngine(database.DB)
+	pending := &models.Order{APIKey: "k", Symbol: "BTCUSDT", Side: models.SideBuy, Type: "LIMIT",
+		Price: decimal.NewFromInt(90), Quantity: decimal.NewFromInt(1), Leverage: 50, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(pending))
+
+	liquidator := New(database.DB, engine)
+
+	// 99 时保证金余额 1 仍高于维持保证金 0.495
+	liquidator.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "99", Quantity: "1"})
+	p, err := positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+
+	// 98.3 时保证金余额 0.3 低于维持保证金，按破产价 98 强平
+	liquidator.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "98.3", Quantity: "1"})
+	p, err = positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Equal(t, models.OrderStatusCancelled, pending.Status)
+
+	trades, err := store.NewOrderStore(database.DB).GetTradesByAPIKey("k")
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.True(t, trades[0].IsLiquidation)
+	assert.Equal(t, "98", trades[0].Price.String())
+
+	balance, err := store.NewBalanceStore(database.DB).Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "-2", balance.TotalPNL.String())
+}
+
+func TestCrossBankruptcyPrice(t *testing.T) {
+	long := &models.Position{Side: models.PositionSideLong, Size: decimal.NewFromInt(2)}
+	assert.Equal(t, "97", CrossBankruptcyPrice(long, decimal.NewFromInt(98), decimal.NewFromInt(2)).String())
+	// 已跌破破产价时按好于最新价的价格平仓，余额恰好归零
+	assert.Equal(t, "99", CrossBankruptcyPrice(long, decimal.NewFromInt(98), decimal.NewFromInt(-2)).String())
+
+	short := &models.Position{Side: models.PositionSideShort, Size: decimal.NewFromInt(2)}
+	assert.Equal(t, "103", CrossBankruptcyPrice(short, decimal.NewFromInt(102), decimal.NewFromInt(2)).String())
+}
+
+func TestLiquidator_CrossBankruptcy(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('k', 'test', 3)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available, frozen) VALUES ('k', 1, 2)")
+	require.NoError(t, err)
+
+	positions := store.NewPositionStore(database.DB)
+	// 50 倍全仓多单 1 @ 100，保证金 2，可用余额 1
+	require.NoError(t, positions.Save(&models.Position{APIKey: "k", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(1), Leverage: 50, MarginType: models.MarginTypeCrossed, Margin: decimal.NewFromInt(2)}))
+
+	liquidator := New(database.DB, matching.NewEngine(database.DB))
+
+	// 96 时全仓保证金余额 -1，按破产价 97 平仓，钱包余额恰好归零而不是 -1
+	liquidator.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "96", Quantity: "1"})
+	p, err := positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	trades, err := store.NewOrderStore(database.DB).GetTradesByAPIKey("k")
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.True(t, trades[0].IsLiquidation)
+	assert.Equal(t, "97", trades[0].Price.String())
+
+	balance, err := store.NewBalanceStore(database.DB).Get("k")
+	require.NoError(t, err)
+	assert.True(t, balance.Available.Add(balance.Frozen).IsZero(), "wallet %s", balance.Available.Add(balance.Frozen))
+}
+
+func TestLiquidator_RecheckCurrentPosition(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('k', 'test', 1000)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available) VALUES ('k', 1000)")
+	require.NoError(t, err)
+
+	positions := store.NewPositionStore(database.DB)
+	stale := &models.Position{APIKey: "k", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(1), Leverage: 50, MarginType: models.MarginTypeIsolated, Margin: decimal.NewFromInt(2)}
+	require.NoError(t, positions.Save(stale))
+
+	// 调用方读取持仓之后保证金追加到 10
+	current := *stale
+	current.Margin = decimal.NewFromInt(10)
+	require.NoError(t, positions.Save(&current))
+
+	engine := matching.NewEngine(database.DB)
+	liquidator := New(database.DB, engine)
+
+	// 按过时的保证金 2 在 98.3 已经爆仓，按最新保证金 10 没有，不平仓
+	require.NoError(t, engine.Liquidate(stale, liquidator.isolatedCheck(decimal.RequireFromString("98.3"))))
+	p, err := positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+
+	// 90.3 时按最新保证金爆仓，破产价按最新保证金计算为 90
+	require.NoError(t, engine.Liquidate(stale, liquidator.isolatedCheck(decimal.RequireFromString("90.3"))))
+	p, err = positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	trades, err := store.NewOrderStore(database.DB).GetTradesByAPIKey("k")
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.Equal(t, "90", trades[0].Price.String())
+}
+
+func TestLiquidator_RollbackKeepsOrders(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('k', 'test', 1000)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available) VALUES ('k', 1000)")
+	require.NoError(t, err)
+
+	positions := store.NewPositionStore(database.DB)
+	position := &models.Position{APIKey: "k", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(1), Leverage: 50, MarginType: models.MarginTypeIsolated, Margin: decimal.NewFromInt(2)}
+	require.NoError(t, positions.Save(position))
+
+	engine := matching.NewEngine(database.DB)
+	pending := &models.Order{APIKey: "k", Symbol: "BTCUSDT", Side: models.SideBuy, Type: "LIMIT",
+		Price: decimal.NewFromInt(90), Quantity: decimal.NewFromInt(1), Leverage: 50, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(pending))
+	liquidator := New(database.DB, engine)
+
+	// 强平成交写入失败时，撤单和释放的保证金随强平事务一起回滚
+	_, err = database.Exec(`CREATE TRIGGER fail_trade BEFORE INSERT ON trades BEGIN SELECT RAISE(ABORT, 'boom'); END`)
+	require.NoError(t, err)
+	require.Error(t, engine.Liquidate(position, liquidator.isolatedCheck(decimal.RequireFromString("98.3"))))
+
+	p, err := positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	assert.Equal(t, models.OrderStatusNew, pending.Status)
+	orders, err := store.NewOrderStore(database.DB).GetOpen()
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	balance, err := store.NewBalanceStore(database.DB).Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "1.8", balance.Frozen.String())
+
+	// 故障恢复后强平正常撤单
+	_, err = database.Exec(`DROP TRIGGER fail_trade`)
+	require.NoError(t, err)
+	require.NoError(t, engine.Liquidate(position, liquidator.isolatedCheck(decimal.RequireFromString("98.3"))))
+	assert.Equal(t, models.OrderStatusCancelled, pending.Status)
+	orders, err = store.NewOrderStore(database.DB).GetOpen()
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
+func TestLiquidator_SnapshotRefresh(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES ('k', 'test', 1000)")
+	require.NoError(t, err)
+	_, err = database.Exec("INSERT INTO balances (api_key, available) VALUES ('k', 1000)")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewSim(start)
+	engine := matching.NewEngine(database.DB)
+	engine.SetClock(clk)
+	liquidator := New(database.DB, engine)
+	liquidator.SetClock(clk)
+
+	// 第一笔行情读取快照，此时没有持仓
+	liquidator.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "100", Quantity: "1"})
+
+	positions := store.NewPositionStore(database.DB)
+	require.NoError(t, positions.Save(&models.Position{APIKey: "k", Symbol: "BTCUSDT",
+		PositionSide: models.PositionSideBoth, Side: models.PositionSideLong,
+		EntryPrice: decimal.NewFromInt(100), Size: decimal.NewFromInt(1), Leverage: 50, MarginType: models.MarginTypeIsolated, Margin: decimal.NewFromInt(2)}))
+
+	// 快照未过期，行情不读数据库，看不到新持仓
+	liquidator.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "98.3", Quantity: "1"})
+	p, err := positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+
+	// 一个刷新间隔后重新读取快照并强平
+	clk.Set(start.Add(refreshInterval))
+	liquidator.OnTrade(collector.Trade{Symbol: "BTCUSDT", Price: "98.3", Quantity: "1"})
+	p, err = positions.Get("k", "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
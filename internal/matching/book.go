@@ -0,0 +1,153 @@
+package matching
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+)
+
+// priceLevel 同一价格上的挂单队列，按进入订单簿的先后排列
+type priceLevel struct {
+	price  decimal.Decimal
+	orders []*models.Order
+}
+
+// OrderBook 单个 symbol 的内存订单簿（价格优先、时间优先）
+// 只保存模拟用户的挂单，不包含币安的真实盘口
+type OrderBook struct {
+	symbol string
+	bids   []*priceLevel // 价格从高到低
+	asks   []*priceLevel // 价格从低到高
+	orders map[int64]*models.Order
+	queue  map[int64]decimal.Decimal // 订单 ID -> 排在前面的真实挂单量
+}
+
+func NewOrderBook(symbol string) *OrderBook {
+	return &OrderBook{
+		symbol: symbol,
+		orders: make(map[int64]*models.Order),
+		queue:  make(map[int64]decimal.Decimal),
+	}
+}
+
+// Len 返回订单簿中的挂单数量
+func (b *OrderBook) Len() int {
+	return len(b.orders)
+}
+
+// Get 按订单 ID 查找挂单
+func (b *OrderBook) Get(id int64) *models.Order {
+	return b.orders[id]
+}
+
+// ByAPIKey 返回某个账户在订单簿中的全部挂单
+func (b *OrderBook) ByAPIKey(apiKey string) []*models.Order {
+	var result []*models.Order
+	for _, o := range b.orders {
+		if o.APIKey == apiKey {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// Add 将订单放到对应价格档位的队尾
+func (b *OrderBook) Add(order *models.Order) {
+	if _, ok := b.orders[order.ID]; ok {
+		return
+	}
+
+	levels := b.side(order.Side)
+	i := b.search(order.Side, order.Price)
+	if i < len(*levels) && (*levels)[i].price.Equal(order.Price) {
+		(*levels)[i].orders = append((*levels)[i].orders, order)
+	} else {
+		level := &priceLevel{price: order.Price, orders: []*models.Order{order}}
+		*levels = append(*levels, nil)
+		copy((*levels)[i+1:], (*levels)[i:])
+		(*levels)[i] = level
+	}
+	b.orders[order.ID] = order
+}
+
+// Remove 从订单簿中移除订单，返回被移除的订单
+func (b *OrderBook) Remove(id int64) *models.Order {
+	order, ok := b.orders[id]
+	if !ok {
+		return nil
+	}
+	delete(b.orders, id)
+	delete(b.queue, id)
+
+	levels := b.side(order.Side)
+	i := b.search(order.Side, order.Price)
+	if i >= len(*levels) || !(*levels)[i].price.Equal(order.Price) {
+		return order
+	}
+
+	level := (*levels)[i]
+	for j, o := range level.orders {
+		if o.ID == id {
+			level.orders = append(level.orders[:j], level.orders[j+1:]...)
+			break
+		}
+	}
+	if len(level.orders) == 0 {
+		*levels = append((*levels)[:i], (*levels)[i+1:]...)
+	}
+	return order
+}
+
+// Touched 返回成交价触及或击穿的挂单，按价格优先、时间优先排列
+// 买单：限价 >= 成交价；卖单：限价 <= 成交价
+// 只遍历被触及的价格档位
+func (b *OrderBook) Touched(tradePrice decimal.Decimal) []*models.Order {
+	var result []*models.Order
+	for _, level := range b.bids {
+		if level.price.LessThan(tradePrice) {
+			break
+		}
+		result = append(result, level.orders...)
+	}
+	for _, level := range b.asks {
+		if level.price.GreaterThan(tradePrice) {
+			break
+		}
+		result = append(result, level.orders...)
+	}
+	return result
+}
+
+// QueueAhead 返回订单前方尚未成交的真实挂单量
+func (b *OrderBook) QueueAhead(id int64) decimal.Decimal {
+	return b.queue[id]
+}
+
+// SetQueueAhead 设置订单前方的真实挂单量
+func (b *OrderBook) SetQueueAhead(id int64, qty decimal.Decimal) {
+	if _, ok := b.orders[id]; !ok {
+		return
+	}
+	if qty.IsNegative() {
+		qty = decimal.Zero
+	}
+	b.queue[id] = qty
+}
+
+func (b *OrderBook) side(side models.Side) *[]*priceLevel {
+	if side == models.SideBuy {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// search 返回价格档位应在的位置
+func (b *OrderBook) search(side models.Side, price decimal.Decimal) int {
+	levels := *b.side(side)
+	if side == models.SideBuy {
+		return sort.Search(len(levels), func(i int) bool { return levels[i].price.LessThanOrEqual(price) })
+	}
+	return sort.Search(len(levels), func(i int) bool { return levels[i].price.GreaterThanOrEqual(price) })
+}
@@ -0,0 +1,49 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hft-sim/internal/models"
+)
+
+func orderIDs(orders []*models.Order) []int64 {
+	ids := make([]int64, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+	return ids
+}
+
+func TestOrderBook_PriceTimePriority(t *testing.T) {
+	book := NewOrderBook("BTCUSDT")
+	book.Add(&models.Order{ID: 1, Side: models.SideBuy, Price: dec("100")})
+	book.Add(&models.Order{ID: 2, Side: models.SideBuy, Price: dec("101")})
+	book.Add(&models.Order{ID: 3, Side: models.SideBuy, Price: dec("100")})
+	book.Add(&models.Order{ID: 4, Side: models.SideBuy, Price: dec("99")})
+	book.Add(&models.Order{ID: 5, Side: models.SideSell, Price: dec("103")})
+	book.Add(&models.Order{ID: 6, Side: models.SideSell, Price: dec("102")})
+
+	// 成交价 99.5 只触及 101 和 100 两档买单
+	assert.Equal(t, []int64{2, 1, 3}, orderIDs(book.Touched(dec("99.5"))))
+	// 成交价 102.5 只触及 102 的卖单
+	assert.Equal(t, []int64{6}, orderIDs(book.Touched(dec("102.5"))))
+	// 成交价等于限价也算触及
+	assert.Equal(t, []int64{2}, orderIDs(book.Touched(dec("101"))))
+	assert.Empty(t, book.Touched(dec("101.5")))
+}
+
+func TestOrderBook_Remove(t *testing.T) {
+	book := NewOrderBook("BTCUSDT")
+	book.Add(&models.Order{ID: 1, Side: models.SideSell, Price: dec("100")})
+	book.Add(&models.Order{ID: 2, Side: models.SideSell, Price: dec("100")})
+
+	assert.NotNil(t, book.Remove(1))
+	assert.Nil(t, book.Remove(1))
+	assert.Equal(t, 1, book.Len())
+	assert.Equal(t, []int64{2}, orderIDs(book.Touched(dec("101"))))
+
+	book.Remove(2)
+	assert.Empty(t, book.asks)
+}
@@ -2,163 +2,481 @@ package matching
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
-	"strconv"
+	"sync"
 	"time"
 
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
 	"hft-sim/internal/collector"
 	"hft-sim/internal/models"
 	"hft-sim/internal/store"
 )
 
+// ErrOrderNotFound 订单不存在或已不在订单簿中
+var ErrOrderNotFound = errors.New("unknown order")
+
+// Engine 撮合引擎
+// 内存订单簿是挂单的权威数据，SQLite 只作为成交后的写入日志
 type Engine struct {
+	db         *sql.DB
+	mu         sync.Mutex
+	books      map[string]*OrderBook      // symbol -> 订单簿
+	triggers   map[string]*TriggerBook    // symbol -> 等待触发的条件单
+	lastPrices map[string]decimal.Decimal // symbol -> 最新成交价
+
 	orderStore    *store.OrderStore
 	positionStore *store.PositionStore
 	balanceStore  *store.BalanceStore
+	ledgerStore   *store.LedgerStore
+	accountStore  *store.AccountStore
 	configStore   *store.ConfigStore
+	depth         DepthSource
+	defaultFill   FillModel
+	fillModels    map[string]FillModel // symbol -> 成交模型
+	fillSeed      int64                // 非 0 时覆盖 fill_touch_seed 配置
+	symbolStore   *store.SymbolStore
+	fundingStore  *store.FundingStore
+	fees          feeRates            // 默认费率
+	feeTiers      map[string]feeRates // 费率等级 -> 费率
+	accountTiers  map[string]string   // apiKey -> 费率等级，未分配等级的账户不在其中
+	quoteAssets   map[string]string   // symbol -> 计价资产（手续费币种）
+	feesLoadedAt  time.Time           // 上次读取费率配置、账户等级和计价资产的时间
+	clock         clock.Clock
 }
 
 func NewEngine(db *sql.DB) *Engine {
 	return &Engine{
+		db:            db,
+		books:         make(map[string]*OrderBook),
+		triggers:      make(map[string]*TriggerBook),
+		lastPrices:    make(map[string]decimal.Decimal),
 		orderStore:    store.NewOrderStore(db),
 		positionStore: store.NewPositionStore(db),
 		balanceStore:  store.NewBalanceStore(db),
+		ledgerStore:   store.NewLedgerStore(db),
+		accountStore:  store.NewAccountStore(db),
 		configStore:   store.NewConfigStore(db),
+		defaultFill:   queueModel{},
+		fillModels:    make(map[string]FillModel),
+		symbolStore:   store.NewSymbolStore(db),
+		fundingStore:  store.NewFundingStore(db),
+		fees:          feeRates{Maker: decimal.RequireFromString("0.0002"), Taker: decimal.RequireFromString("0.0005")},
+		feeTiers:      make(map[string]feeRates),
+		accountTiers:  make(map[string]string),
+		quoteAssets:   make(map[string]string),
+		clock:         clock.Real{},
 	}
 }
 
-func (e *Engine) OnTrade(trade collector.Trade) {
-	price, _ := strconv.ParseFloat(trade.Price, 64)
+// SetClock 设置时间来源，订单、成交和持仓的时间戳都取自该时钟，回放时使用模拟时钟
+func (e *Engine) SetClock(c clock.Clock) {
+	e.clock = c
+	e.orderStore.SetClock(c)
+	e.positionStore.SetClock(c)
+	e.balanceStore.SetClock(c)
+	e.ledgerStore.SetClock(c)
+	e.symbolStore.SetClock(c)
+}
 
-	// 获取该 symbol 的所有未成交订单
-	orders, err := e.orderStore.GetOpenBySymbol(trade.Symbol)
+// SetFillSeed 设置 probabilistic_touch 模型的随机数种子，覆盖 fill_touch_seed 配置，需在 Load 之前调用
+// 回放时使用由回放范围得到的种子，同一次回放重复运行的成交相同
+func (e *Engine) SetFillSeed(seed int64) {
+	e.fillSeed = seed
+}
+
+// Load 从 SQLite 重建内存订单簿并读取成交模型、手续费配置，启动时调用一次
+func (e *Engine) Load() error {
+	orders, err := e.orderStore.GetOpen()
 	if err != nil {
-		log.Printf("Error getting open orders: %v", err)
-		return
+		return err
 	}
 
-	for _, order := range orders {
-		if e.shouldMatch(order, price) {
-			e.matchOrder(&order, price)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.loadFillModels()
+	e.loadFees()
+
+	e.books = make(map[string]*OrderBook)
+	e.triggers = make(map[string]*TriggerBook)
+	expired := 0
+	for i := range orders {
+		order := &orders[i]
+		if models.IsConditional(order.Type) && order.TriggerTime == nil {
+			e.triggerBook(order.Symbol).Add(order)
+			continue
 		}
+		// 吃单只在提交或触发时按盘口成交，进程在成交途中停止时剩余部分过期，不能挂入订单簿
+		if takerOnly(order) {
+			if err := e.expire(order); err != nil {
+				return err
+			}
+			expired++
+			continue
+		}
+		book := e.book(order.Symbol)
+		book.Add(order)
+		book.SetQueueAhead(order.ID, e.estimateQueue(order))
 	}
+	log.Printf("Order book loaded: %d open orders, %d unfinished taker orders expired", len(orders)-expired, expired)
+	return nil
 }
 
-// shouldMatch 判断订单是否应该成交
-// 买单：当 trade price < limit price 时成交（price-through）
-// 卖单：当 trade price > limit price 时成交
-func (e *Engine) shouldMatch(order models.Order, tradePrice float64) bool {
-	if order.Status == models.OrderStatusFilled || order.Status == models.OrderStatusCancelled {
-		return false
-	}
+// takerOnly 订单是否只能立即成交、不能挂单：市价单（含触发后的市价条件单）和 IOC/FOK 限价单
+func takerOnly(order *models.Order) bool {
+	return models.IsMarketType(order.Type) ||
+		order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK
+}
 
-	remainingQty := order.Quantity - order.ExecutedQty
-	if remainingQty <= 0 {
-		return false
+// PlaceOrder 冻结订单初始保证金，持久化新订单并挂入内存订单簿
+// 与当前盘口交叉的部分立即按 taker 成交；市价单和 IOC/FOK 限价单不挂单，GTX 订单会立即成交时直接过期
+// 条件单放入触发簿，按最新成交价会立即触发时返回 ErrWouldTrigger
+// 可用余额不足时返回 ErrMarginInsufficient
+func (e *Engine) PlaceOrder(order *models.Order) error {
+	if order.PositionSide == "" {
+		order.PositionSide = models.PositionSideBoth
 	}
-
-	switch order.Side {
-	case models.SideBuy:
-		// 买单：价格击穿（低于限价）
-		return tradePrice < order.Price
-	case models.SideSell:
-		// 卖单：价格击穿（高于限价）
-		return tradePrice > order.Price
+	if order.TimeInForce == "" {
+		order.TimeInForce = models.TimeInForceGTC
 	}
-	return false
+	if order.Status == "" {
+		order.Status = models.OrderStatusNew
+	}
+	if models.IsConditional(order.Type) {
+		return e.placeConditional(order)
+	}
+	return e.execute(order)
 }
 
-func (e *Engine) matchOrder(order *models.Order, price float64) {
-	remainingQty := order.Quantity - order.ExecutedQty
+// execute 按限价单或市价单执行订单，条件单触发后也从这里执行
+func (e *Engine) execute(order *models.Order) error {
+	if takerOnly(order) {
+		return e.placeTaker(order)
+	}
 
-	// 获取手续费率
-	makerFee := 0.0002 // 从配置读取
+	// 在加锁前获取深度，避免网络请求阻塞撮合
+	depth := e.fetchDepth(order.Symbol)
 
-	// 创建成交记录
-	quoteQty := remainingQty * price
-	fee := quoteQty * makerFee
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	trade := &models.Trade{
-		OrderID:   order.ID,
-		APIKey:    order.APIKey,
-		Symbol:    order.Symbol,
-		Side:      order.Side,
-		Price:     price,
-		Quantity:  remainingQty,
-		QuoteQty:  quoteQty,
-		Fee:       fee,
-		Timestamp: time.Now(),
-	}
-
-	if err := e.orderStore.CreateTrade(trade); err != nil {
-		log.Printf("Error creating trade: %v", err)
-		return
+	if isReduceOnly(order) {
+		if err := e.sizeReduceOnly(order); err != nil {
+			return err
+		}
 	}
 
-	// 更新订单状态
-	newExecutedQty := order.Quantity
-	if err := e.orderStore.UpdateStatus(order.ID, models.OrderStatusFilled, newExecutedQty); err != nil {
-		log.Printf("Error updating order: %v", err)
-		return
+	if order.TimeInForce == models.TimeInForceGTX && depth != nil && wouldTake(depth, order) {
+		if err := e.persist(order); err != nil {
+			return err
+		}
+		order.Status = models.OrderStatusExpired
+		return e.orderStore.Expire(order.ID)
 	}
 
-	// 更新持仓
-	if err := e.updatePosition(order, remainingQty, price); err != nil {
-		log.Printf("Error updating position: %v", err)
-		return
+	if err := e.freezeOrderMargin(order, orderMargin(order, order.Quantity)); err != nil {
+		return err
 	}
 
-	// 更新余额
-	if err := e.updateBalance(order, remainingQty, price, fee); err != nil {
-		log.Printf("Error updating balance: %v", err)
-		return
+	// 提交时与盘口交叉的部分立即作为 taker 成交，剩余部分挂单等待成为 maker
+	book := e.book(order.Symbol)
+	if depth != nil && wouldTake(depth, order) {
+		for _, f := range sweepDepth(depth, order.Side, order.Quantity, order.Price) {
+			if !isOpen(order) {
+				break
+			}
+			e.matchOrder(book, order, f.price, f.qty, FillModelDepth, false, e.clock.Now(), 0)
+		}
+		if !isOpen(order) {
+			return nil
+		}
 	}
 
-	log.Printf("Order %d matched: %s %s @ %f", order.ID, order.Side, order.Symbol, price)
+	book.Add(order)
+	if depth != nil {
+		book.SetQueueAhead(order.ID, levelQuantity(depth, order.Side, order.Price))
+	}
+	return nil
 }
 
-func (e *Engine) updatePosition(order *models.Order, qty, price float64) error {
-	// 简化版：开新仓或平仓逻辑
-	position, err := e.positionStore.Get(order.APIKey, order.Symbol)
+// freezeOrderMargin 检查可用余额不少于 required，持久化订单并冻结其初始保证金，调用方需持有 e.mu
+// 余额检查、订单和冻结保证金的流水在一个事务内完成，失败时都不写入
+// 限价单 required 即冻结的初始保证金；市价单没有限价，冻结金额为 0，成交时直接锁定为持仓保证金
+func (e *Engine) freezeOrderMargin(order *models.Order, required decimal.Decimal) error {
+	ftx, err := e.beginFill()
+	if err != nil {
+		return err
+	}
+	defer ftx.tx.Rollback()
+
+	balance, err := e.balanceStore.WithTx(ftx.tx).Get(order.APIKey)
 	if err != nil {
 		return err
 	}
+	if required.GreaterThan(balance.Available) {
+		return ErrMarginInsufficient
+	}
+	margin := orderMargin(order, order.Quantity)
+
+	created := order.ID == 0
+	if created {
+		if err := ftx.orders.Create(order); err != nil {
+			return err
+		}
+	}
+	err = ftx.ledger.Post(marginEntry(order.APIKey, order.Symbol, margin, fmt.Sprintf("order %d", order.ID)))
+	if err == nil {
+		err = ftx.tx.Commit()
+	}
+	if err != nil && created {
+		// 事务已回滚，订单没有写入
+		order.ID = 0
+	}
+	return err
+}
+
+// persist 持久化新订单，已持久化的订单（触发后的条件单）不重复写入
+func (e *Engine) persist(order *models.Order) error {
+	if order.ID != 0 {
+		return nil
+	}
+	return e.orderStore.Create(order)
+}
+
+// SetDepthSource 设置真实盘口深度来源，用于估计挂单排队位置
+func (e *Engine) SetDepthSource(depth DepthSource) {
+	e.depth = depth
+}
 
-	// 如果没有持仓或反向持仓，开新仓
-	if position == nil || (position.Side == models.PositionSideLong && order.Side == models.SideSell) ||
-		(position.Side == models.PositionSideShort && order.Side == models.SideBuy) {
+// CancelOrder 撤销挂单，只能撤销属于该 API Key 的订单
+func (e *Engine) CancelOrder(apiKey string, id int64) (*models.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-		newSide := models.PositionSideLong
-		if order.Side == models.SideSell {
-			newSide = models.PositionSideShort
+	for _, t := range e.triggers {
+		if o := t.Get(id); o != nil && o.APIKey == apiKey {
+			if err := e.cancelConditional(t, o); err != nil {
+				return nil, err
+			}
+			return o, nil
 		}
+	}
 
-		newPosition := &models.Position{
-			APIKey:     order.APIKey,
-			Symbol:     order.Symbol,
-			Side:       newSide,
-			EntryPrice: price,
-			Size:       qty,
-			Leverage:   order.Leverage,
-			Margin:     qty * price / float64(order.Leverage),
+	var book *OrderBook
+	for _, b := range e.books {
+		if o := b.Get(id); o != nil && o.APIKey == apiKey {
+			book = b
+			break
 		}
-		return e.positionStore.Save(newPosition)
+	}
+	if book == nil {
+		return nil, ErrOrderNotFound
 	}
 
-	// 同向加仓或平仓逻辑（简化）
-	// TODO: 完整实现
-	return nil
+	order := book.Get(id)
+	if err := e.cancel(book, order); err != nil {
+		return nil, err
+	}
+	return order, nil
 }
 
-func (e *Engine) updateBalance(order *models.Order, qty, price, fee float64) error {
-	balance, err := e.balanceStore.Get(order.APIKey)
-	if err != nil {
+// cancel 撤单并释放未成交部分冻结的保证金，调用方需持有 e.mu
+func (e *Engine) cancel(book *OrderBook, order *models.Order) error {
+	if err := e.orderStore.Cancel(order.ID); err != nil {
 		return err
 	}
+	book.Remove(order.ID)
+	order.Status = models.OrderStatusCancelled
+	return e.releaseOrderMargin(order, order.Quantity.Sub(order.ExecutedQty))
+}
+
+// orderMargin 订单 qty 数量按限价计算的初始保证金，只减仓订单不占用保证金
+func orderMargin(order *models.Order, qty decimal.Decimal) decimal.Decimal {
+	if isReduceOnly(order) {
+		return decimal.Zero
+	}
+	return initialMargin(qty, order.Price, order.Leverage)
+}
+
+// isOpen 订单是否还可以继续成交
+func isOpen(order *models.Order) bool {
+	return order.Status == models.OrderStatusNew || order.Status == models.OrderStatusPartiallyFilled
+}
+
+// expire 按 timeInForce 规则撤销订单剩余部分并释放冻结的保证金，调用方需持有 e.mu
+func (e *Engine) expire(order *models.Order) error {
+	if err := e.orderStore.Expire(order.ID); err != nil {
+		return err
+	}
+	order.Status = models.OrderStatusExpired
+	return e.releaseOrderMargin(order, order.Quantity.Sub(order.ExecutedQty))
+}
+
+// releaseOrderMargin 把订单 qty 数量冻结的保证金退回可用余额
+func (e *Engine) releaseOrderMargin(order *models.Order, qty decimal.Decimal) error {
+	return e.ledgerStore.Post(releaseEntry(order, qty))
+}
+
+// releaseEntry 把订单 qty 数量冻结的保证金退回可用余额的流水
+func releaseEntry(order *models.Order, qty decimal.Decimal) *models.LedgerEntry {
+	return marginEntry(order.APIKey, order.Symbol, orderMargin(order, qty).Neg(), fmt.Sprintf("order %d", order.ID))
+}
+
+func (e *Engine) OnTrade(trade collector.Trade) {
+	price, err := decimal.NewFromString(trade.Price)
+	if err != nil {
+		log.Printf("Invalid trade price %q for %s: %v", trade.Price, trade.Symbol, err)
+		return
+	}
+	volume, err := decimal.NewFromString(trade.Quantity)
+	if err != nil {
+		log.Printf("Invalid trade quantity %q for %s: %v", trade.Quantity, trade.Symbol, err)
+		return
+	}
+
+	// 被触发的条件单在释放锁之后执行，执行时需要获取盘口深度
+	for _, order := range e.match(trade.Symbol, price, volume, e.tradeTime(trade), trade.TradeID) {
+		e.activate(order)
+	}
+}
+
+// tradeTime 行情的币安成交时间，没有成交时间时取当前时钟时间
+func (e *Engine) tradeTime(trade collector.Trade) time.Time {
+	if trade.TradeTime > 0 {
+		return time.UnixMilli(trade.TradeTime).UTC()
+	}
+	return e.clock.Now()
+}
+
+// match 用一笔 at 时刻、币安成交 ID 为 tradeID 的行情撮合挂单并检查条件单，返回本次被触发的条件单
+func (e *Engine) match(symbol string, price, volume decimal.Decimal, at time.Time, tradeID int64) []*models.Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastPrices[symbol] = price
+
+	// 只处理被本次成交价触及的价格档位，按优先级交给成交模型判断
+	if book, ok := e.books[symbol]; ok {
+		model := e.fillModel(symbol)
+		for _, order := range book.Touched(price) {
+			if !e.shouldMatch(*order, price) {
+				continue
+			}
+			if qty := model.Fill(book, order, price, &volume); qty.IsPositive() {
+				e.matchOrder(book, order, price, qty, model.Name(), true, at, tradeID)
+			}
+		}
+	}
+
+	triggers, ok := e.triggers[symbol]
+	if !ok {
+		return nil
+	}
+	triggered := triggers.Check(price)
+	for _, order := range triggered {
+		e.markTriggered(order, price, at)
+	}
+	return triggered
+}
+
+// book 返回 symbol 对应的订单簿，不存在时创建，调用方需持有 e.mu
+func (e *Engine) book(symbol string) *OrderBook {
+	b, ok := e.books[symbol]
+	if !ok {
+		b = NewOrderBook(symbol)
+		e.books[symbol] = b
+	}
+	return b
+}
+
+// shouldMatch 判断成交价是否触及订单限价
+// 买单：trade price <= limit price
+// 卖单：trade price >= limit price
+// 是否真正成交以及成交多少由成交模型决定
+func (e *Engine) shouldMatch(order models.Order, tradePrice decimal.Decimal) bool {
+	if order.Status == models.OrderStatusFilled || order.Status == models.OrderStatusCancelled {
+		return false
+	}
+
+	remainingQty := order.Quantity.Sub(order.ExecutedQty)
+	if !remainingQty.IsPositive() {
+		return false
+	}
+
+	switch order.Side {
+	case models.SideBuy:
+		return tradePrice.LessThanOrEqual(order.Price)
+	case models.SideSell:
+		return tradePrice.GreaterThanOrEqual(order.Price)
+	}
+	return false
+}
+
+// matchOrder 按 qty 成交订单，qty 小于剩余数量时为部分成交
+// maker 为 true 表示挂单被行情撮合，否则为提交时吃掉盘口的 taker 成交；at 为成交时间
+// tradeID 为触发成交的币安成交 ID，用于生成幂等键，同一笔行情重放时不会重复成交；taker 成交传 0
+func (e *Engine) matchOrder(book *OrderBook, order *models.Order, price, qty decimal.Decimal, fillModel string, maker bool, at time.Time, tradeID int64) {
+	if isReduceOnly(order) || closesHedgeLeg(order) {
+		if qty = e.clampReduceOnly(book, order, qty); !qty.IsPositive() {
+			return
+		}
+	}
+	// 成交量不超过订单剩余数量，成交记录、手续费和均价都按限制后的数量计算
+	remainingQty := order.Quantity.Sub(order.ExecutedQty)
+	if qty = decimal.Min(qty, remainingQty); !qty.IsPositive() {
+		return
+	}
+
+	// 创建成交记录，maker 费率为负时为返佣
+	quoteQty := qty.Mul(price)
+	fee := quoteQty.Mul(e.feeRate(order.APIKey, maker))
+
+	trade := &models.Trade{
+		OrderID:         order.ID,
+		APIKey:          order.APIKey,
+		Symbol:          order.Symbol,
+		Side:            order.Side,
+		Price:           price,
+		Quantity:        qty,
+		QuoteQty:        quoteQty,
+		Fee:             fee,
+		CommissionAsset: e.commissionAsset(order.Symbol),
+		IsMaker:         maker,
+		FillModel:       fillModel,
+		Timestamp:       at,
+		FillKey:         fillKey(tradeID, order.ID),
+	}
+
+	// 订单状态，成交量达到剩余数量时全部成交
+	newExecutedQty := order.ExecutedQty.Add(qty)
+	status := models.OrderStatusPartiallyFilled
+	if qty.Equal(remainingQty) {
+		status = models.OrderStatusFilled
+	}
+	avgPrice := order.AvgPrice.Mul(order.ExecutedQty).Add(price.Mul(qty)).Div(newExecutedQty)
+
+	// 成交、订单、持仓和余额在一个事务内结算，失败时内存订单保持不变
+	if _, err := e.settleFill(order, trade, status, newExecutedQty, avgPrice); err != nil {
+		if errors.Is(err, store.ErrDuplicateFill) {
+			log.Printf("Fill %s already settled, skipped", trade.FillKey)
+			return
+		}
+		log.Printf("Error settling fill for order %d: %v", order.ID, err)
+		return
+	}
+	order.ExecutedQty = newExecutedQty
+	order.AvgPrice = avgPrice
+	order.Status = status
+	if status == models.OrderStatusFilled {
+		book.Remove(order.ID)
+	}
 
-	// 扣除手续费
-	balance.Available -= fee
-	balance.TotalPNL -= fee
+	// 持仓归零或反向后，其余只减仓订单不再有持仓可减
+	e.cancelStaleReduceOnly(order.APIKey, order.Symbol, order.ID)
 
-	return e.balanceStore.Update(balance)
+	log.Printf("Order %d matched: %s %s %s @ %s (%s)", order.ID, order.Side, order.Symbol, qty, price, status)
 }
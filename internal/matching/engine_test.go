@@ -0,0 +1,698 @@
+package matching
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/db"
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+const testAPIKey = "test-key"
+
+// staticDepth 固定的盘口深度
+type staticDepth struct {
+	depth *collector.Depth
+}
+
+func (d staticDepth) Depth(symbol string) (*collector.Depth, error) {
+	return d.depth, nil
+}
+
+func newTestEngine(t *testing.T) (*Engine, *db.DB) {
+	t.Helper()
+
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	_, err = database.Exec("INSERT INTO api_keys (key, name, initial_balance) VALUES (?, 'test', 10000)", testAPIKey)
+	require.NoError(t, err)
+	err = store.NewLedgerStore(database.DB).Post(&models.LedgerEntry{
+		APIKey: testAPIKey, Type: models.LedgerTypeDeposit,
+		Debit: models.LedgerAccountExternal, Credit: models.LedgerAccountAvailable, Amount: dec("10000"),
+	})
+	require.NoError(t, err)
+
+	return NewEngine(database.DB), database
+}
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// assertDecimal 按数值比较，不受末尾的 0 影响
+func assertDecimal(t *testing.T, expected string, actual decimal.Decimal) {
+	t.Helper()
+	assert.True(t, dec(expected).Equal(actual), "expected %s, actual %s", expected, actual)
+}
+
+func tick(symbol, price, qty string) collector.Trade {
+	return collector.Trade{EventType: "trade", Symbol: symbol, Price: price, Quantity: qty}
+}
+
+func TestEngine_QueuePartialFills(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Bids: []collector.DepthLevel{{Price: dec("100"), Quantity: dec("1")}},
+	}})
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("0.5"), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+
+	// 前方排队 1.0，成交 0.6 只消耗排队量
+	engine.OnTrade(tick("BTCUSDT", "100", "0.6"))
+	assert.Equal(t, models.OrderStatusNew, order.Status)
+
+	// 剩余排队 0.4，成交 0.6 后剩余 0.2 成交给订单
+	engine.OnTrade(tick("BTCUSDT", "100", "0.6"))
+	assert.Equal(t, models.OrderStatusPartiallyFilled, order.Status)
+	assertDecimal(t, "0.2", order.ExecutedQty)
+
+	// 更高价格的成交不触及买单
+	engine.OnTrade(tick("BTCUSDT", "100.5", "5"))
+	assertDecimal(t, "0.2", order.ExecutedQty)
+
+	// 击穿限价，按成交量成交剩余部分
+	engine.OnTrade(tick("BTCUSDT", "99.9", "1"))
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+	assertDecimal(t, "0.5", order.ExecutedQty)
+
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	assert.Len(t, trades, 2)
+}
+
+func TestEngine_FillModels(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.fillModels["BTCUSDT"] = priceThroughModel{}
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideSell,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("2"), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+
+	// price-through：恰好触及不成交
+	engine.OnTrade(tick("BTCUSDT", "100", "10"))
+	assert.Equal(t, models.OrderStatusNew, order.Status)
+
+	// 击穿时不论成交量大小全部成交
+	engine.OnTrade(tick("BTCUSDT", "100.1", "0.001"))
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.Equal(t, FillModelPriceThrough, trades[0].FillModel)
+	assertDecimal(t, "2.0", trades[0].Quantity)
+}
+
+// volumeModel 把本笔成交的全部成交量分给订单，不考虑订单剩余数量
+type volumeModel struct{}
+
+func (volumeModel) Name() string { return "volume" }
+
+func (volumeModel) Fill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal {
+	return *volume
+}
+
+func TestEngine_FillCappedAtRemaining(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.fillModels["BTCUSDT"] = volumeModel{}
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("2"), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+
+	engine.OnTrade(tick("BTCUSDT", "100", "0.5"))
+	assert.Equal(t, models.OrderStatusPartiallyFilled, order.Status)
+
+	// 成交模型给出的数量超过剩余数量，成交记录和均价只按剩余的 1.5 计算
+	engine.OnTrade(tick("BTCUSDT", "99", "10"))
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+	assertDecimal(t, "2", order.ExecutedQty)
+	assertDecimal(t, "99.25", order.AvgPrice)
+
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	var last models.Trade
+	for _, trade := range trades {
+		if trade.Price.Equal(dec("99")) {
+			last = trade
+		}
+	}
+	assertDecimal(t, "1.5", last.Quantity)
+	assertDecimal(t, "148.5", last.QuoteQty)
+	position, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	require.NotNil(t, position)
+	assertDecimal(t, "2", position.Size)
+}
+
+func TestNewFillModel_Unknown(t *testing.T) {
+	_, err := NewFillModel("optimistic", 0.5, DefaultFillSeed)
+	assert.Error(t, err)
+}
+
+func TestNewFillModel_ProbabilisticTouchSeed(t *testing.T) {
+	// 相同种子在相同行情下得到相同的成交序列
+	fills := func(seed int64) []bool {
+		model, err := NewFillModel(FillModelProbabilisticTouch, 0.5, seed)
+		require.NoError(t, err)
+		order := &models.Order{Side: models.SideBuy, Price: dec("100"), Quantity: dec("1")}
+		var result []bool
+		for i := 0; i < 32; i++ {
+			volume := dec("1")
+			result = append(result, model.Fill(nil, order, dec("100"), &volume).IsPositive())
+		}
+		return result
+	}
+	assert.Equal(t, fills(DefaultFillSeed), fills(DefaultFillSeed))
+	assert.NotEqual(t, fills(DefaultFillSeed), fills(42))
+}
+
+func TestEngine_SettleFundingRollback(t *testing.T) {
+	engine, database := newTestEngine(t)
+
+	positions := store.NewPositionStore(database.DB)
+	require.NoError(t, positions.Save(&models.Position{APIKey: testAPIKey, Symbol: "BTCUSDT", PositionSide: models.PositionSideBoth,
+		Side: models.PositionSideLong, EntryPrice: dec("100"), Size: dec("1"), Leverage: 10, MarginType: models.MarginTypeIsolated, Margin: dec("10")}))
+	require.NoError(t, positions.Save(&models.Position{APIKey: "other", Symbol: "BTCUSDT", PositionSide: models.PositionSideBoth,
+		Side: models.PositionSideShort, EntryPrice: dec("100"), Size: dec("1"), Leverage: 10, MarginType: models.MarginTypeCrossed, Margin: dec("10")}))
+
+	// 第二个账户记账失败时，整个 symbol 的结算回滚
+	_, err := database.Exec(`CREATE TRIGGER fail_ledger BEFORE INSERT ON ledger WHEN NEW.api_key = 'other'
+		BEGIN SELECT RAISE(ABORT, 'ledger failure'); END`)
+	require.NoError(t, err)
+
+	_, err = engine.SettleFunding("BTCUSDT", dec("0.01"), dec("100"), time.Now())
+	require.Error(t, err)
+
+	var count int
+	require.NoError(t, database.QueryRow("SELECT COUNT(*) FROM funding_payments").Scan(&count))
+	assert.Equal(t, 0, count)
+	p, err := positions.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assertDecimal(t, "10", p.Margin)
+	balance, err := store.NewBalanceStore(database.DB).Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "0", balance.Frozen)
+}
+
+func TestEngine_SettleFundingOnce(t *testing.T) {
+	engine, database := newTestEngine(t)
+	fillLeg(t, engine, models.PositionSideLong, models.SideBuy, "1", "100")
+	fillLeg(t, engine, models.PositionSideShort, models.SideSell, "2", "100")
+	before, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+
+	// 双向持仓的两条腿在同一结算时间各收付一次
+	fundingTime := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	payments, err := engine.SettleFunding("BTCUSDT", dec("0.01"), dec("100"), fundingTime)
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+
+	// 同一结算时间再次触发时不重复收付
+	payments, err = engine.SettleFunding("BTCUSDT", dec("0.01"), dec("100"), fundingTime)
+	require.NoError(t, err)
+	assert.Empty(t, payments)
+
+	var count int
+	require.NoError(t, database.QueryRow("SELECT COUNT(*) FROM funding_payments").Scan(&count))
+	assert.Equal(t, 2, count)
+	after, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	// 多头付 1，空头收 2
+	assertDecimal(t, before.Available.Add(dec("1")).String(), after.Available)
+}
+
+func TestEngine_FreezeMarginRollback(t *testing.T) {
+	engine, database := newTestEngine(t)
+
+	// 冻结保证金的流水写入失败时订单也不写入
+	_, err := database.Exec(`CREATE TRIGGER fail_ledger BEFORE INSERT ON ledger
+		BEGIN SELECT RAISE(ABORT, 'ledger failure'); END`)
+	require.NoError(t, err)
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: models.OrderTypeLimit, Price: dec("100"), Quantity: dec("10"), Leverage: 10, Status: models.OrderStatusNew}
+	require.Error(t, engine.PlaceOrder(order))
+	assert.Zero(t, order.ID)
+
+	var count int
+	require.NoError(t, database.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count))
+	assert.Equal(t, 0, count)
+	balance, err := store.NewBalanceStore(database.DB).Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "10000", balance.Available)
+}
+
+func TestEngine_FeeTierRefresh(t *testing.T) {
+	engine, database := newTestEngine(t)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewSim(start)
+	engine.SetClock(clk)
+	require.NoError(t, engine.Load())
+
+	maker, _ := engine.FeeRates(testAPIKey)
+	assertDecimal(t, "0.0002", maker)
+
+	// 管理员在另一个进程中修改费率等级，内存中的副本在刷新间隔之后更新
+	_, err := database.Exec(`INSERT INTO config (key, value) VALUES ('fee_tiers', '{"MM":{"maker":"-0.0001","taker":"0.0003"}}')`)
+	require.NoError(t, err)
+	require.NoError(t, store.NewAccountStore(database.DB).SetFeeTier(testAPIKey, "MM"))
+	maker, _ = engine.FeeRates(testAPIKey)
+	assertDecimal(t, "0.0002", maker)
+
+	clk.Set(start.Add(feeRefreshInterval))
+	maker, taker := engine.FeeRates(testAPIKey)
+	assertDecimal(t, "-0.0001", maker)
+	assertDecimal(t, "0.0003", taker)
+}
+
+func TestEngine_OrderMargin(t *testing.T) {
+	engine, _ := newTestEngine(t)
+
+	// 10 倍杠杆挂单 10 @ 100，冻结 100
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("10"), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "9900", balance.Available)
+	assertDecimal(t, "100", balance.Frozen)
+
+	// 余额不足时拒绝
+	tooLarge := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("1000"), Leverage: 10, Status: models.OrderStatusNew}
+	assert.ErrorIs(t, engine.PlaceOrder(tooLarge), ErrMarginInsufficient)
+
+	// 撤单全部释放
+	_, err = engine.CancelOrder(testAPIKey, order.ID)
+	require.NoError(t, err)
+	balance, err = engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "10000", balance.Available)
+	assertDecimal(t, "0", balance.Frozen)
+}
+
+func TestEngine_MarketOrder(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Asks: []collector.DepthLevel{{Price: dec("100"), Quantity: dec("1")}, {Price: dec("101"), Quantity: dec("1")}, {Price: dec("102"), Quantity: dec("5")}},
+	}})
+
+	// 买入 1.5 吃掉两档：1 @ 100，0.5 @ 101
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: models.OrderTypeMarket, Quantity: dec("1.5"), Leverage: 10}
+	require.NoError(t, engine.PlaceOrder(order))
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+	assertDecimal(t, dec("150.5").Div(dec("1.5")).String(), order.AvgPrice)
+
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	fees := decimal.Zero
+	for _, tr := range trades {
+		assert.Equal(t, FillModelDepth, tr.FillModel)
+		fees = fees.Add(tr.Fee)
+	}
+	assertDecimal(t, "0.07525", fees) // 150.5 × 0.0005
+
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assertDecimal(t, "1.5", pos.Size)
+	assertDecimal(t, order.AvgPrice.String(), pos.EntryPrice)
+
+	// 深度不足时未成交部分过期
+	order = &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: models.OrderTypeMarket, Quantity: dec("10"), Leverage: 10}
+	require.NoError(t, engine.PlaceOrder(order))
+	assert.Equal(t, models.OrderStatusExpired, order.Status)
+	assertDecimal(t, "7", order.ExecutedQty)
+
+	// 没有对手盘
+	order = &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideSell,
+		Type: models.OrderTypeMarket, Quantity: dec("1"), Leverage: 10}
+	assert.ErrorIs(t, engine.PlaceOrder(order), ErrNoLiquidity)
+
+	// 按将要成交的名义价值保证金不足时拒绝，订单不写入
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Asks: []collector.DepthLevel{{Price: dec("100"), Quantity: dec("1000")}},
+	}})
+	order = &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: models.OrderTypeMarket, Quantity: dec("1000"), Leverage: 1}
+	assert.ErrorIs(t, engine.PlaceOrder(order), ErrMarginInsufficient)
+	assert.Zero(t, order.ID)
+	orders, err := engine.orderStore.GetByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	assert.Len(t, orders, 2)
+}
+
+func TestEngine_TimeInForce(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Bids: []collector.DepthLevel{{Price: dec("99"), Quantity: dec("1")}},
+		Asks: []collector.DepthLevel{{Price: dec("100"), Quantity: dec("1")}, {Price: dec("101"), Quantity: dec("1")}},
+	}})
+	limit := func(tif models.TimeInForce, price, qty string) *models.Order {
+		order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy, Type: models.OrderTypeLimit,
+			Price: dec(price), Quantity: dec(qty), TimeInForce: tif, Leverage: 10, Status: models.OrderStatusNew}
+		require.NoError(t, engine.PlaceOrder(order))
+		return order
+	}
+
+	// IOC 只吃限价以内的档位，剩余过期
+	order := limit(models.TimeInForceIOC, "100", "1.5")
+	assert.Equal(t, models.OrderStatusExpired, order.Status)
+	assertDecimal(t, "1", order.ExecutedQty)
+
+	// FOK 不能全部成交时整单过期
+	order = limit(models.TimeInForceFOK, "101", "3")
+	assert.Equal(t, models.OrderStatusExpired, order.Status)
+	assertDecimal(t, "0.0", order.ExecutedQty)
+
+	order = limit(models.TimeInForceFOK, "101", "2")
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+
+	// GTX 会立即成交时过期，否则正常挂单
+	order = limit(models.TimeInForceGTX, "100", "1")
+	assert.Equal(t, models.OrderStatusExpired, order.Status)
+	order = limit(models.TimeInForceGTX, "99.5", "1")
+	assert.Equal(t, models.OrderStatusNew, order.Status)
+
+	// 过期订单释放冻结保证金，只剩 GTX 挂单的 9.95 和持仓保证金
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assertDecimal(t, dec("9.95").Add(pos.Margin).String(), balance.Frozen)
+}
+
+func TestEngine_MakerTakerFees(t *testing.T) {
+	engine, database := newTestEngine(t)
+	_, err := database.Exec(`INSERT INTO config (key, value) VALUES ('fee_tiers', '{"MM":{"maker":"-0.0001","taker":"0.0003"}}')`)
+	require.NoError(t, err)
+	require.NoError(t, engine.accountStore.SetFeeTier(testAPIKey, "MM"))
+	require.NoError(t, engine.Load())
+	engine.fillModels["BTCUSDT"] = touchModel{}
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Asks: []collector.DepthLevel{{Price: dec("100"), Quantity: dec("1")}},
+	}})
+
+	// 提交时与卖一交叉：1 @ 100 作为 taker 成交，剩余 1 挂单
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: models.OrderTypeLimit, Price: dec("100"), Quantity: dec("2"), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+	assert.Equal(t, models.OrderStatusPartiallyFilled, order.Status)
+
+	// 挂单之后被行情撮合为 maker，按负费率返佣
+	engine.OnTrade(tick("BTCUSDT", "100", "5"))
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+
+	trades, err := engine.orderStore.GetTrades(testAPIKey, "BTCUSDT", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	assert.False(t, trades[0].IsMaker)
+	assertDecimal(t, "0.03", trades[0].Fee)
+	assert.True(t, trades[1].IsMaker)
+	assertDecimal(t, "-0.01", trades[1].Fee)
+	assert.Equal(t, "USDT", trades[1].CommissionAsset)
+
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "-0.02", balance.TotalPNL)
+}
+
+func TestEngine_ConditionalOrders(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Bids: []collector.DepthLevel{{Price: dec("94"), Quantity: dec("10")}},
+		Asks: []collector.DepthLevel{{Price: dec("106"), Quantity: dec("10")}},
+	}})
+	engine.OnTrade(tick("BTCUSDT", "100", "1"))
+
+	place := func(order *models.Order) *models.Order {
+		order.APIKey, order.Symbol, order.Leverage, order.Status = testAPIKey, "BTCUSDT", 10, models.OrderStatusNew
+		require.NoError(t, engine.PlaceOrder(order))
+		return order
+	}
+
+	// 最新价已越过触发价时拒绝
+	err := engine.PlaceOrder(&models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: models.OrderTypeStopMarket, StopPrice: dec("99"), Quantity: dec("1"), Leverage: 10, Status: models.OrderStatusNew})
+	assert.ErrorIs(t, err, ErrWouldTrigger)
+
+	stop := place(&models.Order{Side: models.SideBuy, Type: models.OrderTypeStopMarket, StopPrice: dec("105"), Quantity: dec("1")})
+	takeProfit := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeTakeProfit, StopPrice: dec("110"), Price: dec("90"), Quantity: dec("1")})
+	trailing := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeTrailingStopMarket,
+		ActivatePrice: dec("104"), PriceRate: dec("2"), Quantity: dec("1")})
+	cancelled := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeStopMarket, StopPrice: dec("50"), Quantity: dec("1")})
+
+	// 条件单在触发前不冻结保证金，可以撤销
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "0.0", balance.Frozen)
+	_, err = engine.CancelOrder(testAPIKey, cancelled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderStatusCancelled, cancelled.Status)
+
+	// 价格涨到 105 触发止损买单，按盘口卖一市价成交
+	engine.OnTrade(tick("BTCUSDT", "105", "1"))
+	require.NotNil(t, stop.TriggerTime)
+	assert.Equal(t, models.OrderStatusFilled, stop.Status)
+	assertDecimal(t, "106", stop.AvgPrice)
+	assert.Equal(t, models.OrderStatusNew, trailing.Status)
+
+	// 涨到 110 触发止盈卖单，限价 90 与买一 94 交叉成交
+	engine.OnTrade(tick("BTCUSDT", "110", "1"))
+	assert.Equal(t, models.OrderStatusFilled, takeProfit.Status)
+	assertDecimal(t, "94", takeProfit.AvgPrice)
+
+	// 追踪止损从最高价 110 回调 2% 以上才触发
+	engine.OnTrade(tick("BTCUSDT", "108", "1"))
+	assert.Nil(t, trailing.TriggerTime)
+	engine.OnTrade(tick("BTCUSDT", "107.7", "1"))
+	require.NotNil(t, trailing.TriggerTime)
+	assert.Equal(t, models.OrderStatusFilled, trailing.Status)
+}
+
+func TestEngine_LoadTriggeredMarketOrder(t *testing.T) {
+	engine, database := newTestEngine(t)
+	engine.OnTrade(tick("BTCUSDT", "100", "1"))
+
+	stop := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideSell,
+		Type: models.OrderTypeStopMarket, StopPrice: dec("95"), Quantity: dec("1"), Leverage: 10}
+	require.NoError(t, engine.PlaceOrder(stop))
+
+	// 触发后进程在执行前停止，订单已记录触发时间但没有成交
+	triggered := engine.match("BTCUSDT", dec("95"), dec("1"), engine.clock.Now(), 0)
+	require.Len(t, triggered, 1)
+
+	// 重启后触发过的市价条件单过期，不会作为价格为 0 的卖单挂入订单簿
+	restarted := NewEngine(database.DB)
+	require.NoError(t, restarted.Load())
+	assert.NotContains(t, restarted.books, "BTCUSDT")
+	restarted.OnTrade(tick("BTCUSDT", "90", "1"))
+
+	orders, err := restarted.orderStore.GetByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, models.OrderStatusExpired, orders[0].Status)
+	trades, err := restarted.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	assert.Empty(t, trades)
+	balance, err := restarted.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "10000", balance.Available)
+	assertDecimal(t, "0", balance.Frozen)
+}
+
+func TestEngine_ReduceOnly(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.fillModels["BTCUSDT"] = touchModel{}
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Bids: []collector.DepthLevel{{Price: dec("99"), Quantity: dec("10")}},
+		Asks: []collector.DepthLevel{{Price: dec("101"), Quantity: dec("10")}},
+	}})
+	place := func(order *models.Order) (*models.Order, error) {
+		order.APIKey, order.Symbol, order.Leverage = testAPIKey, "BTCUSDT", 10
+		return order, engine.PlaceOrder(order)
+	}
+
+	// 没有持仓时拒绝
+	_, err := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeLimit, Price: dec("105"), Quantity: dec("1"), ReduceOnly: true})
+	assert.ErrorIs(t, err, ErrReduceOnlyRejected)
+
+	_, err = place(&models.Order{Side: models.SideBuy, Type: models.OrderTypeMarket, Quantity: dec("1")})
+	require.NoError(t, err)
+
+	// 超出持仓的部分被缩小，两笔重复的平仓单都挂单且不冻结保证金
+	first, err := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeLimit, Price: dec("105"), Quantity: dec("2"), ReduceOnly: true})
+	require.NoError(t, err)
+	assertDecimal(t, "1.0", first.Quantity)
+	second, err := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeLimit, Price: dec("106"), Quantity: dec("1"), ReduceOnly: true})
+	require.NoError(t, err)
+	stop, err := place(&models.Order{Side: models.SideSell, Type: models.OrderTypeStopMarket, StopPrice: dec("90"), ClosePosition: true})
+	require.NoError(t, err)
+
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assertDecimal(t, pos.Margin.String(), balance.Frozen)
+
+	// 第一笔成交后持仓归零，其余只减仓订单自动撤销，不会反向开空
+	engine.OnTrade(tick("BTCUSDT", "106", "5"))
+	assert.Equal(t, models.OrderStatusFilled, first.Status)
+	assert.Equal(t, models.OrderStatusCancelled, second.Status)
+	assert.Equal(t, models.OrderStatusCancelled, stop.Status)
+	pos, err = engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, pos)
+}
+
+func TestEngine_ClosePosition(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	engine.SetDepthSource(staticDepth{&collector.Depth{
+		Bids: []collector.DepthLevel{{Price: dec("94"), Quantity: dec("10")}},
+		Asks: []collector.DepthLevel{{Price: dec("101"), Quantity: dec("10")}},
+	}})
+	engine.OnTrade(tick("BTCUSDT", "100", "1"))
+
+	stop := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideSell, Type: models.OrderTypeStopMarket,
+		StopPrice: dec("95"), Leverage: 10, ClosePosition: true}
+	require.NoError(t, engine.PlaceOrder(stop))
+	assertDecimal(t, "0.0", stop.Quantity)
+
+	// 下单后持仓加到 3，触发时平掉当时的全部持仓
+	for i := 0; i < 3; i++ {
+		require.NoError(t, engine.PlaceOrder(&models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+			Type: models.OrderTypeMarket, Quantity: dec("1"), Leverage: 10}))
+	}
+	engine.OnTrade(tick("BTCUSDT", "95", "1"))
+	assert.Equal(t, models.OrderStatusFilled, stop.Status)
+	assertDecimal(t, "3.0", stop.Quantity)
+
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, pos)
+}
+
+func TestEngine_Clock(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	clk := clock.NewSim(start)
+	engine.SetClock(clk)
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("1"), Leverage: 10}
+	require.NoError(t, engine.PlaceOrder(order))
+
+	// 成交时间取币安成交时间，订单更新时间取时钟
+	clk.Set(start.Add(time.Minute))
+	trade := tick("BTCUSDT", "99", "1")
+	trade.TradeTime = start.Add(59 * time.Second).UnixMilli()
+	engine.OnTrade(trade)
+	require.Equal(t, models.OrderStatusFilled, order.Status)
+
+	orders, err := engine.orderStore.GetByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.True(t, start.Equal(orders[0].CreatedAt), orders[0].CreatedAt)
+	assert.True(t, start.Add(time.Minute).Equal(orders[0].UpdatedAt), orders[0].UpdatedAt)
+
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.True(t, start.Add(59*time.Second).Equal(trades[0].Timestamp), trades[0].Timestamp)
+
+	position, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.True(t, start.Add(time.Minute).Equal(position.UpdatedAt), position.UpdatedAt)
+}
+
+func TestEngine_IdempotentFill(t *testing.T) {
+	engine, database := newTestEngine(t)
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("1"), Leverage: 10}
+	require.NoError(t, engine.PlaceOrder(order))
+
+	trade := tick("BTCUSDT", "100", "0.4")
+	trade.TradeID = 42
+	engine.OnTrade(trade)
+	assertDecimal(t, "0.4", order.ExecutedQty)
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+
+	// 重启后从数据库重建订单簿，重放同一笔行情不会重复成交
+	restarted := NewEngine(database.DB)
+	require.NoError(t, restarted.Load())
+	restarted.OnTrade(trade)
+
+	trades, err := restarted.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.Equal(t, fmt.Sprintf("42:%d", order.ID), trades[0].FillKey)
+	after, err := restarted.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assert.Equal(t, balance, after)
+
+	// 下一笔行情正常成交
+	trade.TradeID = 43
+	restarted.OnTrade(trade)
+	orders, err := restarted.orderStore.GetOpen()
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assertDecimal(t, "0.8", orders[0].ExecutedQty)
+}
+
+func TestEngine_FillRollback(t *testing.T) {
+	engine, database := newTestEngine(t)
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideBuy,
+		Type: "LIMIT", Price: dec("100"), Quantity: dec("1"), Leverage: 10}
+	require.NoError(t, engine.PlaceOrder(order))
+	before, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+
+	// 余额写入失败时，同一事务内的成交、订单状态和持仓全部回滚
+	_, err = database.Exec(`CREATE TRIGGER fail_balance BEFORE UPDATE ON balances BEGIN SELECT RAISE(ABORT, 'boom'); END`)
+	require.NoError(t, err)
+	engine.OnTrade(tick("BTCUSDT", "99", "1"))
+
+	assert.Equal(t, models.OrderStatusNew, order.Status)
+	assert.True(t, order.ExecutedQty.IsZero())
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	assert.Empty(t, trades)
+	orders, err := engine.orderStore.GetOpen()
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, models.OrderStatusNew, orders[0].Status)
+	position, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, position)
+
+	// 故障恢复后下一笔行情正常结算
+	_, err = database.Exec(`DROP TRIGGER fail_balance`)
+	require.NoError(t, err)
+	engine.OnTrade(tick("BTCUSDT", "99", "1"))
+	assert.Equal(t, models.OrderStatusFilled, order.Status)
+	after, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	// 钱包余额只减少了手续费 99 × 0.0002
+	assertDecimal(t, before.Available.Add(before.Frozen).Sub(dec("0.0198")).String(), after.Available.Add(after.Frozen))
+}
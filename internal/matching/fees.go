@@ -0,0 +1,105 @@
+package matching
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// feeRefreshInterval 重新读取费率配置、账户费率等级和交易对计价资产的间隔
+// 管理员通过 cmd/admin 在另一个进程中修改，撮合时只读内存中的副本，修改最迟在一个间隔后生效
+const feeRefreshInterval = 10 * time.Second
+
+// feeRates 手续费率，maker 为负数时表示返佣
+type feeRates struct {
+	Maker decimal.Decimal `json:"maker"`
+	Taker decimal.Decimal `json:"taker"`
+}
+
+// loadFees 从 config 表读取默认 maker/taker 手续费率和费率等级，并读取各账户的费率等级和交易对的计价资产
+// fee_tiers 格式：{"VIP1":{"maker":0.00016,"taker":0.0004},"MM":{"maker":-0.00005,"taker":0.0003}}
+// 调用方需持有 e.mu
+func (e *Engine) loadFees() {
+	e.feesLoadedAt = e.clock.Now()
+
+	if v, err := e.configStore.Get("trade_fee_maker"); err == nil {
+		if rate, err := decimal.NewFromString(v); err == nil {
+			e.fees.Maker = rate
+		}
+	}
+	if v, err := e.configStore.Get("trade_fee_taker"); err == nil {
+		if rate, err := decimal.NewFromString(v); err == nil {
+			e.fees.Taker = rate
+		}
+	}
+
+	if tiers, err := e.accountStore.GetFeeTiers(); err != nil {
+		log.Printf("Error loading account fee tiers: %v", err)
+	} else {
+		e.accountTiers = tiers
+	}
+	if symbols, err := e.symbolStore.List(); err != nil {
+		log.Printf("Error loading symbols: %v", err)
+	} else {
+		e.quoteAssets = make(map[string]string, len(symbols))
+		for _, sym := range symbols {
+			e.quoteAssets[sym.Symbol] = sym.QuoteAsset
+		}
+	}
+
+	feeTiers := make(map[string]feeRates)
+	v, err := e.configStore.Get("fee_tiers")
+	if err != nil || v == "" {
+		e.feeTiers = feeTiers
+		return
+	}
+	if err := json.Unmarshal([]byte(v), &feeTiers); err != nil {
+		log.Printf("Invalid fee_tiers config: %v", err)
+		return
+	}
+	e.feeTiers = feeTiers
+}
+
+// refreshFees 距上次读取超过 feeRefreshInterval（或时钟被换成更早的模拟时钟）时重新读取，调用方需持有 e.mu
+func (e *Engine) refreshFees() {
+	if elapsed := e.clock.Now().Sub(e.feesLoadedAt); elapsed >= feeRefreshInterval || elapsed < 0 {
+		e.loadFees()
+	}
+}
+
+// FeeRates 账户当前的 maker/taker 费率，未分配费率等级或等级不存在时使用默认费率
+func (e *Engine) FeeRates(apiKey string) (maker, taker decimal.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rates := e.accountFees(apiKey)
+	return rates.Maker, rates.Taker
+}
+
+// feeRate 返回一笔成交适用的费率，调用方需持有 e.mu
+func (e *Engine) feeRate(apiKey string, maker bool) decimal.Decimal {
+	rates := e.accountFees(apiKey)
+	if maker {
+		return rates.Maker
+	}
+	return rates.Taker
+}
+
+func (e *Engine) accountFees(apiKey string) feeRates {
+	e.refreshFees()
+	if rates, ok := e.feeTiers[e.accountTiers[apiKey]]; ok {
+		return rates
+	}
+	return e.fees
+}
+
+// commissionAsset 手续费币种，即交易对的计价资产，调用方需持有 e.mu
+func (e *Engine) commissionAsset(symbol string) string {
+	e.refreshFees()
+	if asset, ok := e.quoteAssets[symbol]; ok {
+		return asset
+	}
+	return "USDT"
+}
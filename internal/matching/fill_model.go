@@ -0,0 +1,167 @@
+package matching
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+)
+
+// 内置成交模型名称，对应 config 表中的取值
+const (
+	FillModelPriceThrough       = "price_through"
+	FillModelTouch              = "touch"
+	FillModelProbabilisticTouch = "probabilistic_touch"
+	FillModelQueue              = "queue"
+)
+
+// FillModel 成交模型：决定一笔市场成交能让触及的挂单成交多少
+type FillModel interface {
+	// Name 模型名称，记录在每笔成交上
+	Name() string
+	// Fill 返回订单在本笔成交中的成交数量，0 表示不成交
+	// volume 为本笔成交尚未分配给其他挂单的量，模型可以消耗它
+	Fill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal
+}
+
+// DefaultFillSeed probabilistic_touch 模型默认的随机数种子
+const DefaultFillSeed int64 = 1
+
+// NewFillModel 按名称创建内置成交模型
+// touchProbability 和 seed 只用于 probabilistic_touch 模型，相同的种子和行情得到相同的成交
+func NewFillModel(name string, touchProbability float64, seed int64) (FillModel, error) {
+	switch name {
+	case FillModelPriceThrough:
+		return priceThroughModel{}, nil
+	case FillModelTouch:
+		return touchModel{}, nil
+	case FillModelProbabilisticTouch:
+		return &probabilisticTouchModel{
+			probability: touchProbability,
+			rand:        rand.New(rand.NewSource(seed)),
+		}, nil
+	case FillModelQueue:
+		return queueModel{}, nil
+	}
+	return nil, fmt.Errorf("unknown fill model %q", name)
+}
+
+// crossed 成交价是否严格击穿限价
+func crossed(order *models.Order, tradePrice decimal.Decimal) bool {
+	if order.Side == models.SideBuy {
+		return tradePrice.LessThan(order.Price)
+	}
+	return tradePrice.GreaterThan(order.Price)
+}
+
+func remaining(order *models.Order) decimal.Decimal {
+	return order.Quantity.Sub(order.ExecutedQty)
+}
+
+// priceThroughModel 价格击穿（严格不等）时全部成交，不考虑成交量
+type priceThroughModel struct{}
+
+func (priceThroughModel) Name() string { return FillModelPriceThrough }
+
+func (priceThroughModel) Fill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal {
+	if !crossed(order, tradePrice) {
+		return decimal.Zero
+	}
+	return remaining(order)
+}
+
+// touchModel 成交价触及限价即全部成交
+type touchModel struct{}
+
+func (touchModel) Name() string { return FillModelTouch }
+
+func (touchModel) Fill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal {
+	return remaining(order)
+}
+
+// probabilisticTouchModel 击穿时全部成交，恰好触及时按概率全部成交
+type probabilisticTouchModel struct {
+	probability float64
+	rand        *rand.Rand
+}
+
+func (m *probabilisticTouchModel) Name() string { return FillModelProbabilisticTouch }
+
+func (m *probabilisticTouchModel) Fill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal {
+	if crossed(order, tradePrice) || m.rand.Float64() < m.probability {
+		return remaining(order)
+	}
+	return decimal.Zero
+}
+
+// queueModel 按排队位置和真实成交量部分成交
+type queueModel struct{}
+
+func (queueModel) Name() string { return FillModelQueue }
+
+func (queueModel) Fill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal {
+	return queueFill(book, order, tradePrice, volume)
+}
+
+// loadFillModels 从 config 表读取默认成交模型和按 symbol 覆盖的成交模型
+// 配置无效时记录日志并回退到排队模型
+func (e *Engine) loadFillModels() {
+	probability := 0.5
+	if v, err := e.configStore.Get("fill_touch_probability"); err == nil {
+		if p, err := strconv.ParseFloat(v, 64); err == nil {
+			probability = p
+		}
+	}
+	seed := e.fillSeed
+	if seed == 0 {
+		seed = DefaultFillSeed
+		if v, err := e.configStore.Get("fill_touch_seed"); err == nil {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n != 0 {
+				seed = n
+			}
+		}
+	}
+
+	name := FillModelQueue
+	if v, err := e.configStore.Get("fill_model"); err == nil && v != "" {
+		name = v
+	}
+	model, err := NewFillModel(name, probability, seed)
+	if err != nil {
+		log.Printf("Invalid fill_model config: %v", err)
+		model = queueModel{}
+	}
+	e.defaultFill = model
+
+	e.fillModels = make(map[string]FillModel)
+	v, err := e.configStore.Get("symbol_fill_models")
+	if err != nil || v == "" {
+		return
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(v), &overrides); err != nil {
+		log.Printf("Invalid symbol_fill_models config: %v", err)
+		return
+	}
+	for symbol, name := range overrides {
+		model, err := NewFillModel(name, probability, seed)
+		if err != nil {
+			log.Printf("Invalid fill model for %s: %v", symbol, err)
+			continue
+		}
+		e.fillModels[symbol] = model
+	}
+}
+
+// fillModel 返回 symbol 使用的成交模型，调用方需持有 e.mu
+func (e *Engine) fillModel(symbol string) FillModel {
+	if m, ok := e.fillModels[symbol]; ok {
+		return m
+	}
+	return e.defaultFill
+}
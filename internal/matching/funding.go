@@ -0,0 +1,83 @@
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+// SettleFunding 按资金费率结算 symbol 上的全部持仓，返回每个持仓的收付记录
+// 资金费 = 持仓数量 × 标记价格 × 费率，费率为正时多头支付、空头收取，为负时相反
+// 全仓持仓从可用余额收付，逐仓持仓从持仓保证金收付
+// 费率记录、收付记录、逐仓保证金和资金流水在一个事务内写入，任一步失败时整个 symbol 的结算全部回滚
+// 同一 fundingTime 重复结算时已收付过的持仓被跳过，不出现在返回的记录中
+func (e *Engine) SettleFunding(symbol string, rate, markPrice decimal.Decimal, fundingTime time.Time) ([]models.FundingPayment, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	positions, err := e.positionStore.GetBySymbol(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	ftx, err := e.beginFill()
+	if err != nil {
+		return nil, err
+	}
+	defer ftx.tx.Rollback()
+	fundingStore := e.fundingStore.WithTx(ftx.tx)
+	err = fundingStore.CreateRate(&models.FundingRate{Symbol: symbol, FundingRate: rate, MarkPrice: markPrice, FundingTime: fundingTime})
+	if err != nil {
+		return nil, err
+	}
+
+	payments := make([]models.FundingPayment, 0, len(positions))
+	for i := range positions {
+		p := &positions[i]
+		payment := models.FundingPayment{
+			APIKey:       p.APIKey,
+			Symbol:       p.Symbol,
+			PositionSide: p.PositionSide,
+			PositionSize: p.Size,
+			MarkPrice:    markPrice,
+			FundingRate:  rate,
+			Amount:       direction(p.Side).Neg().Mul(p.Size).Mul(markPrice).Mul(rate),
+			FundingTime:  fundingTime,
+		}
+		if err := fundingStore.CreatePayment(&payment); err != nil {
+			if errors.Is(err, store.ErrDuplicateFunding) {
+				log.Printf("Funding for %s %s %s at %s already settled, skipped",
+					p.APIKey, p.Symbol, p.PositionSide, fundingTime.UTC().Format(time.RFC3339))
+				continue
+			}
+			return nil, err
+		}
+
+		entry := &models.LedgerEntry{
+			APIKey: p.APIKey, Type: models.LedgerTypeFundingFee, Symbol: p.Symbol,
+			Debit: models.LedgerAccountExternal, Credit: models.LedgerAccountAvailable, Amount: payment.Amount,
+			Info: fmt.Sprintf("%s rate %s", p.PositionSide, rate),
+		}
+		if p.MarginType == models.MarginTypeIsolated {
+			p.Margin = p.Margin.Add(payment.Amount)
+			entry.Credit = models.LedgerAccountFrozen
+			if err := ftx.positions.Save(p); err != nil {
+				return nil, err
+			}
+		}
+		if err := ftx.ledger.Post(entry); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	if err := ftx.tx.Commit(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,195 @@
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+)
+
+// LiquidationCheck 按持仓的最新状态重新判断是否仍需强平，返回平仓价格；不再需要强平时返回 false
+type LiquidationCheck func(current *models.Position) (decimal.Decimal, bool)
+
+// Liquidate 强平持仓：撤销账户在该 symbol 上的全部挂单，按 check 给出的价格生成一笔强平成交平掉整条腿
+// 调用方的 position 可能已经过时，是否强平和平仓价格都由 check 按持有 e.mu 时读取的最新持仓决定
+func (e *Engine) Liquidate(position *models.Position, check LiquidationCheck) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// 持仓可能已被其他成交改变，以数据库中的最新状态为准
+	current, err := e.positionStore.Get(position.APIKey, position.Symbol, position.PositionSide)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+	price, ok := check(current)
+	if !ok {
+		return nil
+	}
+
+	now := e.clock.Now()
+	side := models.SideSell
+	if current.Side == models.PositionSideShort {
+		side = models.SideBuy
+	}
+	order := &models.Order{
+		APIKey:        current.APIKey,
+		Symbol:        current.Symbol,
+		Side:          side,
+		Type:          models.OrderTypeLimit,
+		Price:         price,
+		Quantity:      current.Size,
+		Leverage:      current.Leverage,
+		PositionSide:  current.PositionSide,
+		ClientOrderID: fmt.Sprintf("autoclose-%d", now.UnixMilli()),
+	}
+	trade := &models.Trade{
+		APIKey:        order.APIKey,
+		Symbol:        order.Symbol,
+		Side:          order.Side,
+		Price:         price,
+		Quantity:      order.Quantity,
+		QuoteQty:      order.Quantity.Mul(price),
+		IsLiquidation: true,
+		Timestamp:     now,
+	}
+
+	// 撤单、强平订单、成交、持仓和余额在一个事务内写入，失败时挂单和持仓都保持不变
+	ftx, err := e.beginFill()
+	if err != nil {
+		return err
+	}
+	defer ftx.tx.Rollback()
+
+	cancelled, err := e.cancelSymbolOrders(ftx, current.APIKey, current.Symbol)
+	if err != nil {
+		return err
+	}
+	if err := ftx.orders.Create(order); err != nil {
+		return err
+	}
+	if err := ftx.orders.UpdateStatus(order.ID, models.OrderStatusFilled, order.Quantity, price); err != nil {
+		return err
+	}
+	trade.OrderID = order.ID
+	if err := ftx.orders.CreateTrade(trade); err != nil {
+		return err
+	}
+
+	change, err := e.updatePosition(ftx, order, order.Quantity, price)
+	if err != nil {
+		return err
+	}
+	if err := e.updateBalance(ftx, order, change, trade); err != nil {
+		return err
+	}
+	if err := ftx.tx.Commit(); err != nil {
+		return err
+	}
+	e.removeCancelled(current.Symbol, cancelled)
+
+	log.Printf("Liquidated %s %s %s position %s @ %s, realized %s",
+		current.APIKey, current.Symbol, current.PositionSide, current.Size, price, change.realizedPNL)
+	return nil
+}
+
+// cancelSymbolOrders 在事务 ftx 内撤销账户在某个 symbol 上的全部挂单和条件单，释放挂单冻结的保证金，返回被撤销的订单
+// 事务提交后由调用方用 removeCancelled 更新内存中的订单，调用方需持有 e.mu
+func (e *Engine) cancelSymbolOrders(ftx *fillTx, apiKey, symbol string) ([]*models.Order, error) {
+	var cancelled []*models.Order
+	if triggers, ok := e.triggers[symbol]; ok {
+		for _, order := range triggers.ByAPIKey(apiKey) {
+			if err := ftx.orders.Cancel(order.ID); err != nil {
+				return nil, err
+			}
+			cancelled = append(cancelled, order)
+		}
+	}
+
+	if book, ok := e.books[symbol]; ok {
+		for _, order := range book.ByAPIKey(apiKey) {
+			if err := ftx.orders.Cancel(order.ID); err != nil {
+				return nil, err
+			}
+			if err := ftx.ledger.Post(releaseEntry(order, order.Quantity.Sub(order.ExecutedQty))); err != nil {
+				return nil, err
+			}
+			cancelled = append(cancelled, order)
+		}
+	}
+	return cancelled, nil
+}
+
+// removeCancelled 把已在数据库中撤销的订单从 symbol 的订单簿和触发簿移除，调用方需持有 e.mu
+func (e *Engine) removeCancelled(symbol string, orders []*models.Order) {
+	for _, order := range orders {
+		if triggers, ok := e.triggers[symbol]; ok {
+			triggers.Remove(order.ID)
+		}
+		if book, ok := e.books[symbol]; ok {
+			book.Remove(order.ID)
+		}
+		order.Status = models.OrderStatusCancelled
+	}
+}
+
+// 保证金相关的错误
+var (
+	ErrPositionNotFound            = errors.New("position not found")
+	ErrNotIsolated                 = errors.New("add margin only support for isolated position")
+	ErrMarginInsufficient          = errors.New("margin is insufficient")
+	ErrIsolatedBalanceInsufficient = errors.New("isolated balance insufficient")
+)
+
+// AdjustIsolatedMargin 追加（amount > 0）或减少（amount < 0）逐仓持仓的保证金
+// 追加不能超过可用余额；减少后保证金不能低于按开仓均价计算的初始保证金
+// 持仓保证金和资金流水在一个事务内写入，失败时都不写入
+func (e *Engine) AdjustIsolatedMargin(apiKey, symbol string, positionSide models.PositionSide, amount decimal.Decimal) (*models.Position, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ftx, err := e.beginFill()
+	if err != nil {
+		return nil, err
+	}
+	defer ftx.tx.Rollback()
+
+	position, err := ftx.positions.Get(apiKey, symbol, positionSide)
+	if err != nil {
+		return nil, err
+	}
+	if position == nil {
+		return nil, ErrPositionNotFound
+	}
+	if position.MarginType != models.MarginTypeIsolated {
+		return nil, ErrNotIsolated
+	}
+
+	balance, err := e.balanceStore.WithTx(ftx.tx).Get(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if amount.IsPositive() && amount.GreaterThan(balance.Available) {
+		return nil, ErrMarginInsufficient
+	}
+	if amount.IsNegative() && position.Margin.Add(amount).LessThan(initialMargin(position.Size, position.EntryPrice, position.Leverage)) {
+		return nil, ErrIsolatedBalanceInsufficient
+	}
+
+	position.Margin = position.Margin.Add(amount)
+	if err := ftx.positions.Save(position); err != nil {
+		return nil, err
+	}
+	if err := ftx.ledger.Post(marginEntry(apiKey, symbol, amount, "isolated margin")); err != nil {
+		return nil, err
+	}
+	if err := ftx.tx.Commit(); err != nil {
+		return nil, err
+	}
+	return position, nil
+}
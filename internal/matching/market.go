@@ -0,0 +1,118 @@
+package matching
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/collector"
+	"hft-sim/internal/models"
+)
+
+// ErrNoLiquidity 取不到盘口深度或对手盘为空，市价单无法成交
+var ErrNoLiquidity = errors.New("unable to fill")
+
+// FillModelDepth 吃单成交记录的 fillModel，表示按盘口深度成交
+const FillModelDepth = "depth"
+
+// depthFill 吃单在一个盘口档位上的成交
+type depthFill struct {
+	price decimal.Decimal
+	qty   decimal.Decimal
+}
+
+// sweepDepth 按价格优先逐档吃掉对手盘，返回每档成交，深度不足时只成交可成交部分
+// 买单吃卖盘（价格由低到高），卖单吃买盘（价格由高到低），币安深度快照已按此顺序排列
+// limitPrice 大于 0 时只吃价格不劣于限价的档位
+func sweepDepth(depth *collector.Depth, side models.Side, qty, limitPrice decimal.Decimal) []depthFill {
+	levels := depth.Asks
+	if side == models.SideSell {
+		levels = depth.Bids
+	}
+
+	var fills []depthFill
+	for _, l := range levels {
+		if !qty.IsPositive() {
+			break
+		}
+		if limitPrice.IsPositive() && (side == models.SideBuy && l.Price.GreaterThan(limitPrice) || side == models.SideSell && l.Price.LessThan(limitPrice)) {
+			break
+		}
+		if !l.Quantity.IsPositive() {
+			continue
+		}
+		take := decimal.Min(l.Quantity, qty)
+		fills = append(fills, depthFill{price: l.Price, qty: take})
+		qty = qty.Sub(take)
+	}
+	return fills
+}
+
+// wouldTake 限价单按当前盘口是否会立即成交
+func wouldTake(depth *collector.Depth, order *models.Order) bool {
+	if order.Side == models.SideBuy {
+		return len(depth.Asks) > 0 && order.Price.GreaterThanOrEqual(depth.Asks[0].Price)
+	}
+	return len(depth.Bids) > 0 && order.Price.LessThanOrEqual(depth.Bids[0].Price)
+}
+
+// placeTaker 市价单（含触发后的市价条件单）和 IOC/FOK 限价单按真实盘口逐档立即成交，每档生成一条成交记录并收取 taker 手续费
+// 未成交部分过期；FOK 不能全部成交时整单过期
+func (e *Engine) placeTaker(order *models.Order) error {
+	market := models.IsMarketType(order.Type)
+
+	// 在加锁前获取深度，避免网络请求阻塞撮合
+	depth := e.fetchDepth(order.Symbol)
+	if depth == nil {
+		return ErrNoLiquidity
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if isReduceOnly(order) {
+		if err := e.sizeReduceOnly(order); err != nil {
+			return err
+		}
+	}
+
+	fills := sweepDepth(depth, order.Side, order.Quantity, order.Price)
+	if market && len(fills) == 0 {
+		return ErrNoLiquidity
+	}
+	if order.TimeInForce == models.TimeInForceFOK {
+		filled := decimal.Zero
+		for _, f := range fills {
+			filled = filled.Add(f.qty)
+		}
+		if filled.LessThan(order.Quantity) {
+			fills = nil
+		}
+	}
+
+	// 市价单没有限价，按将要成交的名义价值检查保证金
+	required := orderMargin(order, order.Quantity)
+	if market && !isReduceOnly(order) {
+		notional := decimal.Zero
+		for _, f := range fills {
+			notional = notional.Add(f.price.Mul(f.qty))
+		}
+		required = notional.Div(decimal.NewFromInt(int64(order.Leverage)))
+	}
+	if err := e.freezeOrderMargin(order, required); err != nil {
+		return err
+	}
+
+	book := e.book(order.Symbol)
+	for _, f := range fills {
+		if !isOpen(order) {
+			break
+		}
+		e.matchOrder(book, order, f.price, f.qty, FillModelDepth, false, e.clock.Now(), 0)
+	}
+
+	if isOpen(order) {
+		return e.expire(order)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package matching
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+)
+
+// positionSideOf 订单方向对应的持仓方向
+func positionSideOf(side models.Side) models.PositionSide {
+	if side == models.SideSell {
+		return models.PositionSideShort
+	}
+	return models.PositionSideLong
+}
+
+// direction 多头为 1，空头为 -1
+func direction(side models.PositionSide) decimal.Decimal {
+	if side == models.PositionSideShort {
+		return decimal.NewFromInt(-1)
+	}
+	return decimal.NewFromInt(1)
+}
+
+// initialMargin qty 数量按 price 和杠杆计算的初始保证金
+func initialMargin(qty, price decimal.Decimal, leverage int) decimal.Decimal {
+	return qty.Mul(price).Div(decimal.NewFromInt(int64(leverage)))
+}
+
+// positionChange 一笔成交对账户资金的影响
+type positionChange struct {
+	realizedPNL decimal.Decimal
+	marginDelta decimal.Decimal // 正数为新锁定到持仓上的保证金，负数为从持仓释放的保证金
+}
+
+// updatePosition 在结算事务内按成交更新持仓，返回实现盈亏和保证金变化
+func (e *Engine) updatePosition(ftx *fillTx, order *models.Order, qty, price decimal.Decimal) (positionChange, error) {
+	if order.PositionSide == models.PositionSideLong || order.PositionSide == models.PositionSideShort {
+		return e.updateHedgePosition(ftx, order, qty, price)
+	}
+	return e.updateOneWayPosition(ftx, order, qty, price)
+}
+
+// updateOneWayPosition 单向持仓模式下的净额计算
+// 同向成交加仓并按数量加权更新开仓均价；反向成交依次减仓、平仓，超出部分反向开仓
+func (e *Engine) updateOneWayPosition(ftx *fillTx, order *models.Order, qty, price decimal.Decimal) (positionChange, error) {
+	var change positionChange
+
+	position, err := ftx.positions.Get(order.APIKey, order.Symbol, models.PositionSideBoth)
+	if err != nil {
+		return change, err
+	}
+
+	side := positionSideOf(order.Side)
+
+	// 无持仓：开新仓
+	if position == nil {
+		return e.openPosition(ftx, order, models.PositionSideBoth, side, qty, price)
+	}
+
+	// 同向：加仓
+	if position.Side == side {
+		change.marginDelta = addToPosition(position, qty, price)
+		return change, ftx.positions.Save(position)
+	}
+
+	// 反向：先平掉已有持仓
+	remaining := position.Size.Sub(qty)
+	change.realizedPNL, change.marginDelta = reducePosition(position, qty, price)
+
+	switch {
+	case remaining.IsZero():
+		// 全部平仓
+		return change, ftx.positions.Delete(order.APIKey, order.Symbol, models.PositionSideBoth)
+	case remaining.IsPositive():
+		// 部分平仓，开仓均价不变
+		return change, ftx.positions.Save(position)
+	default:
+		// 反手：平掉原持仓后剩余数量反向开仓
+		opened, err := e.openPosition(ftx, order, models.PositionSideBoth, side, remaining.Neg(), price)
+		change.marginDelta = change.marginDelta.Add(opened.marginDelta)
+		return change, err
+	}
+}
+
+// updateHedgePosition 双向持仓模式下只更新订单指定的那条腿
+// 与腿同向的成交开仓或加仓，反向成交减仓或平仓，不会反手到另一条腿
+func (e *Engine) updateHedgePosition(ftx *fillTx, order *models.Order, qty, price decimal.Decimal) (positionChange, error) {
+	var change positionChange
+
+	leg := order.PositionSide
+	position, err := ftx.positions.Get(order.APIKey, order.Symbol, leg)
+	if err != nil {
+		return change, err
+	}
+
+	if positionSideOf(order.Side) == leg {
+		if position == nil {
+			return e.openPosition(ftx, order, leg, leg, qty, price)
+		}
+		change.marginDelta = addToPosition(position, qty, price)
+		return change, ftx.positions.Save(position)
+	}
+
+	// 平仓数量在撮合前已限制在持仓以内，超出说明成交与持仓不一致，回滚整笔成交
+	if position == nil || qty.GreaterThan(position.Size) {
+		return change, fmt.Errorf("order %d closes %s more than the %s position", order.ID, qty, leg)
+	}
+
+	remaining := position.Size.Sub(qty)
+	change.realizedPNL, change.marginDelta = reducePosition(position, qty, price)
+	if remaining.IsZero() {
+		return change, ftx.positions.Delete(order.APIKey, order.Symbol, leg)
+	}
+	return change, ftx.positions.Save(position)
+}
+
+// openPosition 按账户在该 symbol 上的保证金模式开新仓，锁定初始保证金
+func (e *Engine) openPosition(ftx *fillTx, order *models.Order, positionSide, side models.PositionSide, qty, price decimal.Decimal) (positionChange, error) {
+	marginType, err := ftx.accounts.GetMarginType(order.APIKey, order.Symbol)
+	if err != nil {
+		return positionChange{}, err
+	}
+
+	position := &models.Position{
+		APIKey:       order.APIKey,
+		Symbol:       order.Symbol,
+		PositionSide: positionSide,
+		Side:         side,
+		EntryPrice:   price,
+		Size:         qty,
+		Leverage:     order.Leverage,
+		MarginType:   marginType,
+		Margin:       initialMargin(qty, price, order.Leverage),
+	}
+	return positionChange{marginDelta: position.Margin}, ftx.positions.Save(position)
+}
+
+// addToPosition 加仓，按数量加权更新开仓均价，返回新增锁定的保证金
+func addToPosition(position *models.Position, qty, price decimal.Decimal) decimal.Decimal {
+	size := position.Size.Add(qty)
+	position.EntryPrice = position.EntryPrice.Mul(position.Size).Add(price.Mul(qty)).Div(size)
+	position.Size = size
+
+	added := initialMargin(qty, price, position.Leverage)
+	position.Margin = position.Margin.Add(added)
+	return added
+}
+
+// reducePosition 用反向成交减仓，返回实现盈亏和释放的保证金（负数）
+// 保证金按平仓比例释放，逐仓追加的保证金也按比例返还；开仓均价不变
+func reducePosition(position *models.Position, qty, price decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	closeQty := decimal.Min(qty, position.Size)
+	realizedPNL := closeQty.Mul(price.Sub(position.EntryPrice)).Mul(direction(position.Side))
+
+	released := position.Margin
+	if remaining := position.Size.Sub(qty); remaining.IsPositive() {
+		released = position.Margin.Mul(closeQty).Div(position.Size)
+		position.Size = remaining
+		position.Margin = position.Margin.Sub(released)
+	}
+	return realizedPNL, released.Neg()
+}
+
+// updateBalance 将成交的实现盈亏、手续费和保证金划转记入资金流水
+func (e *Engine) updateBalance(ftx *fillTx, order *models.Order, change positionChange, trade *models.Trade) error {
+	entries := []*models.LedgerEntry{
+		{
+			APIKey: order.APIKey, Type: models.LedgerTypeRealizedPNL, Symbol: order.Symbol, TradeID: trade.ID,
+			Debit: models.LedgerAccountExternal, Credit: models.LedgerAccountAvailable, Amount: change.realizedPNL,
+		},
+		{
+			APIKey: order.APIKey, Type: models.LedgerTypeCommission, Symbol: order.Symbol, TradeID: trade.ID,
+			Debit: models.LedgerAccountAvailable, Credit: models.LedgerAccountExternal, Amount: trade.Fee,
+		},
+	}
+	margin := marginEntry(order.APIKey, order.Symbol, change.marginDelta, fmt.Sprintf("order %d", order.ID))
+	margin.TradeID = trade.ID
+	return ftx.ledger.Post(append(entries, margin)...)
+}
+
+// marginEntry 在可用余额和冻结保证金之间划转 amount 的流水，正数为冻结、负数为释放
+func marginEntry(apiKey, symbol string, amount decimal.Decimal, info string) *models.LedgerEntry {
+	entry := &models.LedgerEntry{
+		APIKey: apiKey, Type: models.LedgerTypeMarginFreeze, Symbol: symbol, Info: info,
+		Debit: models.LedgerAccountAvailable, Credit: models.LedgerAccountFrozen, Amount: amount,
+	}
+	if amount.IsNegative() {
+		entry.Type = models.LedgerTypeMarginRelease
+		entry.Debit, entry.Credit, entry.Amount = models.LedgerAccountFrozen, models.LedgerAccountAvailable, amount.Neg()
+	}
+	return entry
+}
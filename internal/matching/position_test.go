@@ -0,0 +1,247 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/models"
+)
+
+// fill 以 touch 模型挂单并立即用一笔成交打满
+func fill(t *testing.T, engine *Engine, side models.Side, qty, price string) {
+	t.Helper()
+	fillLeg(t, engine, models.PositionSideBoth, side, qty, price)
+}
+
+func fillLeg(t *testing.T, engine *Engine, leg models.PositionSide, side models.Side, qty, price string) {
+	t.Helper()
+	engine.fillModels["BTCUSDT"] = touchModel{}
+
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: side, PositionSide: leg,
+		Type: "LIMIT", Price: dec(price), Quantity: dec(qty), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+	engine.OnTrade(tick("BTCUSDT", price, "1000"))
+	require.Equal(t, models.OrderStatusFilled, order.Status)
+}
+
+func TestEngine_PositionNetting(t *testing.T) {
+	engine, _ := newTestEngine(t)
+
+	// 开多 1 @ 100，加仓 1 @ 110，均价 105
+	fill(t, engine, models.SideBuy, "1", "100")
+	fill(t, engine, models.SideBuy, "1", "110")
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Equal(t, models.PositionSideLong, pos.Side)
+	assertDecimal(t, "2", pos.Size)
+	assertDecimal(t, "105", pos.EntryPrice)
+
+	// 减仓 0.5 @ 120，实现盈亏 7.5
+	fill(t, engine, models.SideSell, "0.5", "120")
+	pos, err = engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assertDecimal(t, "1.5", pos.Size)
+	assertDecimal(t, "105", pos.EntryPrice)
+
+	// 减仓超过一半 1 @ 105，不应反手
+	fill(t, engine, models.SideSell, "1", "105")
+	pos, err = engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Equal(t, models.PositionSideLong, pos.Side)
+	assertDecimal(t, "0.5", pos.Size)
+
+	// 加回 1 @ 105，均价仍为 105
+	fill(t, engine, models.SideBuy, "1", "105")
+
+	// 卖出 2.5 @ 100：平掉 1.5（亏损 7.5），反手开空 1 @ 100
+	fill(t, engine, models.SideSell, "2.5", "100")
+	pos, err = engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Equal(t, models.PositionSideShort, pos.Side)
+	assertDecimal(t, "1", pos.Size)
+	assertDecimal(t, "100", pos.EntryPrice)
+
+	// 买入 1 @ 90 全部平仓，实现盈亏 10
+	fill(t, engine, models.SideBuy, "1", "90")
+	pos, err = engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Nil(t, pos)
+
+	// 手续费合计 (100 + 110 + 60 + 105 + 105 + 250 + 90) × 0.0002 = 0.164
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "9.836", balance.TotalPNL)
+	// 全部平仓后保证金全部释放
+	assertDecimal(t, "10009.836", balance.Available)
+	assertDecimal(t, "0", balance.Frozen)
+}
+
+func TestEngine_HedgeModeLegs(t *testing.T) {
+	engine, _ := newTestEngine(t)
+
+	// 同时持有多头和空头两条腿
+	fillLeg(t, engine, models.PositionSideLong, models.SideBuy, "1", "100")
+	fillLeg(t, engine, models.PositionSideShort, models.SideSell, "2", "100")
+
+	long, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideLong)
+	require.NoError(t, err)
+	require.NotNil(t, long)
+	assertDecimal(t, "1", long.Size)
+
+	// 平多腿不影响空腿，也不会反手
+	fillLeg(t, engine, models.PositionSideLong, models.SideSell, "1", "110")
+	long, err = engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideLong)
+	require.NoError(t, err)
+	assert.Nil(t, long)
+
+	short, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideShort)
+	require.NoError(t, err)
+	require.NotNil(t, short)
+	assert.Equal(t, models.PositionSideShort, short.Side)
+	assertDecimal(t, "2", short.Size)
+
+	positions, err := engine.positionStore.GetByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	assert.Len(t, positions, 1)
+
+	// 多腿平仓实现盈亏 10
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	// 手续费 (100 + 200 + 110) × 0.0002 = 0.082
+	assertDecimal(t, "9.918", balance.TotalPNL)
+}
+
+func TestEngine_HedgeCloseClamped(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	fillLeg(t, engine, models.PositionSideLong, models.SideBuy, "1", "100")
+
+	// 平多单数量超过多腿持仓，成交前缩小到持仓大小，超出部分冻结的保证金退回
+	engine.fillModels["BTCUSDT"] = touchModel{}
+	order := &models.Order{APIKey: testAPIKey, Symbol: "BTCUSDT", Side: models.SideSell, PositionSide: models.PositionSideLong,
+		Type: "LIMIT", Price: dec("110"), Quantity: dec("3"), Leverage: 10, Status: models.OrderStatusNew}
+	require.NoError(t, engine.PlaceOrder(order))
+	engine.OnTrade(tick("BTCUSDT", "110", "1000"))
+	require.Equal(t, models.OrderStatusFilled, order.Status)
+	assertDecimal(t, "1", order.Quantity)
+	assertDecimal(t, "1", order.ExecutedQty)
+
+	trades, err := engine.orderStore.GetTradesByAPIKey(testAPIKey)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	for _, trade := range trades {
+		assertDecimal(t, "1", trade.Quantity)
+	}
+
+	long, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideLong)
+	require.NoError(t, err)
+	assert.Nil(t, long)
+
+	// 实现盈亏 10，手续费 (100 + 110) × 0.0002 = 0.042
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "9.958", balance.TotalPNL)
+	assertDecimal(t, "10009.958", balance.Available)
+	assertDecimal(t, "0", balance.Frozen)
+}
+
+func TestEngine_IsolatedMargin(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	require.NoError(t, engine.accountStore.SetMarginType(testAPIKey, "BTCUSDT", models.MarginTypeIsolated))
+
+	// 10 倍逐仓开多 1 @ 100，锁定保证金 10
+	fill(t, engine, models.SideBuy, "1", "100")
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assert.Equal(t, models.MarginTypeIsolated, pos.MarginType)
+	assertDecimal(t, "10", pos.Margin)
+
+	// 追加 5，从可用余额划入冻结
+	pos, err = engine.AdjustIsolatedMargin(testAPIKey, "BTCUSDT", models.PositionSideBoth, dec("5"))
+	require.NoError(t, err)
+	assertDecimal(t, "15", pos.Margin)
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "15", balance.Frozen)
+
+	// 减少后不能低于初始保证金
+	_, err = engine.AdjustIsolatedMargin(testAPIKey, "BTCUSDT", models.PositionSideBoth, dec("-6"))
+	assert.ErrorIs(t, err, ErrIsolatedBalanceInsufficient)
+	_, err = engine.AdjustIsolatedMargin(testAPIKey, "BTCUSDT", models.PositionSideBoth, dec("1000000"))
+	assert.ErrorIs(t, err, ErrMarginInsufficient)
+
+	// 平仓后追加的保证金一并释放
+	fill(t, engine, models.SideSell, "1", "100")
+	balance, err = engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "0", balance.Frozen)
+
+	_, err = engine.AdjustIsolatedMargin(testAPIKey, "BTCUSDT", models.PositionSideBoth, dec("1"))
+	assert.ErrorIs(t, err, ErrPositionNotFound)
+}
+
+func TestEngine_IsolatedMarginRollback(t *testing.T) {
+	engine, database := newTestEngine(t)
+	require.NoError(t, engine.accountStore.SetMarginType(testAPIKey, "BTCUSDT", models.MarginTypeIsolated))
+	fill(t, engine, models.SideBuy, "1", "100")
+
+	// 流水写入失败时持仓保证金也不改变
+	_, err := database.Exec(`CREATE TRIGGER fail_ledger BEFORE INSERT ON ledger BEGIN SELECT RAISE(ABORT, 'boom'); END`)
+	require.NoError(t, err)
+	_, err = engine.AdjustIsolatedMargin(testAPIKey, "BTCUSDT", models.PositionSideBoth, dec("5"))
+	require.Error(t, err)
+
+	pos, err := engine.positionStore.Get(testAPIKey, "BTCUSDT", models.PositionSideBoth)
+	require.NoError(t, err)
+	assertDecimal(t, "10", pos.Margin)
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "10", balance.Frozen)
+}
+
+func TestEngine_Ledger(t *testing.T) {
+	engine, _ := newTestEngine(t)
+
+	// 开多 1 @ 100 后以 110 平仓，盈利 10，手续费 (100 + 110) × 0.0002 = 0.042
+	fill(t, engine, models.SideBuy, "1", "100")
+	fill(t, engine, models.SideSell, "1", "110")
+
+	balance, err := engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "9.958", balance.TotalPNL)
+	assertDecimal(t, "10009.958", balance.Available)
+
+	entries, err := engine.ledgerStore.List(testAPIKey, "", "", time.Time{}, time.Time{}, 100)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	pnl := decimal.Zero
+	for _, e := range entries {
+		if e.Type.IsPNL() {
+			pnl = pnl.Add(e.Income())
+			assert.NotZero(t, e.TradeID)
+		}
+	}
+	assertDecimal(t, "9.958", pnl)
+	last := entries[len(entries)-1]
+	assertDecimal(t, balance.Available.String(), last.AvailableAfter)
+	assertDecimal(t, "0", last.FrozenAfter)
+
+	realized, err := engine.ledgerStore.List(testAPIKey, "BTCUSDT", models.LedgerTypeRealizedPNL, time.Time{}, time.Time{}, 100)
+	require.NoError(t, err)
+	require.Len(t, realized, 1)
+	assertDecimal(t, "10", realized[0].Amount)
+
+	// 余额被改坏后可由流水重新计算
+	_, err = engine.db.Exec("UPDATE balances SET available = '0', total_pnl = '0' WHERE api_key = ?", testAPIKey)
+	require.NoError(t, err)
+	rebuilt, err := engine.ledgerStore.Rebuild(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "10009.958", rebuilt.Available)
+	assertDecimal(t, "9.958", rebuilt.TotalPNL)
+	balance, err = engine.balanceStore.Get(testAPIKey)
+	require.NoError(t, err)
+	assertDecimal(t, "10009.958", balance.Available)
+}
@@ -0,0 +1,71 @@
+package matching
+
+import (
+	"log"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/collector"
+	"hft-sim/internal/models"
+)
+
+// DepthSource 提供币安真实盘口深度，用于估计挂单的排队位置
+type DepthSource interface {
+	Depth(symbol string) (*collector.Depth, error)
+}
+
+// levelQuantity 返回盘口中与订单同方向、同价格档位的挂单量
+func levelQuantity(depth *collector.Depth, side models.Side, price decimal.Decimal) decimal.Decimal {
+	levels := depth.Bids
+	if side == models.SideSell {
+		levels = depth.Asks
+	}
+	for _, l := range levels {
+		if l.Price.Equal(price) {
+			return l.Quantity
+		}
+	}
+	return decimal.Zero
+}
+
+// estimateQueue 订单挂入时估计排在前面的真实挂单量
+// 取不到深度时视为排在队首
+func (e *Engine) estimateQueue(order *models.Order) decimal.Decimal {
+	depth := e.fetchDepth(order.Symbol)
+	if depth == nil {
+		return decimal.Zero
+	}
+	return levelQuantity(depth, order.Side, order.Price)
+}
+
+// fetchDepth 获取 symbol 的真实盘口深度，没有深度来源或请求失败时返回 nil
+func (e *Engine) fetchDepth(symbol string) *collector.Depth {
+	if e.depth == nil {
+		return nil
+	}
+	depth, err := e.depth.Depth(symbol)
+	if err != nil {
+		log.Printf("Error getting depth for %s: %v", symbol, err)
+		return nil
+	}
+	return depth
+}
+
+// queueFill 用一笔成交的剩余量先消耗订单前方的排队量，再成交订单
+// volume 为本笔成交尚未被消耗的量，返回订单可成交的数量
+// 成交价击穿限价说明该档位已被吃穿，前方排队量清零
+func queueFill(book *OrderBook, order *models.Order, tradePrice decimal.Decimal, volume *decimal.Decimal) decimal.Decimal {
+	ahead := book.QueueAhead(order.ID)
+	if !tradePrice.Equal(order.Price) {
+		ahead = decimal.Zero
+	}
+
+	consumed := decimal.Min(ahead, *volume)
+	ahead = ahead.Sub(consumed)
+	*volume = volume.Sub(consumed)
+	book.SetQueueAhead(order.ID, ahead)
+
+	qty := decimal.Min(order.Quantity.Sub(order.ExecutedQty), *volume)
+	*volume = volume.Sub(qty)
+	return qty
+}
@@ -0,0 +1,126 @@
+package matching
+
+import (
+	"errors"
+	"log"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+)
+
+// ErrReduceOnlyRejected 只减仓订单没有可以减少的持仓
+var ErrReduceOnlyRejected = errors.New("reduce only order is rejected")
+
+// isReduceOnly 只减仓订单，closePosition 订单也只减仓
+func isReduceOnly(order *models.Order) bool {
+	return order.ReduceOnly || order.ClosePosition
+}
+
+// closesHedgeLeg 双向持仓模式下与腿反向的订单为平仓单，成交不能超过该腿持仓
+func closesHedgeLeg(order *models.Order) bool {
+	return (order.PositionSide == models.PositionSideLong && order.Side == models.SideSell) ||
+		(order.PositionSide == models.PositionSideShort && order.Side == models.SideBuy)
+}
+
+// closableQty 订单可以减少的持仓数量，调用方需持有 e.mu
+// 单向持仓模式下为与订单反向的净持仓，双向持仓模式下为订单所平的那条腿
+func (e *Engine) closableQty(order *models.Order) (decimal.Decimal, error) {
+	position, err := e.positionStore.Get(order.APIKey, order.Symbol, order.PositionSide)
+	if err != nil || position == nil {
+		return decimal.Zero, err
+	}
+	if position.Side == positionSideOf(order.Side) {
+		return decimal.Zero, nil
+	}
+	return position.Size, nil
+}
+
+// sizeReduceOnly 执行前按当前持仓确定只减仓订单的数量，调用方需持有 e.mu
+// closePosition 订单取全部持仓，reduceOnly 订单超出持仓的部分被缩小；没有可减少的持仓时返回 ErrReduceOnlyRejected
+func (e *Engine) sizeReduceOnly(order *models.Order) error {
+	closable, err := e.closableQty(order)
+	if err != nil {
+		return err
+	}
+	if !closable.IsPositive() {
+		return ErrReduceOnlyRejected
+	}
+	if !order.ClosePosition && order.Quantity.LessThanOrEqual(closable) {
+		return nil
+	}
+	order.Quantity = closable
+	if order.ID == 0 {
+		return nil
+	}
+	return e.orderStore.Resize(order.ID, closable)
+}
+
+// clampReduceOnly 成交时把只减仓订单和双向持仓平仓单的成交量限制在当前持仓以内，
+// 超出部分从订单数量中去掉并释放其冻结的保证金；返回可以成交的数量，为 0 时订单已被撤销，调用方需持有 e.mu
+func (e *Engine) clampReduceOnly(book *OrderBook, order *models.Order, qty decimal.Decimal) decimal.Decimal {
+	closable, err := e.closableQty(order)
+	if err != nil {
+		log.Printf("Error getting position for order %d: %v", order.ID, err)
+		return decimal.Zero
+	}
+	if !closable.IsPositive() {
+		log.Printf("Reduce only order %d has no position to reduce, cancelled", order.ID)
+		if err := e.cancel(book, order); err != nil {
+			log.Printf("Error cancelling order %d: %v", order.ID, err)
+		}
+		return decimal.Zero
+	}
+	if qty.LessThanOrEqual(closable) {
+		return qty
+	}
+
+	quantity := order.ExecutedQty.Add(closable)
+	if err := e.orderStore.Resize(order.ID, quantity); err != nil {
+		log.Printf("Error resizing order %d: %v", order.ID, err)
+		return decimal.Zero
+	}
+	if err := e.releaseOrderMargin(order, order.Quantity.Sub(quantity)); err != nil {
+		log.Printf("Error releasing margin of order %d: %v", order.ID, err)
+	}
+	order.Quantity = quantity
+	return closable
+}
+
+// cancelStaleReduceOnly 持仓归零或反向后，撤销账户在该 symbol 上已经没有持仓可减的只减仓挂单和条件单
+// except 为正在成交的订单，由成交流程自己处理，调用方需持有 e.mu
+func (e *Engine) cancelStaleReduceOnly(apiKey, symbol string, except int64) {
+	stale := func(order *models.Order) bool {
+		if order.ID == except || !isReduceOnly(order) {
+			return false
+		}
+		closable, err := e.closableQty(order)
+		return err == nil && !closable.IsPositive()
+	}
+
+	if book, ok := e.books[symbol]; ok {
+		for _, order := range book.ByAPIKey(apiKey) {
+			if !stale(order) {
+				continue
+			}
+			if err := e.cancel(book, order); err != nil {
+				log.Printf("Error cancelling reduce only order %d: %v", order.ID, err)
+				continue
+			}
+			log.Printf("Reduce only order %d cancelled: no position to reduce", order.ID)
+		}
+	}
+
+	if triggers, ok := e.triggers[symbol]; ok {
+		for _, order := range triggers.ByAPIKey(apiKey) {
+			if !stale(order) {
+				continue
+			}
+			if err := e.cancelConditional(triggers, order); err != nil {
+				log.Printf("Error cancelling reduce only order %d: %v", order.ID, err)
+				continue
+			}
+			log.Printf("Reduce only order %d cancelled: no position to reduce", order.ID)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package matching
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+// fillTx 一笔成交的结算事务，成交记录、订单状态、持仓和资金流水通过它在同一个事务内写入
+// 结算需要的账户设置也通过它在同一个事务内读取
+type fillTx struct {
+	tx        *sql.Tx
+	orders    *store.OrderStore
+	positions *store.PositionStore
+	ledger    *store.LedgerStore
+	accounts  *store.AccountStore
+}
+
+// beginFill 开始结算事务，调用方需 Commit，或在出错时 Rollback
+func (e *Engine) beginFill() (*fillTx, error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &fillTx{
+		tx:        tx,
+		orders:    e.orderStore.WithTx(tx),
+		positions: e.positionStore.WithTx(tx),
+		ledger:    e.ledgerStore.WithTx(tx),
+		accounts:  e.accountStore.WithTx(tx),
+	}, nil
+}
+
+// fillKey 行情撮合成交的幂等键，由币安成交 ID 和订单 ID 组成；没有币安成交 ID 时为空，不做去重
+func fillKey(tradeID, orderID int64) string {
+	if tradeID <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", tradeID, orderID)
+}
+
+// settleFill 在一个事务内写入成交记录、订单状态、持仓和余额，任一步失败时全部回滚
+// 成交的幂等键已存在时返回 store.ErrDuplicateFill，数据库不发生变化
+func (e *Engine) settleFill(order *models.Order, trade *models.Trade, status models.OrderStatus, executedQty, avgPrice decimal.Decimal) (positionChange, error) {
+	var change positionChange
+
+	ftx, err := e.beginFill()
+	if err != nil {
+		return change, err
+	}
+	defer ftx.tx.Rollback()
+
+	if err := ftx.orders.CreateTrade(trade); err != nil {
+		return change, err
+	}
+	if err := ftx.orders.UpdateStatus(order.ID, status, executedQty, avgPrice); err != nil {
+		return change, err
+	}
+
+	change, err = e.updatePosition(ftx, order, trade.Quantity, trade.Price)
+	if err != nil {
+		return change, err
+	}
+
+	// 成交部分的订单冻结保证金转为持仓保证金
+	change.marginDelta = change.marginDelta.Sub(orderMargin(order, trade.Quantity))
+
+	if err := e.updateBalance(ftx, order, change, trade); err != nil {
+		return change, err
+	}
+	return change, ftx.tx.Commit()
+}
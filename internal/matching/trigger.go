@@ -0,0 +1,181 @@
+package matching
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+)
+
+// ErrWouldTrigger 条件单按最新成交价会立即触发
+var ErrWouldTrigger = errors.New("order would immediately trigger")
+
+// TriggerBook 单个 symbol 上等待触发的条件单，按下单先后排列
+type TriggerBook struct {
+	orders  []*models.Order
+	extreme map[int64]decimal.Decimal // 追踪止损激活后的最高价（卖）或最低价（买），未激活时不存在
+}
+
+func NewTriggerBook() *TriggerBook {
+	return &TriggerBook{extreme: make(map[int64]decimal.Decimal)}
+}
+
+func (b *TriggerBook) Len() int {
+	return len(b.orders)
+}
+
+func (b *TriggerBook) Add(order *models.Order) {
+	b.orders = append(b.orders, order)
+}
+
+func (b *TriggerBook) Get(id int64) *models.Order {
+	for _, o := range b.orders {
+		if o.ID == id {
+			return o
+		}
+	}
+	return nil
+}
+
+// ByAPIKey 返回属于某个账户的条件单
+func (b *TriggerBook) ByAPIKey(apiKey string) []*models.Order {
+	var result []*models.Order
+	for _, o := range b.orders {
+		if o.APIKey == apiKey {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// Remove 移除条件单，返回被移除的订单
+func (b *TriggerBook) Remove(id int64) *models.Order {
+	for i, o := range b.orders {
+		if o.ID == id {
+			b.orders = append(b.orders[:i], b.orders[i+1:]...)
+			delete(b.extreme, id)
+			return o
+		}
+	}
+	return nil
+}
+
+// Check 用最新成交价检查所有条件单，移除并返回被触发的订单
+func (b *TriggerBook) Check(price decimal.Decimal) []*models.Order {
+	var triggered []*models.Order
+	kept := b.orders[:0]
+	for _, o := range b.orders {
+		if b.triggered(o, price) {
+			triggered = append(triggered, o)
+			delete(b.extreme, o.ID)
+			continue
+		}
+		kept = append(kept, o)
+	}
+	b.orders = kept
+	return triggered
+}
+
+// triggered 判断条件单是否被 price 触发，追踪止损会同时更新最高/最低价
+// STOP 系列：买单价格涨到触发价以上、卖单跌到触发价以下时触发
+// TAKE_PROFIT 系列：买单价格跌到触发价以下、卖单涨到触发价以上时触发
+// TRAILING_STOP_MARKET：激活后卖单从最高价回调 priceRate%、买单从最低价反弹 priceRate% 时触发
+func (b *TriggerBook) triggered(order *models.Order, price decimal.Decimal) bool {
+	buy := order.Side == models.SideBuy
+
+	switch order.Type {
+	case models.OrderTypeStop, models.OrderTypeStopMarket:
+		return buy && price.GreaterThanOrEqual(order.StopPrice) || !buy && price.LessThanOrEqual(order.StopPrice)
+	case models.OrderTypeTakeProfit, models.OrderTypeTakeProfitMarket:
+		return buy && price.LessThanOrEqual(order.StopPrice) || !buy && price.GreaterThanOrEqual(order.StopPrice)
+	case models.OrderTypeTrailingStopMarket:
+		extreme, active := b.extreme[order.ID]
+		if !active {
+			if order.ActivatePrice.IsPositive() && (buy && price.GreaterThan(order.ActivatePrice) || !buy && price.LessThan(order.ActivatePrice)) {
+				return false
+			}
+			b.extreme[order.ID] = price
+			return false
+		}
+		rate := order.PriceRate.Div(decimal.NewFromInt(100))
+		if buy {
+			if price.LessThan(extreme) {
+				b.extreme[order.ID] = price
+				return false
+			}
+			return price.GreaterThanOrEqual(extreme.Mul(decimal.NewFromInt(1).Add(rate)))
+		}
+		if price.GreaterThan(extreme) {
+			b.extreme[order.ID] = price
+			return false
+		}
+		return price.LessThanOrEqual(extreme.Mul(decimal.NewFromInt(1).Sub(rate)))
+	}
+	return false
+}
+
+// placeConditional 条件单持久化后放入触发簿，不冻结保证金，触发时再按普通订单检查
+func (e *Engine) placeConditional(order *models.Order) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	triggers := e.triggerBook(order.Symbol)
+	if last, ok := e.lastPrices[order.Symbol]; ok && order.Type != models.OrderTypeTrailingStopMarket &&
+		triggers.triggered(order, last) {
+		return ErrWouldTrigger
+	}
+
+	if err := e.orderStore.Create(order); err != nil {
+		return err
+	}
+	triggers.Add(order)
+	return nil
+}
+
+// cancelConditional 撤销尚未触发的条件单，条件单没有冻结保证金，调用方需持有 e.mu
+func (e *Engine) cancelConditional(triggers *TriggerBook, order *models.Order) error {
+	if err := e.orderStore.Cancel(order.ID); err != nil {
+		return err
+	}
+	triggers.Remove(order.ID)
+	order.Status = models.OrderStatusCancelled
+	return nil
+}
+
+// markTriggered 记录触发时间 at，调用方需持有 e.mu
+func (e *Engine) markTriggered(order *models.Order, price decimal.Decimal, at time.Time) {
+	if err := e.orderStore.MarkTriggered(order.ID, at); err != nil {
+		log.Printf("Error marking order %d triggered: %v", order.ID, err)
+	}
+	order.TriggerTime = &at
+	log.Printf("Order %d %s %s triggered @ %s", order.ID, order.Type, order.Symbol, price)
+}
+
+// activate 触发后的条件单按限价单或市价单执行，无法执行时过期
+func (e *Engine) activate(order *models.Order) {
+	err := e.execute(order)
+	if err == nil {
+		return
+	}
+
+	log.Printf("Triggered order %d expired: %v", order.ID, err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.orderStore.Expire(order.ID); err != nil {
+		log.Printf("Error expiring order %d: %v", order.ID, err)
+	}
+	order.Status = models.OrderStatusExpired
+}
+
+// triggerBook 返回 symbol 对应的触发簿，不存在时创建，调用方需持有 e.mu
+func (e *Engine) triggerBook(symbol string) *TriggerBook {
+	b, ok := e.triggers[symbol]
+	if !ok {
+		b = NewTriggerBook()
+		e.triggers[symbol] = b
+	}
+	return b
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// FundingRate 一次资金费结算使用的费率
+type FundingRate struct {
+	Symbol      string          `json:"symbol"`
+	FundingRate decimal.Decimal `json:"fundingRate"`
+	MarkPrice   decimal.Decimal `json:"markPrice"`
+	FundingTime time.Time       `json:"fundingTime"`
+}
+
+// FundingPayment 一个持仓在一次结算中收付的资金费，Amount 为正表示收入、负表示支出
+type FundingPayment struct {
+	ID           int64           `json:"id"`
+	APIKey       string          `json:"-"`
+	Symbol       string          `json:"symbol"`
+	PositionSide PositionSide    `json:"positionSide"`
+	PositionSize decimal.Decimal `json:"positionSize"`
+	MarkPrice    decimal.Decimal `json:"markPrice"`
+	FundingRate  decimal.Decimal `json:"fundingRate"`
+	Amount       decimal.Decimal `json:"amount"`
+	FundingTime  time.Time       `json:"fundingTime"`
+}
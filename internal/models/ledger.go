@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// LedgerType 资金流水类型，取值与币安 /fapi/v1/income 的 incomeType 对应
+type LedgerType string
+
+const (
+	LedgerTypeCommission    LedgerType = "COMMISSION"     // 手续费，maker 返佣时为收入
+	LedgerTypeRealizedPNL   LedgerType = "REALIZED_PNL"   // 平仓实现盈亏
+	LedgerTypeFundingFee    LedgerType = "FUNDING_FEE"    // 资金费
+	LedgerTypeMarginFreeze  LedgerType = "MARGIN_FREEZE"  // 可用余额转为挂单或持仓保证金
+	LedgerTypeMarginRelease LedgerType = "MARGIN_RELEASE" // 保证金退回可用余额
+	LedgerTypeDeposit       LedgerType = "DEPOSIT"        // 入金（创建账户时的初始资金）
+	LedgerTypeAdjustment    LedgerType = "ADJUSTMENT"     // 管理员调账
+)
+
+// IsPNL 是否计入累计盈亏 TotalPNL
+func (t LedgerType) IsPNL() bool {
+	return t == LedgerTypeCommission || t == LedgerTypeRealizedPNL || t == LedgerTypeFundingFee
+}
+
+// LedgerAccount 记账科目，AVAILABLE 和 FROZEN 属于账户钱包，EXTERNAL 为钱包以外的对手方（交易所、其他交易者、入金来源）
+type LedgerAccount string
+
+const (
+	LedgerAccountAvailable LedgerAccount = "AVAILABLE"
+	LedgerAccountFrozen    LedgerAccount = "FROZEN"
+	LedgerAccountExternal  LedgerAccount = "EXTERNAL"
+)
+
+// LedgerEntry 一笔复式记账分录：Amount 从 Debit 科目转入 Credit 科目，写入后不再修改
+// AvailableAfter 等为记账后的账户余额
+type LedgerEntry struct {
+	ID             int64           `json:"id"`
+	APIKey         string          `json:"-"`
+	Type           LedgerType      `json:"type"`
+	Symbol         string          `json:"symbol"`
+	Debit          LedgerAccount   `json:"debit"`
+	Credit         LedgerAccount   `json:"credit"`
+	Amount         decimal.Decimal `json:"amount"`
+	AvailableAfter decimal.Decimal `json:"availableAfter"`
+	FrozenAfter    decimal.Decimal `json:"frozenAfter"`
+	TotalPNLAfter  decimal.Decimal `json:"totalPnlAfter"`
+	TradeID        int64           `json:"tradeId"` // 关联的成交，没有时为 0
+	Info           string          `json:"info"`
+	Time           time.Time       `json:"time"`
+}
+
+// Income 分录对钱包余额（Available + Frozen）的影响，钱包内部的保证金划转为 0
+func (e *LedgerEntry) Income() decimal.Decimal {
+	switch {
+	case e.Debit == LedgerAccountExternal && e.Credit != LedgerAccountExternal:
+		return e.Amount
+	case e.Credit == LedgerAccountExternal && e.Debit != LedgerAccountExternal:
+		return e.Amount.Neg()
+	default:
+		return decimal.Zero
+	}
+}
+
+// Apply 把分录计入余额
+func (e *LedgerEntry) Apply(b *Balance) {
+	b.add(e.Credit, e.Amount)
+	b.add(e.Debit, e.Amount.Neg())
+	if e.Type.IsPNL() {
+		b.TotalPNL = b.TotalPNL.Add(e.Income())
+	}
+}
+
+func (b *Balance) add(account LedgerAccount, amount decimal.Decimal) {
+	switch account {
+	case LedgerAccountAvailable:
+		b.Available = b.Available.Add(amount)
+	case LedgerAccountFrozen:
+		b.Frozen = b.Frozen.Add(amount)
+	}
+}
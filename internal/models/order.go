@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 type OrderStatus string
 
@@ -9,8 +13,50 @@ const (
 	OrderStatusPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
 	OrderStatusFilled          OrderStatus = "FILLED"
 	OrderStatusCancelled       OrderStatus = "CANCELLED"
+	OrderStatusExpired         OrderStatus = "EXPIRED" // 按 timeInForce 规则被系统撤销
+)
+
+// TimeInForce 订单有效方式
+type TimeInForce string
+
+const (
+	TimeInForceGTC TimeInForce = "GTC" // 成交为止
+	TimeInForceIOC TimeInForce = "IOC" // 立即成交，未成交部分撤销
+	TimeInForceFOK TimeInForce = "FOK" // 全部立即成交，否则撤销
+	TimeInForceGTX TimeInForce = "GTX" // 只做 maker，会立即成交时撤销
+)
+
+// 订单类型
+const (
+	OrderTypeLimit  = "LIMIT"
+	OrderTypeMarket = "MARKET" // 按币安真实盘口立即成交
+
+	// 条件单：在触发簿中等待，触发后按限价单或市价单执行
+	OrderTypeStop               = "STOP"
+	OrderTypeStopMarket         = "STOP_MARKET"
+	OrderTypeTakeProfit         = "TAKE_PROFIT"
+	OrderTypeTakeProfitMarket   = "TAKE_PROFIT_MARKET"
+	OrderTypeTrailingStopMarket = "TRAILING_STOP_MARKET"
 )
 
+// IsConditional 是否为需要触发的条件单
+func IsConditional(orderType string) bool {
+	switch orderType {
+	case OrderTypeStop, OrderTypeStopMarket, OrderTypeTakeProfit, OrderTypeTakeProfitMarket, OrderTypeTrailingStopMarket:
+		return true
+	}
+	return false
+}
+
+// IsMarketType 是否按市价执行（市价单或触发后按市价执行的条件单）
+func IsMarketType(orderType string) bool {
+	switch orderType {
+	case OrderTypeMarket, OrderTypeStopMarket, OrderTypeTakeProfitMarket, OrderTypeTrailingStopMarket:
+		return true
+	}
+	return false
+}
+
 type Side string
 
 const (
@@ -19,30 +65,45 @@ const (
 )
 
 type Order struct {
-	ID            int64       `json:"orderId"`
-	APIKey        string      `json:"-"`
-	Symbol        string      `json:"symbol"`
-	Side          Side        `json:"side"`
-	Type          string      `json:"type"` // LIMIT only
-	Price         float64     `json:"price,string"`
-	Quantity      float64     `json:"origQty,string"`
-	ExecutedQty   float64     `json:"executedQty,string"`
-	Leverage      int         `json:"leverage"`
-	Status        OrderStatus `json:"status"`
-	ClientOrderID string      `json:"clientOrderId"`
-	CreatedAt     time.Time   `json:"time"`
-	UpdatedAt     time.Time   `json:"updateTime"`
+	ID            int64           `json:"orderId"`
+	APIKey        string          `json:"-"`
+	Symbol        string          `json:"symbol"`
+	Side          Side            `json:"side"`
+	Type          string          `json:"type"`
+	Price         decimal.Decimal `json:"price"` // 市价单为 0
+	AvgPrice      decimal.Decimal `json:"avgPrice"`
+	Quantity      decimal.Decimal `json:"origQty"`
+	ExecutedQty   decimal.Decimal `json:"executedQty"`
+	StopPrice     decimal.Decimal `json:"stopPrice"`     // STOP/TAKE_PROFIT 系列的触发价
+	ActivatePrice decimal.Decimal `json:"activatePrice"` // 追踪止损的激活价，0 表示立即激活
+	PriceRate     decimal.Decimal `json:"priceRate"`     // 追踪止损的回调比例（百分比）
+	TriggerTime   *time.Time      `json:"triggerTime,omitempty"`
+	TimeInForce   TimeInForce     `json:"timeInForce"`
+	ReduceOnly    bool            `json:"reduceOnly"`    // 只减仓，不会增加或反向持仓，不冻结保证金
+	ClosePosition bool            `json:"closePosition"` // 触发后平掉当时的全部持仓，Quantity 在执行时确定
+	Leverage      int             `json:"leverage"`
+	PositionSide  PositionSide    `json:"positionSide"`
+	Status        OrderStatus     `json:"status"`
+	ClientOrderID string          `json:"clientOrderId"`
+	CreatedAt     time.Time       `json:"time"`
+	UpdatedAt     time.Time       `json:"updateTime"`
 }
 
 type Trade struct {
-	ID        int64     `json:"id"`
-	OrderID   int64     `json:"orderId"`
-	APIKey    string    `json:"-"`
-	Symbol    string    `json:"symbol"`
-	Side      Side      `json:"side"`
-	Price     float64   `json:"price,string"`
-	Quantity  float64   `json:"qty,string"`
-	QuoteQty  float64   `json:"quoteQty,string"`
-	Fee       float64   `json:"fee,string"`
-	Timestamp time.Time `json:"time"`
+	ID              int64           `json:"id"`
+	OrderID         int64           `json:"orderId"`
+	APIKey          string          `json:"-"`
+	Symbol          string          `json:"symbol"`
+	Side            Side            `json:"side"`
+	Price           decimal.Decimal `json:"price"`
+	Quantity        decimal.Decimal `json:"qty"`
+	QuoteQty        decimal.Decimal `json:"quoteQty"`
+	Fee             decimal.Decimal `json:"fee"` // maker 返佣时为负数
+	CommissionAsset string          `json:"commissionAsset"`
+	IsMaker         bool            `json:"isMaker"`
+	FillModel       string          `json:"fillModel"` // 产生该成交的成交模型
+	IsLiquidation   bool            `json:"isLiquidation"`
+	Timestamp       time.Time       `json:"time"`
+	// FillKey 幂等键：行情撮合的成交为 "<币安成交 ID>:<订单 ID>"，同一笔行情不会重复成交同一订单；其余成交为空
+	FillKey string `json:"-"`
 }
@@ -1,30 +1,48 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 type PositionSide string
 
 const (
+	PositionSideBoth  PositionSide = "BOTH" // 单向持仓模式
 	PositionSideLong  PositionSide = "LONG"
 	PositionSideShort PositionSide = "SHORT"
 )
 
+// MarginType 保证金模式
+type MarginType string
+
+const (
+	MarginTypeCrossed  MarginType = "CROSSED"  // 全仓：所有全仓持仓共享钱包余额
+	MarginTypeIsolated MarginType = "ISOLATED" // 逐仓：保证金锁定在单个持仓上
+)
+
+// Position 持仓
+// 单向持仓模式下 PositionSide 为 BOTH，Side 为当前净持仓方向；
+// 双向持仓模式下每个 symbol 最多有 LONG、SHORT 两条腿，PositionSide 与 Side 相同
 type Position struct {
-	APIKey         string       `json:"-"`
-	Symbol         string       `json:"symbol"`
-	Side           PositionSide `json:"side"`
-	EntryPrice     float64      `json:"entryPrice,string"`
-	Size           float64      `json:"size,string"`
-	Leverage       int          `json:"leverage"`
-	Margin         float64      `json:"margin,string"`
-	UnrealizedPNL  float64      `json:"unrealizedPnl,string"`
-	UpdatedAt      time.Time    `json:"updatedAt"`
+	APIKey        string          `json:"-"`
+	Symbol        string          `json:"symbol"`
+	PositionSide  PositionSide    `json:"positionSide"`
+	Side          PositionSide    `json:"side"`
+	EntryPrice    decimal.Decimal `json:"entryPrice"`
+	Size          decimal.Decimal `json:"size"`
+	Leverage      int             `json:"leverage"`
+	MarginType    MarginType      `json:"marginType"`
+	Margin        decimal.Decimal `json:"margin"` // 锁定在该持仓上的保证金，计入 Balance.Frozen
+	UnrealizedPNL decimal.Decimal `json:"unrealizedPnl"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
 }
 
-// Balance 账户余额
+// Balance 账户余额，钱包余额 = Available + Frozen
 type Balance struct {
-	APIKey     string  `json:"-"`
-	Available  float64 `json:"available,string"`
-	Frozen     float64 `json:"frozen,string"`
-	TotalPNL   float64 `json:"totalPnl,string"`
+	APIKey    string          `json:"-"`
+	Available decimal.Decimal `json:"available"`
+	Frozen    decimal.Decimal `json:"frozen"` // 持仓和挂单占用的保证金
+	TotalPNL  decimal.Decimal `json:"totalPnl"`
 }
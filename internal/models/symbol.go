@@ -0,0 +1,27 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// SymbolStatus 交易对状态
+const (
+	SymbolStatusTrading = "TRADING"
+	SymbolStatusBreak   = "BREAK" // 暂停交易
+)
+
+// Symbol 交易对规则，对应币安 exchangeInfo 中的 PRICE_FILTER、LOT_SIZE、MIN_NOTIONAL
+type Symbol struct {
+	Symbol            string          `json:"symbol"`
+	BaseAsset         string          `json:"baseAsset"`
+	QuoteAsset        string          `json:"quoteAsset"`
+	Status            string          `json:"status"`
+	PricePrecision    int             `json:"pricePrecision"`
+	QuantityPrecision int             `json:"quantityPrecision"`
+	MinPrice          decimal.Decimal `json:"minPrice"`
+	MaxPrice          decimal.Decimal `json:"maxPrice"`
+	TickSize          decimal.Decimal `json:"tickSize"`
+	MinQty            decimal.Decimal `json:"minQty"`
+	MaxQty            decimal.Decimal `json:"maxQty"`
+	StepSize          decimal.Decimal `json:"stepSize"`
+	MinNotional       decimal.Decimal `json:"minNotional"`
+	MaxLeverage       int             `json:"maxLeverage"`
+}
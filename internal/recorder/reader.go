@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Reader 按写入顺序读取一个录制文件，可以按索引跳到某个时间附近开始读
+type Reader struct {
+	file    *os.File
+	dec     *zstd.Decoder
+	scanner *bufio.Scanner
+	index   []IndexEntry
+	from    int64 // Seek 之后跳过早于该时间的记录
+}
+
+// Open 打开某个 symbol 某一天（UTC）的录制文件
+func Open(dir, symbol string, day time.Time) (*Reader, error) {
+	return OpenFile(DataPath(dir, symbol, day))
+}
+
+// OpenFile 打开录制文件及其索引
+func OpenFile(path string) (*Reader, error) {
+	index, err := ReadIndex(indexPath(path))
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r := &Reader{file: file, dec: dec, index: index}
+	r.scanner = newScanner(dec)
+	return r, nil
+}
+
+// ReadIndex 读取索引文件，按时间升序
+func ReadIndex(path string) ([]IndexEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var index []IndexEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e IndexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		index = append(index, e)
+	}
+	return index, scanner.Err()
+}
+
+// Seek 跳到包含 t 的 frame，之后 Next 只返回时间不早于 t 的记录
+func (r *Reader) Seek(t time.Time) error {
+	ms := t.UnixMilli()
+	i := sort.Search(len(r.index), func(i int) bool { return r.index[i].Time > ms })
+	offset := int64(0)
+	if i > 0 {
+		offset = r.index[i-1].Offset
+	}
+
+	if _, err := r.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := r.dec.Reset(r.file); err != nil {
+		return err
+	}
+	r.scanner = newScanner(r.dec)
+	r.from = ms
+	return nil
+}
+
+// Next 返回下一条记录，读完时返回 io.EOF
+func (r *Reader) Next() (*Record, error) {
+	for r.scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(r.scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		if record.Time < r.from {
+			continue
+		}
+		return &record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *Reader) Close() error {
+	r.dec.Close()
+	return r.file.Close()
+}
+
+// newScanner 深度事件一行可能很长，放宽单行长度限制
+func newScanner(rd io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return scanner
+}
@@ -0,0 +1,396 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"hft-sim/internal/collector"
+)
+
+// 记录类型，与 collector 的原始消息类型相同
+const (
+	RecordTrade    = collector.RawTrade
+	RecordBackfill = collector.RawBackfill
+	RecordDepth    = collector.RawDepth
+	RecordSnapshot = collector.RawSnapshot
+)
+
+const (
+	dataSuffix  = ".ndjson.zst"
+	indexSuffix = ".idx"
+
+	frameRecords  = 1000             // 每个 zstd frame 最多的记录数
+	flushInterval = 10 * time.Second // 定时结束当前 frame，保证已落盘的数据可读
+)
+
+// Record 录制文件中的一行，Data 为币安原始消息：websocket 推送的事件，或 REST 返回的补齐成交、深度快照
+type Record struct {
+	Type string          `json:"type"`
+	Time int64           `json:"time"` // 事件时间（毫秒）
+	Data json.RawMessage `json:"data"`
+}
+
+// IndexEntry 索引文件中的一行：frame 中第一条记录的时间和 frame 在压缩文件中的偏移
+type IndexEntry struct {
+	Time   int64 `json:"time"`
+	Offset int64 `json:"offset"`
+}
+
+// Recorder 把 collector 实际使用的每笔成交、diff-depth 事件和深度快照按 symbol、按天（UTC）写入 zstd 压缩的 NDJSON 文件
+// 文件路径为 <dir>/<SYMBOL>/<YYYY-MM-DD>.ndjson.zst，同名 .idx 文件按时间索引每个 zstd frame 的偏移
+type Recorder struct {
+	dir     string
+	entries chan entry
+	files   map[string]*dayFile // symbol -> 当前写入的文件
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+type entry struct {
+	symbol string
+	record Record
+}
+
+func New(dir string) *Recorder {
+	return &Recorder{
+		dir:     dir,
+		entries: make(chan entry, 10000),
+		files:   make(map[string]*dayFile),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// OnRaw 作为 collector 的原始消息处理函数，不阻塞调用方（websocket 读取和成交分发）
+// 写入队列满时丢弃事件并返回 false，由 collector 计入统计；磁盘慢时丢一条录制，好过阻塞读取导致断线丢失实时行情
+func (r *Recorder) OnRaw(event collector.RawEvent) bool {
+	e := entry{symbol: event.Symbol, record: Record{Type: event.Kind, Time: event.Time, Data: event.Data}}
+	select {
+	case r.entries <- e:
+		return true
+	default:
+	}
+
+	if n := r.dropped.Add(1); n == 1 || n%1000 == 0 {
+		log.Printf("Recorder queue full, %d events dropped so far (latest %s %s at %d)", n, event.Symbol, event.Kind, event.Time)
+	}
+	return false
+}
+
+// Dropped 写入队列满而丢弃的事件数
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Start 启动写入任务
+func (r *Recorder) Start() {
+	log.Printf("Recording market data to %s", r.dir)
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case e := <-r.entries:
+				r.write(e)
+			case <-ticker.C:
+				r.flush()
+			case <-r.stop:
+				// 写完队列中剩余的记录再关闭文件
+				for {
+					select {
+					case e := <-r.entries:
+						r.write(e)
+					default:
+						r.closeAll()
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop 写完剩余记录并关闭所有文件
+func (r *Recorder) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Recorder) write(e entry) {
+	day := time.UnixMilli(e.record.Time).UTC().Format("2006-01-02")
+	f, ok := r.files[e.symbol]
+	if !ok || f.day != day {
+		if ok {
+			if err := f.close(); err != nil {
+				log.Printf("Error closing recording %s: %v", f.path, err)
+			}
+		}
+		var err error
+		f, err = openDayFile(r.dir, e.symbol, day)
+		if err != nil {
+			log.Printf("Error opening recording for %s: %v", e.symbol, err)
+			delete(r.files, e.symbol)
+			return
+		}
+		r.files[e.symbol] = f
+	}
+	if err := f.write(e.record); err != nil {
+		log.Printf("Error writing recording %s: %v", f.path, err)
+	}
+}
+
+func (r *Recorder) flush() {
+	for _, f := range r.files {
+		if err := f.endFrame(); err != nil {
+			log.Printf("Error flushing recording %s: %v", f.path, err)
+		}
+	}
+}
+
+func (r *Recorder) closeAll() {
+	for symbol, f := range r.files {
+		if err := f.close(); err != nil {
+			log.Printf("Error closing recording %s: %v", f.path, err)
+		}
+		delete(r.files, symbol)
+	}
+}
+
+// DataPath 某个 symbol 某一天（UTC）的录制文件路径
+func DataPath(dir, symbol string, day time.Time) string {
+	return filepath.Join(dir, strings.ToUpper(symbol), day.UTC().Format("2006-01-02")+dataSuffix)
+}
+
+// Days 返回某个 symbol 已录制的日期（UTC），按时间升序，没有录制目录时返回空
+func Days(dir, symbol string) ([]time.Time, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, strings.ToUpper(symbol)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var days []time.Time
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, dataSuffix) {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", strings.TrimSuffix(name, dataSuffix))
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// indexPath 录制文件对应的索引文件路径
+func indexPath(dataPath string) string {
+	return strings.TrimSuffix(dataPath, dataSuffix) + indexSuffix
+}
+
+// dayFile 一个 symbol 一天的录制文件，以追加方式写入，每个 frame 独立压缩，重启后先 repair 再继续追加
+type dayFile struct {
+	day     string
+	path    string
+	data    *os.File
+	index   *os.File
+	counter *countingWriter
+	enc     *zstd.Encoder
+	records int // 当前 frame 中的记录数
+}
+
+func openDayFile(dir, symbol, day string) (*dayFile, error) {
+	path := filepath.Join(dir, strings.ToUpper(symbol), day+dataSuffix)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := repair(path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := data.Stat()
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	index, err := os.OpenFile(indexPath(path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	counter := &countingWriter{w: data, n: info.Size()}
+	enc, err := zstd.NewWriter(counter, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		data.Close()
+		index.Close()
+		return nil, err
+	}
+	return &dayFile{day: day, path: path, data: data, index: index, counter: counter, enc: enc}, nil
+}
+
+// repair 去掉上次异常退出时没有写完的部分，之后追加的 frame 才能被正常读取
+// 索引只保留完整且指向已写入数据的条目；最后一个 frame 不能完整解压时，数据文件截断到该 frame 的起始偏移并删除其索引
+func repair(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return os.RemoveAll(indexPath(path))
+	}
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	index, err := os.ReadFile(indexPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var (
+		offsets []int64 // 完整索引条目指向的 frame 偏移
+		ends    []int64 // 每个条目在索引文件中的结束位置
+		pos     int64
+	)
+	for {
+		n := bytes.IndexByte(index[pos:], '\n')
+		if n < 0 {
+			break
+		}
+		var e IndexEntry
+		if err := json.Unmarshal(index[pos:pos+int64(n)], &e); err != nil || e.Offset >= size {
+			break
+		}
+		pos += int64(n) + 1
+		offsets = append(offsets, e.Offset)
+		ends = append(ends, pos)
+	}
+
+	dataEnd, indexEnd := size, pos
+	if size > 0 {
+		// 最后一个 frame 从最后一个索引条目开始，没有索引时从文件开头开始
+		last, lastEnd := int64(0), int64(0)
+		if n := len(offsets); n > 0 {
+			last = offsets[n-1]
+			if n > 1 {
+				lastEnd = ends[n-2]
+			}
+		}
+		complete, err := frameComplete(path, last)
+		if err != nil {
+			return err
+		}
+		if !complete {
+			dataEnd, indexEnd = last, lastEnd
+		}
+	}
+
+	if dataEnd < size {
+		log.Printf("Recording %s: dropping incomplete frame at offset %d (%d bytes)", path, dataEnd, size-dataEnd)
+		if err := os.Truncate(path, dataEnd); err != nil {
+			return err
+		}
+	}
+	if indexEnd < int64(len(index)) {
+		return os.Truncate(indexPath(path), indexEnd)
+	}
+	return nil
+}
+
+// frameComplete 从 offset 开始到文件末尾的数据能否完整解压
+func frameComplete(path string, offset int64) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	dec, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return false, err
+	}
+	defer dec.Close()
+	_, err = io.Copy(io.Discard, dec)
+	return err == nil, nil
+}
+
+// write 写入一条记录，新 frame 的第一条记录同时写入索引
+func (f *dayFile) write(record Record) error {
+	if f.records == 0 {
+		line, err := json.Marshal(IndexEntry{Time: record.Time, Offset: f.counter.n})
+		if err != nil {
+			return err
+		}
+		if _, err := f.index.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := f.enc.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	f.records++
+	if f.records >= frameRecords {
+		return f.endFrame()
+	}
+	return nil
+}
+
+// endFrame 结束当前 frame 并写入磁盘，下一条记录开始新的 frame
+func (f *dayFile) endFrame() error {
+	if f.records == 0 {
+		return nil
+	}
+	if err := f.enc.Close(); err != nil {
+		return err
+	}
+	f.enc.Reset(f.counter)
+	f.records = 0
+	return nil
+}
+
+func (f *dayFile) close() error {
+	err := f.endFrame()
+	if cerr := f.data.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.index.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// countingWriter 记录已写入压缩文件的字节数，用作 frame 偏移
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,166 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/collector"
+	"hft-sim/internal/collector/collectortest"
+)
+
+func readAll(t *testing.T, r *Reader) []Record {
+	var records []Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, *record)
+	}
+}
+
+func TestRecorder_WriteAndRead(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	base := day.UnixMilli()
+
+	// 两次启动追加到同一个文件，共 3 个 frame
+	rec := New(dir)
+	rec.Start()
+	for i := 0; i < 1500; i++ {
+		rec.OnRaw(collectortest.Raw(t, collector.RawTrade, "BTCUSDT", base+int64(i), collector.Trade{EventType: "trade", EventTime: base + int64(i), Symbol: "BTCUSDT",
+			TradeID: int64(i), Price: strconv.Itoa(100 + i), Quantity: "1"}))
+	}
+	rec.Stop()
+
+	rec = New(dir)
+	rec.Start()
+	rec.OnRaw(collectortest.Raw(t, collector.RawDepth, "BTCUSDT", base+2000, collector.DepthUpdate{EventType: "depthUpdate", EventTime: base + 2000, Symbol: "BTCUSDT",
+		FirstUpdateID: 1, FinalUpdateID: 2, Bids: [][2]string{{"99", "1"}}}))
+	// 第二天的事件写入新文件
+	rec.OnRaw(collectortest.Raw(t, collector.RawTrade, "BTCUSDT", base+24*3600*1000, collector.Trade{EventType: "trade", EventTime: base + 24*3600*1000, Symbol: "BTCUSDT", TradeID: 9999}))
+	rec.Stop()
+
+	index, err := ReadIndex(indexPath(DataPath(dir, "BTCUSDT", day)))
+	require.NoError(t, err)
+	require.Len(t, index, 3)
+	assert.Equal(t, []int64{base, base + 1000, base + 2000}, []int64{index[0].Time, index[1].Time, index[2].Time})
+	assert.Equal(t, int64(0), index[0].Offset)
+
+	r, err := Open(dir, "BTCUSDT", day)
+	require.NoError(t, err)
+	defer r.Close()
+
+	records := readAll(t, r)
+	require.Len(t, records, 1501)
+	var trade collector.Trade
+	require.NoError(t, json.Unmarshal(records[10].Data, &trade))
+	assert.Equal(t, RecordTrade, records[10].Type)
+	assert.Equal(t, int64(10), trade.TradeID)
+	assert.Equal(t, "110", trade.Price)
+
+	last := records[1500]
+	assert.Equal(t, RecordDepth, last.Type)
+	var update collector.DepthUpdate
+	require.NoError(t, json.Unmarshal(last.Data, &update))
+	assert.Equal(t, int64(2), update.FinalUpdateID)
+
+	// 按索引跳到第二个 frame 中间
+	require.NoError(t, r.Seek(time.UnixMilli(base+1200)))
+	records = readAll(t, r)
+	require.Len(t, records, 301)
+	assert.Equal(t, base+1200, records[0].Time)
+
+	next, err := Open(dir, "BTCUSDT", day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	defer next.Close()
+	assert.Len(t, readAll(t, next), 1)
+}
+
+func TestRecorder_DropsWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	base := day.UnixMilli()
+
+	// 写入任务启动前队列容量为 1，第二个事件不阻塞调用方，直接丢弃
+	rec := New(dir)
+	rec.entries = make(chan entry, 1)
+	assert.True(t, rec.OnRaw(collectortest.Raw(t, collector.RawTrade, "BTCUSDT", base, collector.Trade{EventType: "trade", EventTime: base, Symbol: "BTCUSDT", TradeID: 1})))
+	assert.False(t, rec.OnRaw(collectortest.Raw(t, collector.RawTrade, "BTCUSDT", base+1, collector.Trade{EventType: "trade", EventTime: base + 1, Symbol: "BTCUSDT", TradeID: 2})))
+	assert.Equal(t, int64(1), rec.Dropped())
+
+	rec.Start()
+	rec.Stop()
+
+	r, err := Open(dir, "BTCUSDT", day)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Len(t, readAll(t, r), 1)
+}
+
+func TestRecorder_RepairAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	base := day.UnixMilli()
+	trade := func(i int64) collector.RawEvent {
+		return collectortest.Raw(t, collector.RawTrade, "BTCUSDT", base+i, collector.Trade{EventType: "trade", EventTime: base + i, Symbol: "BTCUSDT", TradeID: i})
+	}
+
+	rec := New(dir)
+	rec.Start()
+	for i := int64(0); i < 1500; i++ {
+		rec.OnRaw(trade(i))
+	}
+	rec.Stop()
+
+	// 模拟写到一半时进程崩溃：新 frame 的索引已写入，压缩数据只写了一半，后面还有半行索引
+	path := DataPath(dir, "BTCUSDT", day)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	var frame bytes.Buffer
+	enc, err := zstd.NewWriter(&frame)
+	require.NoError(t, err)
+	_, err = enc.Write(bytes.Repeat([]byte(`{"type":"trade","time":0,"data":{}}`+"\n"), 100))
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+	appendFile(t, path, frame.Bytes()[:frame.Len()/2])
+	appendFile(t, indexPath(path), []byte(`{"time":1,"offset":`+strconv.FormatInt(info.Size(), 10)+"}\n{\"time\":"))
+
+	// 重启后截掉半个 frame 和多余的索引，新数据追加在完整的 frame 之后
+	rec = New(dir)
+	rec.Start()
+	rec.OnRaw(trade(2000))
+	rec.Stop()
+
+	index, err := ReadIndex(indexPath(path))
+	require.NoError(t, err)
+	require.Len(t, index, 3)
+	assert.Equal(t, info.Size(), index[2].Offset)
+	assert.Equal(t, base+2000, index[2].Time)
+
+	r, err := Open(dir, "BTCUSDT", day)
+	require.NoError(t, err)
+	defer r.Close()
+	records := readAll(t, r)
+	require.Len(t, records, 1501)
+	assert.Equal(t, base+2000, records[1500].Time)
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Write(data)
+	require.NoError(t, err)
+}
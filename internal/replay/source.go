@@ -0,0 +1,506 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/collector"
+	"hft-sim/internal/recorder"
+)
+
+// Speed 回放速度：大于 0 时为相对录制时间的倍速
+type Speed float64
+
+const (
+	Max  Speed = 0  // 不等待，尽快回放
+	Step Speed = -1 // 手动单步，每次 Step 放行指定条数的事件
+)
+
+// ParseSpeed 解析回放速度："1"、"10x"、"max"、"step"
+func ParseSpeed(s string) (Speed, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "max":
+		return Max, nil
+	case "step":
+		return Step, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q", s)
+	}
+	return Speed(v), nil
+}
+
+// ParseTime 解析回放起止时间，支持 "2006-01-02"（UTC）和 RFC3339，空字符串返回零值
+func ParseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (s Speed) String() string {
+	switch s {
+	case Max:
+		return "max"
+	case Step:
+		return "step"
+	}
+	return strconv.FormatFloat(float64(s), 'f', -1, 64) + "x"
+}
+
+// Status 回放进度
+type Status struct {
+	Speed   string    `json:"speed"`
+	Time    time.Time `json:"time"`
+	Records int64     `json:"records"`
+	Pending int       `json:"pending"` // 单步模式下已放行但还没回放的事件数
+	Waiting bool      `json:"waiting"` // 等待 Release 开始回放
+	Done    bool      `json:"done"`
+}
+
+// Source 回放 recorder 录制的行情文件，实现 collector.MarketSource
+// 多个 symbol 的事件按事件时间合并，在同一个 goroutine 中依次同步调用处理函数，
+// 模拟时钟在每个事件之前推进到该事件的时间，同一份录制数据每次回放的结果相同
+// 本地盘口与实时模式一样由录制的 REST 快照重置、diff-depth 事件增量更新
+type Source struct {
+	dir     string
+	symbols []string
+	from    time.Time
+	to      time.Time
+	speed   Speed
+	clock   *clock.Sim
+
+	mu           sync.RWMutex
+	handlers     []func(collector.Trade)
+	depthSubs    []func(collector.DepthUpdate)
+	latestTrades map[string]collector.Trade
+	books        map[string]*collector.LocalBook
+	records      int64
+
+	steps       int
+	stepped     chan struct{}
+	held        bool
+	release     chan struct{}
+	releaseOnce sync.Once
+	stopOnce    sync.Once
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// New 创建回放源，回放 [from, to] 内的录制数据
+// from 为零时从最早的录制日开始，to 为零时回放到最后一个录制日结束
+func New(dir string, symbols []string, from, to time.Time, speed Speed) (*Source, error) {
+	var first, last time.Time
+	for _, symbol := range symbols {
+		days, err := recorder.Days(dir, symbol)
+		if err != nil {
+			return nil, err
+		}
+		if len(days) == 0 {
+			continue
+		}
+		if first.IsZero() || days[0].Before(first) {
+			first = days[0]
+		}
+		if end := days[len(days)-1].AddDate(0, 0, 1).Add(-time.Millisecond); end.After(last) {
+			last = end
+		}
+	}
+	if first.IsZero() {
+		return nil, fmt.Errorf("no recorded market data in %s", dir)
+	}
+	if from.IsZero() {
+		from = first
+	}
+	if to.IsZero() {
+		to = last
+	}
+
+	s := &Source{
+		dir:          dir,
+		symbols:      symbols,
+		from:         from.UTC(),
+		to:           to.UTC(),
+		speed:        speed,
+		clock:        clock.NewSim(from.UTC()),
+		latestTrades: make(map[string]collector.Trade),
+		books:        make(map[string]*collector.LocalBook, len(symbols)),
+		stepped:      make(chan struct{}, 1),
+		release:      make(chan struct{}),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		s.books[symbol] = collector.NewLocalBook(symbol)
+	}
+	return s, nil
+}
+
+// Seed 由回放的 symbol 和时间范围得到的随机数种子，用于回放中的随机成交模型
+// 同一范围的回放每次得到相同的种子，不同范围的种子不同
+func (s *Source) Seed() int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d|%d", strings.ToUpper(strings.Join(s.symbols, ",")), s.from.UnixMilli(), s.to.UnixMilli())
+	return int64(h.Sum64())
+}
+
+// Clock 回放使用的模拟时钟，时间为最近一个事件的时间
+func (s *Source) Clock() *clock.Sim {
+	return s.clock
+}
+
+// Done 回放结束（录制数据读完、到达 to 或 Stop）后关闭
+func (s *Source) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Source) AddHandler(handler func(collector.Trade)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler)
+}
+
+// AddDepthHandler 订阅 diff-depth 事件，处理函数在应用到本地盘口之前调用
+func (s *Source) AddDepthHandler(handler func(collector.DepthUpdate)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.depthSubs = append(s.depthSubs, handler)
+}
+
+func (s *Source) GetLatestTrades() map[string]collector.Trade {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]collector.Trade, len(s.latestTrades))
+	for k, v := range s.latestTrades {
+		result[k] = v
+	}
+	return result
+}
+
+// Depth 返回本地盘口前 100 档，实现 matching.DepthSource
+func (s *Source) Depth(symbol string) (*collector.Depth, error) {
+	return s.Snapshot(symbol, 100)
+}
+
+// Snapshot 返回按录制的快照和 diff-depth 事件重建的盘口前 limit 档
+// 回放到该 symbol 的第一个快照之前返回 collector.ErrDepthNotSynced
+func (s *Source) Snapshot(symbol string, limit int) (*collector.Depth, error) {
+	book, ok := s.books[strings.ToUpper(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("no depth for %s", symbol)
+	}
+	return book.Snapshot(limit)
+}
+
+// Start 开始回放，需在所有处理函数和定时任务注册之后调用
+func (s *Source) Start() error {
+	log.Printf("Replaying %s from %s to %s at %s", s.dir, s.from.Format(time.RFC3339), s.to.Format(time.RFC3339), s.speed)
+	go s.run()
+	return nil
+}
+
+// Hold 让回放在 Start 之后等待 Release 才开始，用于等待策略就绪，需在 Start 之前调用
+func (s *Source) Hold() {
+	s.held = true
+}
+
+// Release 开始被 Hold 的回放，重复调用或没有 Hold 时无效果
+func (s *Source) Release() {
+	s.releaseOnce.Do(func() { close(s.release) })
+}
+
+func (s *Source) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Step 单步模式下放行 n 个事件，其他模式下无效果
+func (s *Source) Step(n int) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.steps += n
+	s.mu.Unlock()
+
+	select {
+	case s.stepped <- struct{}{}:
+	default:
+	}
+}
+
+// Status 当前回放进度
+func (s *Source) Status() Status {
+	s.mu.RLock()
+	status := Status{
+		Speed:   s.speed.String(),
+		Time:    s.clock.Now(),
+		Records: s.records,
+		Pending: s.steps,
+	}
+	s.mu.RUnlock()
+
+	if s.held {
+		select {
+		case <-s.release:
+		default:
+			status.Waiting = true
+		}
+	}
+	select {
+	case <-s.done:
+		status.Done = true
+	default:
+	}
+	return status
+}
+
+// run 按天打开各 symbol 的录制文件，按事件时间合并回放
+func (s *Source) run() {
+	defer close(s.done)
+
+	if s.held {
+		log.Printf("Replay waiting for the strategy to start")
+		select {
+		case <-s.release:
+		case <-s.stop:
+			return
+		}
+	}
+
+	var pace pacer
+	for day := s.from.Truncate(24 * time.Hour); !day.After(s.to); day = day.AddDate(0, 0, 1) {
+		readers, symbols, err := s.openDay(day)
+		if err != nil {
+			log.Printf("Replay stopped: %v", err)
+			return
+		}
+		finished := s.replayDay(readers, symbols, &pace)
+		for _, r := range readers {
+			r.Close()
+		}
+		if finished {
+			break
+		}
+	}
+
+	log.Printf("Replay finished at %s, %d events", s.clock.Now().Format(time.RFC3339Nano), s.records)
+}
+
+// openDay 打开某一天所有 symbol 的录制文件，没有录制的 symbol 跳过，返回的 symbols 与 readers 一一对应
+// 文件从当天开头读起：from 之前的快照和深度事件用于建立本地盘口
+func (s *Source) openDay(day time.Time) ([]*recorder.Reader, []string, error) {
+	var readers []*recorder.Reader
+	var symbols []string
+	for _, symbol := range s.symbols {
+		r, err := recorder.Open(s.dir, symbol, day)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, nil, err
+		}
+		readers = append(readers, r)
+		symbols = append(symbols, strings.ToUpper(symbol))
+	}
+	return readers, symbols, nil
+}
+
+// replayDay 合并回放一天的录制文件，到达 to 或被停止时返回 true
+func (s *Source) replayDay(readers []*recorder.Reader, symbols []string, pace *pacer) bool {
+	heads := make([]*recorder.Record, len(readers))
+	next := func(i int) {
+		record, err := readers[i].Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading recording: %v", err)
+			}
+			record = nil
+		}
+		heads[i] = record
+	}
+	for i := range readers {
+		next(i)
+	}
+
+	start, end := s.from.UnixMilli(), s.to.UnixMilli()
+	for {
+		// 时间相同的事件按 symbol 配置顺序回放
+		pick := -1
+		for i, head := range heads {
+			if head != nil && (pick < 0 || head.Time < heads[pick].Time) {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			return false
+		}
+		record := heads[pick]
+		if record.Time > end {
+			return true
+		}
+		switch {
+		case record.Time < start || record.Type == recorder.RecordSnapshot:
+			// from 之前的记录只用于建立盘口；快照不是行情事件，不等待、不推进时钟
+			s.applyBook(symbols[pick], record)
+		case !s.wait(record, pace):
+			return true
+		default:
+			s.dispatch(symbols[pick], record)
+		}
+		next(pick)
+	}
+}
+
+// pacer 倍速回放时按录制时间间隔换算的墙上时间等待
+type pacer struct {
+	first int64 // 第一个事件的录制时间（毫秒）
+	start time.Time
+}
+
+// wait 按回放速度等待到可以回放 record，被停止时返回 false
+func (s *Source) wait(record *recorder.Record, pace *pacer) bool {
+	switch {
+	case s.speed == Step:
+		for {
+			s.mu.Lock()
+			if s.steps > 0 {
+				s.steps--
+				s.mu.Unlock()
+				return true
+			}
+			s.mu.Unlock()
+			select {
+			case <-s.stepped:
+			case <-s.stop:
+				return false
+			}
+		}
+	case s.speed > 0:
+		if pace.start.IsZero() {
+			pace.first = record.Time
+			pace.start = time.Now()
+		}
+		elapsed := time.Duration(float64(time.Duration(record.Time-pace.first)*time.Millisecond) / float64(s.speed))
+		if d := time.Until(pace.start.Add(elapsed)); d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-s.stop:
+				return false
+			}
+		}
+	}
+
+	select {
+	case <-s.stop:
+		return false
+	default:
+		return true
+	}
+}
+
+// dispatch 推进模拟时钟并把事件交给处理函数，处理函数同步调用
+func (s *Source) dispatch(symbol string, record *recorder.Record) {
+	s.clock.Set(time.UnixMilli(record.Time).UTC())
+
+	switch record.Type {
+	case recorder.RecordTrade, recorder.RecordBackfill:
+		trade, err := parseTrade(symbol, record)
+		if err != nil {
+			log.Printf("Unmarshal error: %v", err)
+			return
+		}
+		s.mu.Lock()
+		s.latestTrades[trade.Symbol] = trade
+		s.records++
+		handlers := s.handlers
+		s.mu.Unlock()
+
+		for _, handler := range handlers {
+			handler(trade)
+		}
+	case recorder.RecordDepth:
+		var update collector.DepthUpdate
+		if err := json.Unmarshal(record.Data, &update); err != nil {
+			log.Printf("Unmarshal error: %v", err)
+			return
+		}
+		s.mu.Lock()
+		s.records++
+		subs := s.depthSubs
+		s.mu.Unlock()
+
+		for _, handler := range subs {
+			handler(update)
+		}
+		s.applyDepth(update)
+	}
+}
+
+// applyDepth 应用到本地盘口，update id 不连续（录制时断线）时等待之后录制的快照重新同步
+func (s *Source) applyDepth(update collector.DepthUpdate) {
+	book, ok := s.books[update.Symbol]
+	if !ok {
+		return
+	}
+	if err := book.Apply(update); err != nil {
+		log.Printf("Recorded depth %s has a gap at %d: %v", update.Symbol, update.FirstUpdateID, err)
+	}
+}
+
+// applyBook 只把记录应用到本地盘口，不调用处理函数：快照重置盘口，diff-depth 事件增量更新
+func (s *Source) applyBook(symbol string, record *recorder.Record) {
+	switch record.Type {
+	case recorder.RecordSnapshot:
+		book, ok := s.books[symbol]
+		if !ok {
+			return
+		}
+		snapshot, err := collector.ParseDepth(symbol, record.Data)
+		if err != nil {
+			log.Printf("Unmarshal error: %v", err)
+			return
+		}
+		// 与实时模式相同，过期的快照之后会有重新拉取的快照
+		if err := book.Reset(snapshot); err != nil {
+			log.Printf("Recorded depth snapshot for %s is stale: %v", symbol, err)
+		}
+	case recorder.RecordDepth:
+		var update collector.DepthUpdate
+		if err := json.Unmarshal(record.Data, &update); err != nil {
+			log.Printf("Unmarshal error: %v", err)
+			return
+		}
+		s.applyDepth(update)
+	}
+}
+
+// parseTrade 解析录制的成交，backfill 记录为 REST aggTrades 的返回项
+func parseTrade(symbol string, record *recorder.Record) (collector.Trade, error) {
+	if record.Type == recorder.RecordBackfill {
+		return collector.ParseAggTrade(symbol, record.Data)
+	}
+	var trade collector.Trade
+	err := json.Unmarshal(record.Data, &trade)
+	return trade, err
+}
@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/collector"
+	"hft-sim/internal/collector/collectortest"
+	"hft-sim/internal/recorder"
+)
+
+// record 录制两个 symbol 交错的成交和 ETH 的深度快照、深度事件，返回第一笔事件时间
+func record(t *testing.T, dir string) time.Time {
+	start := time.Date(2024, 3, 1, 7, 59, 59, 0, time.UTC)
+	base := start.UnixMilli()
+
+	// 深度记录按时间穿插在成交之间写入，与实时录制的顺序相同
+	books := []collector.RawEvent{
+		collectortest.Raw(t, collector.RawSnapshot, "ETHUSDT", base+50, map[string]any{"lastUpdateId": 9,
+			"bids": [][2]string{{"97", "5"}, {"99", "1"}}, "asks": [][2]string{{"102", "1"}}}),
+		collectortest.Raw(t, collector.RawDepth, "ETHUSDT", base+100, collector.DepthUpdate{EventType: "depthUpdate", EventTime: base + 100, Symbol: "ETHUSDT",
+			FirstUpdateID: 10, FinalUpdateID: 12, Bids: [][2]string{{"99", "2"}}, Asks: [][2]string{{"101", "3"}}}),
+		collectortest.Raw(t, collector.RawDepth, "ETHUSDT", base+300, collector.DepthUpdate{EventType: "depthUpdate", EventTime: base + 300, Symbol: "ETHUSDT",
+			FirstUpdateID: 13, FinalUpdateID: 13, Bids: [][2]string{{"99", "0"}, {"98", "1"}}}),
+	}
+
+	rec := recorder.New(dir)
+	rec.Start()
+	for i := 0; i < 10; i++ {
+		symbol := "BTCUSDT"
+		if i%2 == 1 {
+			symbol = "ETHUSDT"
+		}
+		at := base + int64(i)*200
+		for len(books) > 0 && books[0].Time < at {
+			rec.OnRaw(books[0])
+			books = books[1:]
+		}
+		rec.OnRaw(collectortest.Raw(t, collector.RawTrade, symbol, at, collector.Trade{EventType: "trade", EventTime: at, Symbol: symbol, TradeID: int64(i), Price: "100", Quantity: "1"}))
+	}
+	rec.Stop()
+	return start
+}
+
+func TestSource_ReplayMax(t *testing.T) {
+	dir := t.TempDir()
+	start := record(t, dir)
+
+	source, err := New(dir, []string{"BTCUSDT", "ETHUSDT"}, time.Time{}, time.Time{}, Max)
+	require.NoError(t, err)
+
+	var ids []int64
+	var times []time.Time
+	source.AddHandler(func(trade collector.Trade) {
+		ids = append(ids, trade.TradeID)
+		times = append(times, source.Clock().Now())
+	})
+	depths := 0
+	source.AddDepthHandler(func(collector.DepthUpdate) { depths++ })
+
+	// 08:00 的定时任务应在 1 秒之后的第一笔成交之前执行
+	var firedAt time.Time
+	tradesAtFire := -1
+	source.clock.Set(start)
+	source.clock.AfterFunc(time.Second, func() {
+		firedAt = source.Clock().Now()
+		tradesAtFire = len(ids)
+	})
+
+	require.NoError(t, source.Start())
+	select {
+	case <-source.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay did not finish")
+	}
+
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ids)
+	assert.Equal(t, start.Add(600*time.Millisecond), times[3])
+	assert.Equal(t, 2, depths)
+	assert.Equal(t, start.Add(time.Second), firedAt)
+	assert.Equal(t, 5, tradesAtFire)
+
+	status := source.Status()
+	assert.True(t, status.Done)
+	assert.Equal(t, int64(12), status.Records)
+	assert.Equal(t, start.Add(1800*time.Millisecond), status.Time)
+	assert.Equal(t, "100", source.GetLatestTrades()["ETHUSDT"].Price)
+
+	// 快照之外的档位来自 diff-depth 事件，快照中的档位保留
+	depth, err := source.Depth("ETHUSDT")
+	require.NoError(t, err)
+	require.Len(t, depth.Bids, 2)
+	assert.Equal(t, "98", depth.Bids[0].Price.String())
+	assert.Equal(t, "97", depth.Bids[1].Price.String())
+	require.Len(t, depth.Asks, 2)
+	assert.Equal(t, "101", depth.Asks[0].Price.String())
+	assert.Equal(t, int64(13), depth.LastUpdateID)
+
+	// BTC 没有录制快照
+	_, err = source.Depth("BTCUSDT")
+	assert.ErrorIs(t, err, collector.ErrDepthNotSynced)
+}
+
+func TestSource_ReplayStep(t *testing.T) {
+	dir := t.TempDir()
+	start := record(t, dir)
+
+	// 从第 5 笔成交开始
+	source, err := New(dir, []string{"BTCUSDT", "ETHUSDT"}, start.Add(time.Second), time.Time{}, Step)
+	require.NoError(t, err)
+
+	trades := make(chan int64, 10)
+	source.AddHandler(func(trade collector.Trade) { trades <- trade.TradeID })
+	require.NoError(t, source.Start())
+	defer source.Stop()
+
+	select {
+	case <-trades:
+		t.Fatal("step replay should wait for Step")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	source.Step(2)
+	assert.Equal(t, int64(5), <-trades)
+	assert.Equal(t, int64(6), <-trades)
+	assert.Eventually(t, func() bool { return source.Status().Records == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, start.Add(1200*time.Millisecond), source.Clock().Now())
+
+	// 开始时间之前的快照和深度事件用于建立盘口
+	depth, err := source.Depth("ETHUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), depth.LastUpdateID)
+}
+
+func TestSource_Hold(t *testing.T) {
+	dir := t.TempDir()
+	record(t, dir)
+
+	source, err := New(dir, []string{"BTCUSDT", "ETHUSDT"}, time.Time{}, time.Time{}, Max)
+	require.NoError(t, err)
+	trades := 0
+	source.AddHandler(func(collector.Trade) { trades++ })
+
+	// max 速度下也等到 Release 才开始回放
+	source.Hold()
+	require.NoError(t, source.Start())
+	select {
+	case <-source.Done():
+		t.Fatal("held replay should wait for Release")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.True(t, source.Status().Waiting)
+
+	source.Release()
+	select {
+	case <-source.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay did not finish")
+	}
+	assert.Equal(t, 10, trades)
+	assert.False(t, source.Status().Waiting)
+}
+
+func TestParseSpeed(t *testing.T) {
+	for in, want := range map[string]Speed{"1": 1, "10x": 10, "MAX": Max, "step": Step, "0.5": 0.5} {
+		speed, err := ParseSpeed(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, speed, in)
+	}
+	_, err := ParseSpeed("0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package risk
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+// Error 下单前风控拒绝的原因，Code/Msg 与币安错误码一致
+type Error struct {
+	Code int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func reject(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Checker 下单前风控：交易对规则（symbols 表）、杠杆上限和挂单数量
+// 保证金是否足够在撮合引擎挂单时与冻结一起原子地检查
+type Checker struct {
+	configStore *store.ConfigStore
+	orderStore  *store.OrderStore
+	symbolStore *store.SymbolStore
+}
+
+func New(db *sql.DB) *Checker {
+	return &Checker{
+		configStore: store.NewConfigStore(db),
+		orderStore:  store.NewOrderStore(db),
+		symbolStore: store.NewSymbolStore(db),
+	}
+}
+
+// Check 检查订单是否可以提交，拒绝时返回 *Error
+func (c *Checker) Check(order *models.Order) error {
+	sym, err := c.Symbol(order.Symbol)
+	if err != nil {
+		return err
+	}
+	if sym == nil || sym.Status != models.SymbolStatusTrading {
+		return reject(-1121, "Invalid symbol.")
+	}
+	if order.Side != models.SideBuy && order.Side != models.SideSell {
+		return reject(-1117, "Invalid side.")
+	}
+	// closePosition 订单的数量在执行时按持仓确定，不检查数量
+	if !order.ClosePosition {
+		if err := checkQuantity(order, sym); err != nil {
+			return err
+		}
+	}
+
+	// 市价单没有价格，PRICE_FILTER 和 MIN_NOTIONAL 只检查限价单
+	limit := !models.IsMarketType(order.Type)
+	if limit {
+		if err := checkPrice(order, sym); err != nil {
+			return err
+		}
+	}
+	if order.StopPrice.IsPositive() {
+		if err := checkStopPrice(order.StopPrice, sym); err != nil {
+			return err
+		}
+	}
+
+	// MIN_NOTIONAL，只减仓订单不受限制
+	if limit && !order.ReduceOnly && order.Price.Mul(order.Quantity).LessThan(sym.MinNotional) {
+		return reject(-4164, "Order's notional must be no smaller than %s (unless you choose reduce only).",
+			sym.MinNotional)
+	}
+
+	maxLeverage := c.MaxLeverage(order.APIKey)
+	if sym.MaxLeverage > 0 && sym.MaxLeverage < maxLeverage {
+		maxLeverage = sym.MaxLeverage
+	}
+	if order.Leverage < 1 || order.Leverage > maxLeverage {
+		return reject(-4028, "Leverage %d is not valid", order.Leverage)
+	}
+
+	count, err := c.orderStore.CountOpenByAPIKey(order.APIKey)
+	if err != nil {
+		return err
+	}
+	if count >= c.intConfig("max_orders_per_api_key", 100) {
+		return reject(-2025, "Reach max open order limit.")
+	}
+	return nil
+}
+
+// checkQuantity 数量、精度和 LOT_SIZE
+func checkQuantity(order *models.Order, sym *models.Symbol) error {
+	if !order.Quantity.IsPositive() {
+		return reject(-4003, "Quantity less than or equal to zero.")
+	}
+	if !hasPrecision(order.Quantity, sym.QuantityPrecision) {
+		return reject(-1111, "Precision is over the maximum defined for this asset.")
+	}
+	if order.Quantity.LessThan(sym.MinQty) {
+		return reject(-4004, "Quantity less than min quantity.")
+	}
+	if order.Quantity.GreaterThan(sym.MaxQty) {
+		return reject(-4005, "Quantity greater than max quantity.")
+	}
+	if !isMultiple(order.Quantity, sym.StepSize) {
+		return reject(-4023, "Quantity not increased by step size.")
+	}
+	return nil
+}
+
+// checkPrice 限价单的价格、精度和 PRICE_FILTER
+func checkPrice(order *models.Order, sym *models.Symbol) error {
+	if !order.Price.IsPositive() {
+		return reject(-4001, "Price less than or equal to 0.")
+	}
+	if !hasPrecision(order.Price, sym.PricePrecision) {
+		return reject(-1111, "Precision is over the maximum defined for this asset.")
+	}
+	if order.Price.LessThan(sym.MinPrice) {
+		return reject(-4024, "Limit price can't be lower than %s.", sym.MinPrice)
+	}
+	if order.Price.GreaterThan(sym.MaxPrice) {
+		return reject(-4016, "Limit price can't be higher than %s.", sym.MaxPrice)
+	}
+	if !isMultiple(order.Price, sym.TickSize) {
+		return reject(-4014, "Price not increased by tick size.")
+	}
+	return nil
+}
+
+// checkStopPrice 条件单触发价的精度和 tick
+func checkStopPrice(stopPrice decimal.Decimal, sym *models.Symbol) error {
+	if !hasPrecision(stopPrice, sym.PricePrecision) {
+		return reject(-1111, "Precision is over the maximum defined for this asset.")
+	}
+	if !isMultiple(stopPrice, sym.TickSize) {
+		return reject(-4014, "Price not increased by tick size.")
+	}
+	return nil
+}
+
+// Symbols 交易对规则列表，不在 supported_symbols（没有行情）中的交易对状态为 BREAK
+func (c *Checker) Symbols() ([]models.Symbol, error) {
+	symbols, err := c.symbolStore.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range symbols {
+		c.applyStatus(&symbols[i])
+	}
+	return symbols, nil
+}
+
+// Symbol 获取单个交易对规则，不存在时返回 nil
+func (c *Checker) Symbol(symbol string) (*models.Symbol, error) {
+	sym, err := c.symbolStore.Get(symbol)
+	if err != nil || sym == nil {
+		return nil, err
+	}
+	c.applyStatus(sym)
+	return sym, nil
+}
+
+func (c *Checker) applyStatus(sym *models.Symbol) {
+	if !c.supported(sym.Symbol) {
+		sym.Status = models.SymbolStatusBreak
+	}
+}
+
+// DefaultLeverage 下单未指定杠杆时使用的倍数
+func (c *Checker) DefaultLeverage() int {
+	return c.intConfig("default_leverage", 10)
+}
+
+// MaxLeverage 账户可用的最大杠杆：account_max_leverage 中单独配置的上限优先，否则使用 max_leverage
+func (c *Checker) MaxLeverage(apiKey string) int {
+	max := c.intConfig("max_leverage", 125)
+
+	v, err := c.configStore.Get("account_max_leverage")
+	if err != nil {
+		return max
+	}
+	var caps map[string]int
+	if err := json.Unmarshal([]byte(v), &caps); err != nil {
+		return max
+	}
+	if limit, ok := caps[apiKey]; ok && limit > 0 && limit < max {
+		return limit
+	}
+	return max
+}
+
+func (c *Checker) supported(symbol string) bool {
+	v, err := c.configStore.Get("supported_symbols")
+	if err != nil {
+		return false
+	}
+	var symbols []string
+	if err := json.Unmarshal([]byte(v), &symbols); err != nil {
+		return false
+	}
+	for _, s := range symbols {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) intConfig(key string, def int) int {
+	if v, err := c.configStore.Get(key); err == nil {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// hasPrecision 数值的小数位数不超过 decimals
+func hasPrecision(v decimal.Decimal, decimals int) bool {
+	return v.Equal(v.Truncate(int32(decimals)))
+}
+
+// isMultiple v 是否为 step 的整数倍，step 为 0 时不限制
+func isMultiple(v, step decimal.Decimal) bool {
+	if !step.IsPositive() {
+		return true
+	}
+	return v.Mod(step).IsZero()
+}
@@ -0,0 +1,106 @@
+package risk
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"hft-sim/internal/config"
+	"hft-sim/internal/db"
+	"hft-sim/internal/models"
+	"hft-sim/internal/store"
+)
+
+func TestChecker_Check(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	cfg := config.New(database)
+	require.NoError(t, cfg.InitDefaults())
+	require.NoError(t, cfg.Set("account_max_leverage", `{"capped":20}`))
+	require.NoError(t, cfg.Set("max_orders_per_api_key", "1"))
+
+	d := decimal.RequireFromString
+	checker := New(database.DB)
+	symbols := store.NewSymbolStore(database.DB)
+	order := func() *models.Order {
+		return &models.Order{APIKey: "k", Symbol: "BTCUSDT", Side: models.SideBuy, Type: "LIMIT",
+			Price: d("100"), Quantity: d("0.1"), Leverage: 10}
+	}
+	code := func(o *models.Order) int {
+		err := checker.Check(o)
+		var rejected *Error
+		if errors.As(err, &rejected) {
+			return rejected.Code
+		}
+		require.NoError(t, err)
+		return 0
+	}
+
+	assert.Equal(t, 0, code(order()))
+
+	o := order()
+	o.Symbol = "DOGEUSDT"
+	assert.Equal(t, -1121, code(o))
+
+	// 在 symbols 表中但没有行情
+	require.NoError(t, symbols.Save(&models.Symbol{Symbol: "SOLUSDT", BaseAsset: "SOL", QuoteAsset: "USDT",
+		PricePrecision: 3, QuantityPrecision: 2, MinPrice: d("0.001"), MaxPrice: d("10000"), TickSize: d("0.001"),
+		MinQty: d("0.01"), MaxQty: d("10000"), StepSize: d("0.01"), MinNotional: d("5"), MaxLeverage: 50}))
+	o = order()
+	o.Symbol = "SOLUSDT"
+	assert.Equal(t, -1121, code(o))
+
+	o = order()
+	o.Quantity = d("0")
+	assert.Equal(t, -4003, code(o))
+
+	o = order()
+	o.Quantity = d("0.0001")
+	assert.Equal(t, -1111, code(o))
+
+	// BTCUSDT 价格精度 1 位
+	o = order()
+	o.Price = d("100.05")
+	assert.Equal(t, -1111, code(o))
+
+	o = order()
+	o.Quantity = d("2000")
+	assert.Equal(t, -4005, code(o))
+
+	btc, err := symbols.Get("BTCUSDT")
+	require.NoError(t, err)
+	btc.TickSize = d("0.5")
+	btc.MaxLeverage = 50
+	require.NoError(t, symbols.Save(btc))
+	o = order()
+	o.Price = d("100.3")
+	assert.Equal(t, -4014, code(o))
+	o = order()
+	o.Leverage = 75
+	assert.Equal(t, -4028, code(o))
+
+	o = order()
+	o.Quantity = d("0.01")
+	assert.Equal(t, -4164, code(o))
+
+	o = order()
+	o.Leverage = 126
+	assert.Equal(t, -4028, code(o))
+
+	o = order()
+	o.APIKey = "capped"
+	o.Leverage = 25
+	assert.Equal(t, -4028, code(o))
+	assert.Equal(t, 20, checker.MaxLeverage("capped"))
+
+	_, err = database.Exec(`INSERT INTO orders (api_key, symbol, side, type, price, quantity) VALUES ('k', 'BTCUSDT', 'BUY', 'LIMIT', 100, 1)`)
+	require.NoError(t, err)
+	assert.Equal(t, -2025, code(order()))
+}
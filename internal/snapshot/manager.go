@@ -3,27 +3,42 @@ package snapshot
 import (
 	"database/sql"
 	"log"
+	"sync"
 	"time"
 
+	"hft-sim/internal/clock"
 	"hft-sim/internal/store"
 )
 
+// snapshotInterval 定时快照间隔
+const snapshotInterval = 10 * time.Minute
+
 type Manager struct {
 	db            *sql.DB
 	snapshotStore *store.SnapshotStore
 	balanceStore  *store.BalanceStore
-	stop          chan struct{}
+	clock         clock.Clock
+
+	mu      sync.Mutex
+	timer   clock.Timer
+	stopped bool
 }
 
 func NewManager(db *sql.DB) *Manager {
 	return &Manager{
 		db:            db,
-	snapshotStore: store.NewSnapshotStore(db),
+		snapshotStore: store.NewSnapshotStore(db),
 		balanceStore:  store.NewBalanceStore(db),
-		stop:          make(chan struct{}),
+		clock:         clock.Real{},
 	}
 }
 
+// SetClock 设置时间来源，快照按该时钟定时并记录快照时间，需在 Start 之前调用
+func (m *Manager) SetClock(c clock.Clock) {
+	m.clock = c
+	m.snapshotStore.SetClock(c)
+}
+
 // Start 启动定时快照任务
 func (m *Manager) Start() {
 	log.Println("Starting PNL snapshot manager...")
@@ -32,23 +47,30 @@ func (m *Manager) Start() {
 	m.takeSnapshot()
 
 	// 每10分钟执行一次
-	ticker := time.NewTicker(10 * time.Minute)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				m.takeSnapshot()
-			case <-m.stop:
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+	m.schedule()
 }
 
 // Stop 停止定时任务
 func (m *Manager) Stop() {
-	close(m.stop)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
+
+// schedule 一个间隔后创建快照，完成后继续安排下一次
+func (m *Manager) schedule() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	m.timer = m.clock.AfterFunc(snapshotInterval, func() {
+		m.takeSnapshot()
+		m.schedule()
+	})
 }
 
 // takeSnapshot 为所有策略创建收益快照
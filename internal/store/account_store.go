@@ -0,0 +1,96 @@
+package store
+
+import (
+	"database/sql"
+
+	"hft-sim/internal/models"
+)
+
+// AccountStore 账户级别的交易设置
+type AccountStore struct {
+	db DBTX
+}
+
+func NewAccountStore(db *sql.DB) *AccountStore {
+	return &AccountStore{db: db}
+}
+
+// WithTx 返回在事务 tx 内执行的副本
+func (s *AccountStore) WithTx(tx *sql.Tx) *AccountStore {
+	return &AccountStore{db: tx}
+}
+
+// GetDualSidePosition 是否为双向持仓模式，未设置时默认单向持仓
+func (s *AccountStore) GetDualSidePosition(apiKey string) (bool, error) {
+	var dual bool
+	err := s.db.QueryRow(`SELECT dual_side_position FROM account_settings WHERE api_key = ?`, apiKey).Scan(&dual)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return dual, err
+}
+
+func (s *AccountStore) SetDualSidePosition(apiKey string, dual bool) error {
+	query := `
+		INSERT INTO account_settings (api_key, dual_side_position)
+		VALUES (?, ?)
+		ON CONFLICT(api_key) DO UPDATE SET dual_side_position = excluded.dual_side_position
+	`
+	_, err := s.db.Exec(query, apiKey, dual)
+	return err
+}
+
+// GetMarginType 获取账户在某个 symbol 上的保证金模式，未设置时默认全仓
+func (s *AccountStore) GetMarginType(apiKey, symbol string) (models.MarginType, error) {
+	var marginType models.MarginType
+	err := s.db.QueryRow(`SELECT margin_type FROM symbol_settings WHERE api_key = ? AND symbol = ?`,
+		apiKey, symbol).Scan(&marginType)
+	if err == sql.ErrNoRows {
+		return models.MarginTypeCrossed, nil
+	}
+	return marginType, err
+}
+
+func (s *AccountStore) SetMarginType(apiKey, symbol string, marginType models.MarginType) error {
+	query := `
+		INSERT INTO symbol_settings (api_key, symbol, margin_type)
+		VALUES (?, ?, ?)
+		ON CONFLICT(api_key, symbol) DO UPDATE SET margin_type = excluded.margin_type
+	`
+	_, err := s.db.Exec(query, apiKey, symbol, marginType)
+	return err
+}
+
+// GetFeeTier 账户的手续费等级，空字符串表示使用默认费率
+func (s *AccountStore) GetFeeTier(apiKey string) (string, error) {
+	var tier string
+	err := s.db.QueryRow(`SELECT COALESCE(fee_tier, '') FROM api_keys WHERE key = ?`, apiKey).Scan(&tier)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return tier, err
+}
+
+// GetFeeTiers 返回所有分配了手续费等级的账户，apiKey -> 等级
+func (s *AccountStore) GetFeeTiers() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT key, fee_tier FROM api_keys WHERE COALESCE(fee_tier, '') != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiers := make(map[string]string)
+	for rows.Next() {
+		var apiKey, tier string
+		if err := rows.Scan(&apiKey, &tier); err != nil {
+			return nil, err
+		}
+		tiers[apiKey] = tier
+	}
+	return tiers, rows.Err()
+}
+
+func (s *AccountStore) SetFeeTier(apiKey, tier string) error {
+	_, err := s.db.Exec(`UPDATE api_keys SET fee_tier = ? WHERE key = ?`, tier, apiKey)
+	return err
+}
@@ -2,15 +2,28 @@ package store
 
 import (
 	"database/sql"
+
+	"hft-sim/internal/clock"
 	"hft-sim/internal/models"
 )
 
 type BalanceStore struct {
-	db *sql.DB
+	db    DBTX
+	clock clock.Clock
 }
 
 func NewBalanceStore(db *sql.DB) *BalanceStore {
-	return &BalanceStore{db: db}
+	return &BalanceStore{db: db, clock: clock.Real{}}
+}
+
+// SetClock 设置写入时间戳使用的时钟
+func (s *BalanceStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// WithTx 返回在事务 tx 内执行的副本，时钟与原 store 相同
+func (s *BalanceStore) WithTx(tx *sql.Tx) *BalanceStore {
+	return &BalanceStore{db: tx, clock: s.clock}
 }
 
 func (s *BalanceStore) Get(apiKey string) (*models.Balance, error) {
@@ -19,7 +32,7 @@ func (s *BalanceStore) Get(apiKey string) (*models.Balance, error) {
 	var b models.Balance
 	err := s.db.QueryRow(query, apiKey).Scan(&b.APIKey, &b.Available, &b.Frozen, &b.TotalPNL)
 	if err == sql.ErrNoRows {
-		return &models.Balance{APIKey: apiKey, Available: 0}, nil
+		return &models.Balance{APIKey: apiKey}, nil
 	}
 	if err != nil {
 		return nil, err
@@ -27,16 +40,17 @@ func (s *BalanceStore) Get(apiKey string) (*models.Balance, error) {
 	return &b, nil
 }
 
+// Update 覆盖余额投影，只由 LedgerStore 在记账时调用，其他地方的余额变动都应通过 LedgerStore.Post 记账
 func (s *BalanceStore) Update(balance *models.Balance) error {
 	query := `
-		INSERT INTO balances (api_key, available, frozen, total_pnl)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO balances (api_key, available, frozen, total_pnl, updated_at)
+		VALUES (?, ?, ?, ?, ?)
 		ON CONFLICT(api_key) DO UPDATE SET
 			available = excluded.available,
 			frozen = excluded.frozen,
 			total_pnl = excluded.total_pnl,
-			updated_at = CURRENT_TIMESTAMP
+			updated_at = excluded.updated_at
 	`
-	_, err := s.db.Exec(query, balance.APIKey, balance.Available, balance.Frozen, balance.TotalPNL)
+	_, err := s.db.Exec(query, balance.APIKey, balance.Available, balance.Frozen, balance.TotalPNL, utcNow(s.clock))
 	return err
 }
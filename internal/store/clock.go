@@ -0,0 +1,12 @@
+package store
+
+import (
+	"time"
+
+	"hft-sim/internal/clock"
+)
+
+// utcNow 写入数据库的时间统一取自注入的时钟并转为 UTC，不依赖 CURRENT_TIMESTAMP，回放时记录的是模拟时间
+func utcNow(c clock.Clock) time.Time {
+	return c.Now().UTC()
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"hft-sim/internal/models"
+)
+
+// FundingStore 资金费率历史和资金费收付记录，时间统一按 UTC 存储以便按字符串比较
+type FundingStore struct {
+	db DBTX
+}
+
+func NewFundingStore(db *sql.DB) *FundingStore {
+	return &FundingStore{db: db}
+}
+
+// WithTx 返回在事务 tx 内执行的副本
+func (s *FundingStore) WithTx(tx *sql.Tx) *FundingStore {
+	return &FundingStore{db: tx}
+}
+
+// CreateRate 记录一次结算使用的资金费率，同一 symbol、同一结算时间已有记录时保留原记录
+func (s *FundingStore) CreateRate(rate *models.FundingRate) error {
+	_, err := s.db.Exec(`INSERT INTO funding_rates (symbol, funding_rate, mark_price, funding_time) VALUES (?, ?, ?, ?)
+		ON CONFLICT(symbol, funding_time) DO NOTHING`,
+		rate.Symbol, rate.FundingRate, rate.MarkPrice, rate.FundingTime.UTC())
+	return err
+}
+
+// HasRate symbol 在 fundingTime 是否已经结算过
+func (s *FundingStore) HasRate(symbol string, fundingTime time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM funding_rates WHERE symbol = ? AND funding_time = ?)`,
+		symbol, fundingTime.UTC()).Scan(&exists)
+	return exists, err
+}
+
+// GetRates 按结算时间升序返回资金费率历史
+// symbol 为空时返回所有交易对；未指定 startTime 时返回 endTime（为零时不限）之前最近的 limit 条
+func (s *FundingStore) GetRates(symbol string, startTime, endTime time.Time, limit int) ([]models.FundingRate, error) {
+	if endTime.IsZero() {
+		endTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if startTime.IsZero() {
+		rows, err = s.db.Query(`
+			SELECT symbol, funding_rate, mark_price, funding_time FROM (
+				SELECT id, symbol, funding_rate, mark_price, funding_time FROM funding_rates
+				WHERE (? = '' OR symbol = ?) AND funding_time <= ? ORDER BY funding_time DESC, id DESC LIMIT ?
+			) ORDER BY funding_time ASC, id ASC`, symbol, symbol, endTime.UTC(), limit)
+	} else {
+		rows, err = s.db.Query(`
+			SELECT symbol, funding_rate, mark_price, funding_time FROM funding_rates
+			WHERE (? = '' OR symbol = ?) AND funding_time >= ? AND funding_time <= ?
+			ORDER BY funding_time ASC, id ASC LIMIT ?`, symbol, symbol, startTime.UTC(), endTime.UTC(), limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.FundingRate
+	for rows.Next() {
+		var r models.FundingRate
+		if err := rows.Scan(&r.Symbol, &r.FundingRate, &r.MarkPrice, &r.FundingTime); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// ErrDuplicateFunding 持仓在同一结算时间的资金费已经收付过
+var ErrDuplicateFunding = errors.New("duplicate funding payment")
+
+// CreatePayment 记录一个持仓的资金费收付，同一持仓、同一结算时间已有记录时返回 ErrDuplicateFunding
+func (s *FundingStore) CreatePayment(p *models.FundingPayment) error {
+	result, err := s.db.Exec(`
+		INSERT INTO funding_payments (api_key, symbol, position_side, position_size, mark_price, funding_rate, amount, funding_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(api_key, symbol, position_side, funding_time) DO NOTHING`,
+		p.APIKey, p.Symbol, p.PositionSide, p.PositionSize, p.MarkPrice, p.FundingRate, p.Amount, p.FundingTime.UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDuplicateFunding
+	}
+	p.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetPaymentsByAPIKey 获取账户的资金费收付记录，按结算时间倒序
+func (s *FundingStore) GetPaymentsByAPIKey(apiKey string, limit int) ([]models.FundingPayment, error) {
+	rows, err := s.db.Query(`
+		SELECT id, api_key, symbol, position_side, position_size, mark_price, funding_rate, amount, funding_time
+		FROM funding_payments WHERE api_key = ? ORDER BY funding_time DESC, id DESC LIMIT ?`, apiKey, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.FundingPayment
+	for rows.Next() {
+		var p models.FundingPayment
+		err := rows.Scan(&p.ID, &p.APIKey, &p.Symbol, &p.PositionSide, &p.PositionSize,
+			&p.MarkPrice, &p.FundingRate, &p.Amount, &p.FundingTime)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
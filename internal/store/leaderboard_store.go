@@ -39,7 +39,7 @@ func (s *LeaderboardStore) GetLeaderboard() ([]LeaderboardEntry, error) {
 		LEFT JOIN balances b ON a.key = b.api_key
 		LEFT JOIN trades t ON a.key = t.api_key
 		GROUP BY a.key
-		ORDER BY total_pnl DESC
+		ORDER BY CAST(total_pnl AS REAL) DESC
 	`
 
 	rows, err := s.db.Query(query)
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/models"
+)
+
+// LedgerStore 资金流水，账户余额的每次变动都记为一条不可修改的分录
+// balances 表是流水的投影：Post 在记账的同时更新它，Rebuild 可按全部流水重新计算
+type LedgerStore struct {
+	db    DBTX
+	clock clock.Clock
+}
+
+func NewLedgerStore(db *sql.DB) *LedgerStore {
+	return &LedgerStore{db: db, clock: clock.Real{}}
+}
+
+// SetClock 设置写入时间戳使用的时钟
+func (s *LedgerStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// WithTx 返回在事务 tx 内执行的副本，时钟与原 store 相同
+func (s *LedgerStore) WithTx(tx *sql.Tx) *LedgerStore {
+	return &LedgerStore{db: tx, clock: s.clock}
+}
+
+// inTx 在事务内执行 fn；store 本身已在事务内时直接执行
+func (s *LedgerStore) inTx(fn func(*LedgerStore) error) error {
+	db, ok := s.db.(*sql.DB)
+	if !ok {
+		return fn(s)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(s.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Post 按顺序记账并更新余额投影，流水和余额在同一个事务内写入
+// 金额为负的分录交换借贷科目后按正数记账，金额为 0 的分录不记
+func (s *LedgerStore) Post(entries ...*models.LedgerEntry) error {
+	return s.inTx(func(s *LedgerStore) error {
+		balances := &BalanceStore{db: s.db, clock: s.clock}
+		for _, e := range entries {
+			if e.Amount.IsZero() {
+				continue
+			}
+			if e.Amount.IsNegative() {
+				e.Debit, e.Credit = e.Credit, e.Debit
+				e.Amount = e.Amount.Neg()
+			}
+
+			balance, err := balances.Get(e.APIKey)
+			if err != nil {
+				return err
+			}
+			e.Apply(balance)
+			e.AvailableAfter, e.FrozenAfter, e.TotalPNLAfter = balance.Available, balance.Frozen, balance.TotalPNL
+			if err := s.create(e); err != nil {
+				return err
+			}
+			if err := balances.Update(balance); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LedgerStore) create(e *models.LedgerEntry) error {
+	e.Time = utcNow(s.clock)
+	result, err := s.db.Exec(`
+		INSERT INTO ledger (api_key, type, symbol, debit_account, credit_account, amount,
+			available_after, frozen_after, total_pnl_after, trade_id, info, time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.APIKey, e.Type, e.Symbol, e.Debit, e.Credit, e.Amount,
+		e.AvailableAfter, e.FrozenAfter, e.TotalPNLAfter, e.TradeID, e.Info, e.Time)
+	if err != nil {
+		return err
+	}
+	e.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// Rebuild 从零开始按记账顺序重放账户的全部流水，覆盖 balances 中的余额并返回
+func (s *LedgerStore) Rebuild(apiKey string) (*models.Balance, error) {
+	balance := &models.Balance{APIKey: apiKey}
+	err := s.inTx(func(s *LedgerStore) error {
+		entries, err := s.query(`WHERE api_key = ? ORDER BY id ASC`, apiKey)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			entries[i].Apply(balance)
+		}
+		return (&BalanceStore{db: s.db, clock: s.clock}).Update(balance)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// List 按记账顺序返回账户的流水，symbol、entryType 为空时不过滤
+// 未指定 startTime 时返回 endTime（为零时不限）之前最近的 limit 条
+func (s *LedgerStore) List(apiKey, symbol string, entryType models.LedgerType, startTime, endTime time.Time, limit int) ([]models.LedgerEntry, error) {
+	if endTime.IsZero() {
+		endTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	filter := `WHERE api_key = ? AND (? = '' OR symbol = ?) AND (? = '' OR type = ?) AND time >= ? AND time <= ?`
+	args := []any{apiKey, symbol, symbol, entryType, entryType, startTime.UTC(), endTime.UTC(), limit}
+	if startTime.IsZero() {
+		return s.query(`WHERE id IN (SELECT id FROM ledger `+filter+` ORDER BY id DESC LIMIT ?) ORDER BY id ASC`, args...)
+	}
+	return s.query(filter+` ORDER BY id ASC LIMIT ?`, args...)
+}
+
+func (s *LedgerStore) query(where string, args ...any) ([]models.LedgerEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT id, api_key, type, symbol, debit_account, credit_account, amount,
+			available_after, frozen_after, total_pnl_after, trade_id, info, time
+		FROM ledger `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.LedgerEntry
+	for rows.Next() {
+		var e models.LedgerEntry
+		err := rows.Scan(&e.ID, &e.APIKey, &e.Type, &e.Symbol, &e.Debit, &e.Credit, &e.Amount,
+			&e.AvailableAfter, &e.FrozenAfter, &e.TotalPNLAfter, &e.TradeID, &e.Info, &e.Time)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
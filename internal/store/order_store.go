@@ -2,35 +2,60 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
 	"hft-sim/internal/models"
 )
 
 type OrderStore struct {
-	db *sql.DB
+	db    DBTX
+	clock clock.Clock
 }
 
 func NewOrderStore(db *sql.DB) *OrderStore {
-	return &OrderStore{db: db}
+	return &OrderStore{db: db, clock: clock.Real{}}
+}
+
+// SetClock 设置写入时间戳使用的时钟
+func (s *OrderStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// WithTx 返回在事务 tx 内执行的副本，时钟与原 store 相同
+func (s *OrderStore) WithTx(tx *sql.Tx) *OrderStore {
+	return &OrderStore{db: tx, clock: s.clock}
 }
 
 func (s *OrderStore) Create(order *models.Order) error {
+	now := utcNow(s.clock)
 	query := `
-		INSERT INTO orders (api_key, symbol, side, type, price, quantity, leverage, client_order_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO orders (api_key, symbol, side, type, price, quantity, stop_price, activate_price, price_rate,
+			time_in_force, reduce_only, close_position, leverage, position_side, client_order_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	result, err := s.db.Exec(query, order.APIKey, order.Symbol, order.Side, order.Type,
-		order.Price, order.Quantity, order.Leverage, order.ClientOrderID)
+		order.Price, order.Quantity, order.StopPrice, order.ActivatePrice, order.PriceRate,
+		order.TimeInForce, order.ReduceOnly, order.ClosePosition, order.Leverage, order.PositionSide, order.ClientOrderID,
+		now, now)
 	if err != nil {
 		return err
 	}
 	order.ID, _ = result.LastInsertId()
+	order.CreatedAt = now
+	order.UpdatedAt = now
 	return nil
 }
 
+const orderColumns = `id, api_key, symbol, side, type, price, COALESCE(avg_price, 0), quantity, executed_qty,
+	COALESCE(stop_price, 0), COALESCE(activate_price, 0), COALESCE(price_rate, 0), trigger_time, COALESCE(time_in_force, 'GTC'),
+	COALESCE(reduce_only, 0), COALESCE(close_position, 0), leverage, COALESCE(position_side, 'BOTH'), status, client_order_id, created_at, updated_at`
+
 func (s *OrderStore) GetByAPIKey(apiKey string) ([]models.Order, error) {
-	query := `SELECT id, api_key, symbol, side, type, price, quantity, executed_qty,
-	          leverage, status, client_order_id, created_at, updated_at
-	          FROM orders WHERE api_key = ? ORDER BY created_at DESC`
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE api_key = ? ORDER BY created_at DESC`
 
 	rows, err := s.db.Query(query, apiKey)
 	if err != nil {
@@ -38,24 +63,11 @@ func (s *OrderStore) GetByAPIKey(apiKey string) ([]models.Order, error) {
 	}
 	defer rows.Close()
 
-	var orders []models.Order
-	for rows.Next() {
-		var o models.Order
-		err := rows.Scan(&o.ID, &o.APIKey, &o.Symbol, &o.Side, &o.Type, &o.Price,
-			&o.Quantity, &o.ExecutedQty, &o.Leverage, &o.Status, &o.ClientOrderID,
-			&o.CreatedAt, &o.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		orders = append(orders, o)
-	}
-	return orders, nil
+	return scanOrders(rows)
 }
 
 func (s *OrderStore) GetOpenBySymbol(symbol string) ([]models.Order, error) {
-	query := `SELECT id, api_key, symbol, side, type, price, quantity, executed_qty,
-	          leverage, status, client_order_id, created_at, updated_at
-	          FROM orders WHERE symbol = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE symbol = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`
 
 	rows, err := s.db.Query(query, symbol)
 	if err != nil {
@@ -63,44 +75,147 @@ func (s *OrderStore) GetOpenBySymbol(symbol string) ([]models.Order, error) {
 	}
 	defer rows.Close()
 
+	return scanOrders(rows)
+}
+
+// GetOpen 获取所有未成交订单，按下单先后排序（用于启动时重建内存订单簿）
+func (s *OrderStore) GetOpen() ([]models.Order, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE status IN ('NEW', 'PARTIALLY_FILLED') ORDER BY id ASC`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrders(rows)
+}
+
+// GetOpenByPositionSide 获取账户在某个 symbol 的一条持仓腿上 side 方向的未成交订单（含未触发的条件单）
+func (s *OrderStore) GetOpenByPositionSide(apiKey, symbol string, positionSide models.PositionSide, side models.Side) ([]models.Order, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders
+	          WHERE api_key = ? AND symbol = ? AND position_side = ? AND side = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`
+
+	rows, err := s.db.Query(query, apiKey, symbol, positionSide, side)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrders(rows)
+}
+
+// CountOpenByAPIKey 统计账户未成交订单数量
+func (s *OrderStore) CountOpenByAPIKey(apiKey string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE api_key = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`,
+		apiKey).Scan(&count)
+	return count, err
+}
+
+// CountOpenBySymbol 统计账户在某个 symbol 上的未成交订单数量
+func (s *OrderStore) CountOpenBySymbol(apiKey, symbol string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE api_key = ? AND symbol = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`,
+		apiKey, symbol).Scan(&count)
+	return count, err
+}
+
+func scanOrders(rows *sql.Rows) ([]models.Order, error) {
 	var orders []models.Order
 	for rows.Next() {
-		var o models.Order
-		err := rows.Scan(&o.ID, &o.APIKey, &o.Symbol, &o.Side, &o.Type, &o.Price,
-			&o.Quantity, &o.ExecutedQty, &o.Leverage, &o.Status, &o.ClientOrderID,
+		var (
+			o           models.Order
+			triggerTime sql.NullTime
+		)
+		err := rows.Scan(&o.ID, &o.APIKey, &o.Symbol, &o.Side, &o.Type, &o.Price, &o.AvgPrice,
+			&o.Quantity, &o.ExecutedQty, &o.StopPrice, &o.ActivatePrice, &o.PriceRate, &triggerTime,
+			&o.TimeInForce, &o.ReduceOnly, &o.ClosePosition, &o.Leverage, &o.PositionSide, &o.Status, &o.ClientOrderID,
 			&o.CreatedAt, &o.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		if triggerTime.Valid {
+			o.TriggerTime = &triggerTime.Time
+		}
 		orders = append(orders, o)
 	}
-	return orders, nil
+	return orders, rows.Err()
 }
 
-func (s *OrderStore) UpdateStatus(id int64, status models.OrderStatus, executedQty float64) error {
-	_, err := s.db.Exec(`UPDATE orders SET status = ?, executed_qty = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
-		status, executedQty, id)
+// UpdateStatus 更新订单状态、累计成交数量和成交均价
+func (s *OrderStore) UpdateStatus(id int64, status models.OrderStatus, executedQty, avgPrice decimal.Decimal) error {
+	_, err := s.db.Exec(`UPDATE orders SET status = ?, executed_qty = ?, avg_price = ?, updated_at = ? WHERE id = ?`,
+		status, executedQty, avgPrice, utcNow(s.clock), id)
 	return err
 }
 
 func (s *OrderStore) Cancel(id int64) error {
-	_, err := s.db.Exec(`UPDATE orders SET status = 'CANCELLED', updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`, id)
+	_, err := s.db.Exec(`UPDATE orders SET status = 'CANCELLED', updated_at = ? WHERE id = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`,
+		utcNow(s.clock), id)
 	return err
 }
 
+// MarkTriggered 记录条件单的触发时间
+func (s *OrderStore) MarkTriggered(id int64, t time.Time) error {
+	_, err := s.db.Exec(`UPDATE orders SET trigger_time = ?, updated_at = ? WHERE id = ?`, t.UTC(), utcNow(s.clock), id)
+	return err
+}
+
+// Resize 修改订单数量，用于只减仓订单按持仓缩小和 closePosition 订单确定平仓数量
+func (s *OrderStore) Resize(id int64, quantity decimal.Decimal) error {
+	_, err := s.db.Exec(`UPDATE orders SET quantity = ?, updated_at = ? WHERE id = ?`, quantity, utcNow(s.clock), id)
+	return err
+}
+
+// Expire 按 timeInForce 规则撤销订单
+func (s *OrderStore) Expire(id int64) error {
+	_, err := s.db.Exec(`UPDATE orders SET status = 'EXPIRED', updated_at = ? WHERE id = ? AND status IN ('NEW', 'PARTIALLY_FILLED')`,
+		utcNow(s.clock), id)
+	return err
+}
+
+// ErrDuplicateFill 相同幂等键的成交已经写入过
+var ErrDuplicateFill = errors.New("duplicate fill")
+
+// CreateTrade 写入成交记录，Timestamp 为空时取当前时钟时间；FillKey 已存在时返回 ErrDuplicateFill
 func (s *OrderStore) CreateTrade(trade *models.Trade) error {
+	if trade.CommissionAsset == "" {
+		trade.CommissionAsset = "USDT"
+	}
+	if trade.Timestamp.IsZero() {
+		trade.Timestamp = utcNow(s.clock)
+	}
+	trade.Timestamp = trade.Timestamp.UTC()
+	var fillKey sql.NullString
+	if trade.FillKey != "" {
+		fillKey = sql.NullString{String: trade.FillKey, Valid: true}
+	}
 	query := `
-		INSERT INTO trades (order_id, api_key, symbol, side, price, quantity, quote_qty, fee)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO trades (order_id, api_key, symbol, side, price, quantity, quote_qty, fee,
+			commission_asset, is_maker, fill_model, is_liquidation, timestamp, fill_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(fill_key) DO NOTHING
 	`
-	_, err := s.db.Exec(query, trade.OrderID, trade.APIKey, trade.Symbol, trade.Side,
-		trade.Price, trade.Quantity, trade.QuoteQty, trade.Fee)
-	return err
+	result, err := s.db.Exec(query, trade.OrderID, trade.APIKey, trade.Symbol, trade.Side,
+		trade.Price, trade.Quantity, trade.QuoteQty, trade.Fee,
+		trade.CommissionAsset, trade.IsMaker, trade.FillModel, trade.IsLiquidation, trade.Timestamp, fillKey)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDuplicateFill
+	}
+	trade.ID, _ = result.LastInsertId()
+	return nil
 }
 
+const tradeColumns = `id, order_id, api_key, symbol, side, price, quantity, quote_qty, fee,
+	COALESCE(commission_asset, 'USDT'), COALESCE(is_maker, 0), COALESCE(fill_model, ''), is_liquidation, timestamp,
+	COALESCE(fill_key, '')`
+
 func (s *OrderStore) GetTradesByAPIKey(apiKey string) ([]models.Trade, error) {
-	query := `SELECT id, order_id, api_key, symbol, side, price, quantity, quote_qty, fee, timestamp
-	          FROM trades WHERE api_key = ? ORDER BY timestamp DESC`
+	query := `SELECT ` + tradeColumns + ` FROM trades WHERE api_key = ? ORDER BY timestamp DESC`
 
 	rows, err := s.db.Query(query, apiKey)
 	if err != nil {
@@ -108,15 +223,35 @@ func (s *OrderStore) GetTradesByAPIKey(apiKey string) ([]models.Trade, error) {
 	}
 	defer rows.Close()
 
+	return scanTrades(rows)
+}
+
+// GetTrades 按成交 ID 升序查询账户成交，symbol 为空时不限交易对，只返回 ID >= fromID 的记录
+func (s *OrderStore) GetTrades(apiKey, symbol string, fromID int64, limit int) ([]models.Trade, error) {
+	query := `SELECT ` + tradeColumns + ` FROM trades
+	          WHERE api_key = ? AND (? = '' OR symbol = ?) AND id >= ?
+	          ORDER BY id ASC LIMIT ?`
+
+	rows, err := s.db.Query(query, apiKey, symbol, symbol, fromID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTrades(rows)
+}
+
+func scanTrades(rows *sql.Rows) ([]models.Trade, error) {
 	var trades []models.Trade
 	for rows.Next() {
 		var t models.Trade
 		err := rows.Scan(&t.ID, &t.OrderID, &t.APIKey, &t.Symbol, &t.Side,
-			&t.Price, &t.Quantity, &t.QuoteQty, &t.Fee, &t.Timestamp)
+			&t.Price, &t.Quantity, &t.QuoteQty, &t.Fee, &t.CommissionAsset, &t.IsMaker,
+			&t.FillModel, &t.IsLiquidation, &t.Timestamp, &t.FillKey)
 		if err != nil {
 			return nil, err
 		}
 		trades = append(trades, t)
 	}
-	return trades, nil
+	return trades, rows.Err()
 }
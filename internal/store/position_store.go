@@ -2,25 +2,41 @@ package store
 
 import (
 	"database/sql"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
 	"hft-sim/internal/models"
 )
 
 type PositionStore struct {
-	db *sql.DB
+	db    DBTX
+	clock clock.Clock
 }
 
 func NewPositionStore(db *sql.DB) *PositionStore {
-	return &PositionStore{db: db}
+	return &PositionStore{db: db, clock: clock.Real{}}
+}
+
+// SetClock 设置写入时间戳使用的时钟
+func (s *PositionStore) SetClock(c clock.Clock) {
+	s.clock = c
 }
 
-func (s *PositionStore) Get(apiKey, symbol string) (*models.Position, error) {
-	query := `SELECT api_key, symbol, side, entry_price, size, leverage, margin, unrealized_pnl, updated_at
-	          FROM positions WHERE api_key = ? AND symbol = ?`
+// WithTx 返回在事务 tx 内执行的副本，时钟与原 store 相同
+func (s *PositionStore) WithTx(tx *sql.Tx) *PositionStore {
+	return &PositionStore{db: tx, clock: s.clock}
+}
+
+// Get 获取持仓的一条腿，单向持仓模式下 positionSide 为 BOTH
+func (s *PositionStore) Get(apiKey, symbol string, positionSide models.PositionSide) (*models.Position, error) {
+	query := `SELECT api_key, symbol, position_side, side, entry_price, size, leverage, margin_type, margin, unrealized_pnl, updated_at
+	          FROM positions WHERE api_key = ? AND symbol = ? AND position_side = ?`
 
 	var p models.Position
-	err := s.db.QueryRow(query, apiKey, symbol).Scan(
-		&p.APIKey, &p.Symbol, &p.Side, &p.EntryPrice, &p.Size,
-		&p.Leverage, &p.Margin, &p.UnrealizedPNL, &p.UpdatedAt)
+	err := s.db.QueryRow(query, apiKey, symbol, positionSide).Scan(
+		&p.APIKey, &p.Symbol, &p.PositionSide, &p.Side, &p.EntryPrice, &p.Size,
+		&p.Leverage, &p.MarginType, &p.Margin, &p.UnrealizedPNL, &p.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -32,7 +48,7 @@ func (s *PositionStore) Get(apiKey, symbol string) (*models.Position, error) {
 }
 
 func (s *PositionStore) GetByAPIKey(apiKey string) ([]models.Position, error) {
-	query := `SELECT api_key, symbol, side, entry_price, size, leverage, margin, unrealized_pnl, updated_at
+	query := `SELECT api_key, symbol, position_side, side, entry_price, size, leverage, margin_type, margin, unrealized_pnl, updated_at
 	          FROM positions WHERE api_key = ?`
 
 	rows, err := s.db.Query(query, apiKey)
@@ -41,38 +57,88 @@ func (s *PositionStore) GetByAPIKey(apiKey string) ([]models.Position, error) {
 	}
 	defer rows.Close()
 
+	return scanPositions(rows)
+}
+
+// GetAll 获取全部持仓（用于强平检查的内存快照）
+func (s *PositionStore) GetAll() ([]models.Position, error) {
+	query := `SELECT api_key, symbol, position_side, side, entry_price, size, leverage, margin_type, margin, unrealized_pnl, updated_at
+	          FROM positions`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPositions(rows)
+}
+
+// GetBySymbol 获取某个 symbol 的全部持仓（用于按行情重新计算盈亏和强平）
+func (s *PositionStore) GetBySymbol(symbol string) ([]models.Position, error) {
+	query := `SELECT api_key, symbol, position_side, side, entry_price, size, leverage, margin_type, margin, unrealized_pnl, updated_at
+	          FROM positions WHERE symbol = ?`
+
+	rows, err := s.db.Query(query, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPositions(rows)
+}
+
+func scanPositions(rows *sql.Rows) ([]models.Position, error) {
 	var positions []models.Position
 	for rows.Next() {
 		var p models.Position
-		err := rows.Scan(&p.APIKey, &p.Symbol, &p.Side, &p.EntryPrice, &p.Size,
-			&p.Leverage, &p.Margin, &p.UnrealizedPNL, &p.UpdatedAt)
+		err := rows.Scan(&p.APIKey, &p.Symbol, &p.PositionSide, &p.Side, &p.EntryPrice, &p.Size,
+			&p.Leverage, &p.MarginType, &p.Margin, &p.UnrealizedPNL, &p.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
 		positions = append(positions, p)
 	}
-	return positions, nil
+	return positions, rows.Err()
+}
+
+// UpdateUnrealizedPNL 只更新未实现盈亏，不改动持仓数量
+func (s *PositionStore) UpdateUnrealizedPNL(apiKey, symbol string, positionSide models.PositionSide, pnl decimal.Decimal) error {
+	_, err := s.db.Exec(`UPDATE positions SET unrealized_pnl = ? WHERE api_key = ? AND symbol = ? AND position_side = ?`,
+		pnl, apiKey, symbol, positionSide)
+	return err
 }
 
 func (s *PositionStore) Save(position *models.Position) error {
 	query := `
-		INSERT INTO positions (api_key, symbol, side, entry_price, size, leverage, margin, unrealized_pnl)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(api_key, symbol) DO UPDATE SET
+		INSERT INTO positions (api_key, symbol, position_side, side, entry_price, size, leverage, margin_type, margin, unrealized_pnl, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(api_key, symbol, position_side) DO UPDATE SET
 			side = excluded.side,
 			entry_price = excluded.entry_price,
 			size = excluded.size,
 			leverage = excluded.leverage,
+			margin_type = excluded.margin_type,
 			margin = excluded.margin,
 			unrealized_pnl = excluded.unrealized_pnl,
-			updated_at = CURRENT_TIMESTAMP
+			updated_at = excluded.updated_at
 	`
-	_, err := s.db.Exec(query, position.APIKey, position.Symbol, position.Side,
-		position.EntryPrice, position.Size, position.Leverage, position.Margin, position.UnrealizedPNL)
+	positionSide := position.PositionSide
+	if positionSide == "" {
+		positionSide = models.PositionSideBoth
+	}
+	marginType := position.MarginType
+	if marginType == "" {
+		marginType = models.MarginTypeCrossed
+	}
+	_, err := s.db.Exec(query, position.APIKey, position.Symbol, positionSide, position.Side,
+		position.EntryPrice, position.Size, position.Leverage, marginType, position.Margin, position.UnrealizedPNL,
+		utcNow(s.clock))
 	return err
 }
 
-func (s *PositionStore) Delete(apiKey, symbol string) error {
-	_, err := s.db.Exec(`DELETE FROM positions WHERE api_key = ? AND symbol = ?`, apiKey, symbol)
+func (s *PositionStore) Delete(apiKey, symbol string, positionSide models.PositionSide) error {
+	_, err := s.db.Exec(`DELETE FROM positions WHERE api_key = ? AND symbol = ? AND position_side = ?`,
+		apiKey, symbol, positionSide)
 	return err
 }
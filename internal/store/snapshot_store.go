@@ -3,6 +3,10 @@ package store
 import (
 	"database/sql"
 	"time"
+
+	"github.com/shopspring/decimal"
+
+	"hft-sim/internal/clock"
 )
 
 // PNLSnapshot 收益快照
@@ -16,20 +20,26 @@ type PNLSnapshot struct {
 }
 
 type SnapshotStore struct {
-	db *sql.DB
+	db    *sql.DB
+	clock clock.Clock
 }
 
 func NewSnapshotStore(db *sql.DB) *SnapshotStore {
-	return &SnapshotStore{db: db}
+	return &SnapshotStore{db: db, clock: clock.Real{}}
+}
+
+// SetClock 设置写入时间戳使用的时钟
+func (s *SnapshotStore) SetClock(c clock.Clock) {
+	s.clock = c
 }
 
-// CreateSnapshot 创建收益快照
-func (s *SnapshotStore) CreateSnapshot(apiKey string, totalPNL, available, frozen float64) error {
+// CreateSnapshot 创建收益快照，快照只用于统计和图表，读取时为浮点数
+func (s *SnapshotStore) CreateSnapshot(apiKey string, totalPNL, available, frozen decimal.Decimal) error {
 	query := `
-		INSERT INTO pnl_snapshots (api_key, total_pnl, available, frozen)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO pnl_snapshots (api_key, total_pnl, available, frozen, snapshot_at)
+		VALUES (?, ?, ?, ?, ?)
 	`
-	_, err := s.db.Exec(query, apiKey, totalPNL, available, frozen)
+	_, err := s.db.Exec(query, apiKey, totalPNL, available, frozen, utcNow(s.clock))
 	return err
 }
 
@@ -95,7 +105,7 @@ func (s *SnapshotStore) GetSnapshotByTimeRange(apiKey string, start, end time.Ti
 		ORDER BY snapshot_at ASC
 	`
 
-	rows, err := s.db.Query(query, apiKey, start, end)
+	rows, err := s.db.Query(query, apiKey, start.UTC(), end.UTC())
 	if err != nil {
 		return nil, err
 	}
@@ -119,8 +129,8 @@ func (s *SnapshotStore) GetSnapshotByTimeRange(apiKey string, start, end time.Ti
 func (s *SnapshotStore) DeleteOldSnapshots(days int) error {
 	query := `
 		DELETE FROM pnl_snapshots
-		WHERE snapshot_at < datetime('now', ?)
+		WHERE snapshot_at < ?
 	`
-	_, err := s.db.Exec(query, "-"+string(rune(days*24))+" hours")
+	_, err := s.db.Exec(query, utcNow(s.clock).AddDate(0, 0, -days))
 	return err
 }
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"database/sql"
+
+	"hft-sim/internal/clock"
+	"hft-sim/internal/models"
+)
+
+// SymbolStore 交易对规则
+type SymbolStore struct {
+	db    *sql.DB
+	clock clock.Clock
+}
+
+func NewSymbolStore(db *sql.DB) *SymbolStore {
+	return &SymbolStore{db: db, clock: clock.Real{}}
+}
+
+// SetClock 设置写入时间戳使用的时钟
+func (s *SymbolStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+const symbolColumns = `symbol, base_asset, quote_asset, status, price_precision, quantity_precision,
+	min_price, max_price, tick_size, min_qty, max_qty, step_size, min_notional, max_leverage`
+
+// Get 获取交易对规则，不存在时返回 nil
+func (s *SymbolStore) Get(symbol string) (*models.Symbol, error) {
+	row := s.db.QueryRow(`SELECT `+symbolColumns+` FROM symbols WHERE symbol = ?`, symbol)
+	sym, err := scanSymbol(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sym, err
+}
+
+func (s *SymbolStore) List() ([]models.Symbol, error) {
+	rows, err := s.db.Query(`SELECT ` + symbolColumns + ` FROM symbols ORDER BY symbol`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var symbols []models.Symbol
+	for rows.Next() {
+		sym, err := scanSymbol(rows)
+		if err != nil {
+			return nil, err
+		}
+		symbols = append(symbols, *sym)
+	}
+	return symbols, rows.Err()
+}
+
+func (s *SymbolStore) Save(sym *models.Symbol) error {
+	if sym.Status == "" {
+		sym.Status = models.SymbolStatusTrading
+	}
+	query := `
+		INSERT INTO symbols (` + symbolColumns + `, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(symbol) DO UPDATE SET
+			base_asset = excluded.base_asset,
+			quote_asset = excluded.quote_asset,
+			status = excluded.status,
+			price_precision = excluded.price_precision,
+			quantity_precision = excluded.quantity_precision,
+			min_price = excluded.min_price,
+			max_price = excluded.max_price,
+			tick_size = excluded.tick_size,
+			min_qty = excluded.min_qty,
+			max_qty = excluded.max_qty,
+			step_size = excluded.step_size,
+			min_notional = excluded.min_notional,
+			max_leverage = excluded.max_leverage,
+			updated_at = excluded.updated_at
+	`
+	_, err := s.db.Exec(query, sym.Symbol, sym.BaseAsset, sym.QuoteAsset, sym.Status,
+		sym.PricePrecision, sym.QuantityPrecision, sym.MinPrice, sym.MaxPrice, sym.TickSize,
+		sym.MinQty, sym.MaxQty, sym.StepSize, sym.MinNotional, sym.MaxLeverage, utcNow(s.clock))
+	return err
+}
+
+func (s *SymbolStore) Delete(symbol string) error {
+	_, err := s.db.Exec(`DELETE FROM symbols WHERE symbol = ?`, symbol)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSymbol(row rowScanner) (*models.Symbol, error) {
+	var sym models.Symbol
+	err := row.Scan(&sym.Symbol, &sym.BaseAsset, &sym.QuoteAsset, &sym.Status,
+		&sym.PricePrecision, &sym.QuantityPrecision, &sym.MinPrice, &sym.MaxPrice, &sym.TickSize,
+		&sym.MinQty, &sym.MaxQty, &sym.StepSize, &sym.MinNotional, &sym.MaxLeverage)
+	if err != nil {
+		return nil, err
+	}
+	return &sym, nil
+}
@@ -0,0 +1,10 @@
+package store
+
+import "database/sql"
+
+// DBTX *sql.DB 和 *sql.Tx 共有的方法，store 通过它在事务内外执行同样的语句
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
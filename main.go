@@ -4,13 +4,19 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"hft-sim/internal/api"
+	"hft-sim/internal/clock"
 	"hft-sim/internal/collector"
 	"hft-sim/internal/config"
 	"hft-sim/internal/db"
+	"hft-sim/internal/funding"
+	"hft-sim/internal/liquidation"
 	"hft-sim/internal/matching"
+	"hft-sim/internal/recorder"
+	"hft-sim/internal/replay"
 	"hft-sim/internal/snapshot"
 )
 
@@ -36,22 +42,88 @@ func main() {
 
 	// 获取配置
 	symbols, _ := cfg.GetStringSlice("supported_symbols")
-	wsURL, _ := cfg.Get("binance_ws_url")
+
+	// 行情来源：实时连接币安，或回放录制数据；回放时所有组件使用回放的模拟时钟
+	var source collector.MarketSource
+	var replaySource *replay.Source
+	var clk clock.Clock = clock.Real{}
+	if mode, _ := cfg.Get("market_source"); mode == "replay" {
+		replaySource, err = newReplaySource(cfg, symbols)
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = replaySource
+		clk = replaySource.Clock()
+	} else {
+		// 数据收集器：成交流（断线缺口通过 REST 补齐）和按 diff-depth 流维护的本地盘口
+		wsURL, _ := cfg.Get("binance_ws_url")
+		restURL, _ := cfg.Get("binance_rest_url")
+		coll := collector.New(wsURL, symbols)
+		coll.EnableDepth(restURL)
+		coll.EnableBackfill(restURL)
+		// 成交分发：每个 symbol 每个处理函数一个有序的有界队列
+		queueSize, _ := cfg.Get("dispatch_queue_size")
+		size, _ := strconv.Atoi(queueSize)
+		overflow, _ := cfg.Get("dispatch_overflow")
+		policy, err := collector.ParseOverflowPolicy(overflow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		coll.SetDispatch(size, policy)
+		source = coll
+
+		// 行情录制：recorder_dir 为空时不录制
+		if dir, _ := cfg.Get("recorder_dir"); dir != "" {
+			rec := recorder.New(dir)
+			coll.AddRawHandler(rec.OnRaw)
+			rec.Start()
+			defer rec.Stop()
+		}
+	}
 
 	// 启动撮合引擎
 	engine := matching.NewEngine(database.DB)
+	engine.SetDepthSource(source)
+	engine.SetClock(clk)
+	if replaySource != nil {
+		engine.SetFillSeed(replaySource.Seed())
+	}
+	if err := engine.Load(); err != nil {
+		log.Fatal(err)
+	}
+	source.AddHandler(engine.OnTrade)
 
-	// 启动数据收集器
-	coll := collector.New(wsURL, symbols)
-	coll.AddHandler(engine.OnTrade)
+	// 强平监控
+	liquidator := liquidation.New(database.DB, engine)
+	liquidator.SetClock(clk)
+	source.AddHandler(liquidator.OnTrade)
 
-	if err := coll.Start(); err != nil {
-		log.Fatal(err)
+	// 资金费结算
+	fundingScheduler := funding.New(database.DB, engine, symbols)
+	switch rateSource, _ := cfg.Get("funding_rate_source"); rateSource {
+	case funding.RateSourceLive:
+		futuresURL, _ := cfg.Get("binance_futures_rest_url")
+		fundingScheduler.SetPremiumSource(collector.NewPremiumIndexClient(futuresURL))
+	case funding.RateSourceRecorded:
+		path, _ := cfg.Get("funding_premium_file")
+		recorded, err := funding.LoadRecordedPremium(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fundingScheduler.SetPremiumSource(recorded)
 	}
+	source.AddHandler(fundingScheduler.OnTrade)
+	fundingScheduler.SetClock(clk)
 
 	// 启动 API 服务器
 	server := api.NewServer(database.DB)
-	server.SetCollector(coll)
+	server.SetCollector(source)
+	server.SetClock(clk)
+	if replaySource != nil {
+		server.SetReplay(replaySource)
+	}
+	server.SetEngine(engine)
+	server.SetFunding(fundingScheduler)
 	go func() {
 		if err := server.Run(":8080"); err != nil {
 			log.Fatal(err)
@@ -60,9 +132,18 @@ func main() {
 
 	// 启动收益快照管理器
 	snapshotMgr := snapshot.NewManager(database.DB)
+	snapshotMgr.SetClock(clk)
 	snapshotMgr.Start()
 	defer snapshotMgr.Stop()
 
+	fundingScheduler.Start()
+	defer fundingScheduler.Stop()
+
+	// 行情最后启动，回放时保证所有处理函数和定时任务都已注册
+	if err := source.Start(); err != nil {
+		log.Fatal(err)
+	}
+
 	log.Println("Server running on :8080")
 
 	// Graceful shutdown
@@ -71,5 +152,27 @@ func main() {
 
 	<-sigChan
 	log.Println("Shutting down...")
-	coll.Stop()
+	source.Stop()
+}
+
+// newReplaySource 按 replay_* 配置创建回放源
+func newReplaySource(cfg *config.Config, symbols []string) (*replay.Source, error) {
+	dir, _ := cfg.Get("replay_dir")
+	startValue, _ := cfg.Get("replay_start")
+	endValue, _ := cfg.Get("replay_end")
+	speedValue, _ := cfg.Get("replay_speed")
+
+	start, err := replay.ParseTime(startValue)
+	if err != nil {
+		return nil, err
+	}
+	end, err := replay.ParseTime(endValue)
+	if err != nil {
+		return nil, err
+	}
+	speed, err := replay.ParseSpeed(speedValue)
+	if err != nil {
+		return nil, err
+	}
+	return replay.New(dir, symbols, start, end, speed)
 }
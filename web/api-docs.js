@@ -19,14 +19,20 @@ const API_ENDPOINTS = [
             {
                 method: 'POST',
                 path: '/api/v3/order',
-                desc: '创建限价单',
+                desc: '创建订单（限价单 / 市价单）',
                 auth: true,
                 body: {
                     symbol: { type: 'string', required: true, default: 'BTCUSDT', desc: '交易对' },
                     side: { type: 'string', required: true, default: 'BUY', desc: '方向: BUY/SELL' },
-                    type: { type: 'string', required: true, default: 'LIMIT', desc: '类型: LIMIT' },
-                    quantity: { type: 'string', required: true, default: '0.01', desc: '数量' },
-                    price: { type: 'string', required: true, default: '65000', desc: '价格' },
+                    type: { type: 'string', required: true, default: 'LIMIT', desc: '类型: LIMIT/MARKET/STOP/STOP_MARKET/TAKE_PROFIT/TAKE_PROFIT_MARKET/TRAILING_STOP_MARKET' },
+                    timeInForce: { type: 'string', required: false, default: 'GTC', desc: '有效方式: GTC/IOC/FOK/GTX（仅 LIMIT/STOP/TAKE_PROFIT）' },
+                    quantity: { type: 'string', required: false, default: '0.01', desc: '数量（closePosition=true 时不发送）' },
+                    price: { type: 'string', required: false, default: '65000', desc: '价格（LIMIT/STOP/TAKE_PROFIT 必填）' },
+                    stopPrice: { type: 'string', required: false, default: '', desc: '触发价（STOP/TAKE_PROFIT 系列必填）' },
+                    activationPrice: { type: 'string', required: false, default: '', desc: '追踪止损激活价（默认立即激活）' },
+                    callbackRate: { type: 'string', required: false, default: '', desc: '追踪止损回调比例 0.1~5（%）' },
+                    reduceOnly: { type: 'string', required: false, default: 'false', desc: '只减仓: true/false（单向持仓模式）' },
+                    closePosition: { type: 'string', required: false, default: 'false', desc: '触发后全部平仓: true/false（仅 STOP_MARKET/TAKE_PROFIT_MARKET，不能发送 quantity）' },
                     leverage: { type: 'integer', required: false, default: 10, desc: '杠杆倍数' }
                 }
             },
@@ -52,10 +58,25 @@ const API_ENDPOINTS = [
             {
                 method: 'GET',
                 path: '/api/v3/myTrades',
-                desc: '获取成交历史',
+                desc: '获取成交历史（含 isMaker、commission、commissionAsset）',
                 auth: true,
                 params: [
-                    { name: 'symbol', type: 'string', required: false, default: '', desc: '交易对(可选)' }
+                    { name: 'symbol', type: 'string', required: false, default: '', desc: '交易对(可选)' },
+                    { name: 'fromId', type: 'integer', required: false, default: '', desc: '起始成交ID(可选)' },
+                    { name: 'limit', type: 'integer', required: false, default: 500, desc: '返回条数，最大 1000' }
+                ]
+            },
+            {
+                method: 'GET',
+                path: '/api/v3/income',
+                desc: '资金流水（手续费、实现盈亏、资金费、保证金划转、入金和调账，含记账后余额）',
+                auth: true,
+                params: [
+                    { name: 'symbol', type: 'string', required: false, default: '', desc: '交易对(可选)' },
+                    { name: 'incomeType', type: 'string', required: false, default: '', desc: 'COMMISSION / REALIZED_PNL / FUNDING_FEE / MARGIN_FREEZE / MARGIN_RELEASE / DEPOSIT / ADJUSTMENT(可选)' },
+                    { name: 'startTime', type: 'integer', required: false, default: '', desc: '起始时间（毫秒）' },
+                    { name: 'endTime', type: 'integer', required: false, default: '', desc: '结束时间（毫秒）' },
+                    { name: 'limit', type: 'integer', required: false, default: 100, desc: '返回条数，最大 1000' }
                 ]
             }
         ]
@@ -73,10 +94,32 @@ const API_ENDPOINTS = [
             {
                 method: 'GET',
                 path: '/api/v3/depth',
-                desc: '获取订单簿深度',
+                desc: '获取订单簿深度（币安 diff-depth 流维护的真实盘口）',
+                auth: false,
+                params: [
+                    { name: 'symbol', type: 'string', required: true, default: 'BTCUSDT', desc: '交易对' },
+                    { name: 'limit', type: 'integer', required: false, default: 100, desc: '档位数量（最大 5000）' }
+                ]
+            },
+            {
+                method: 'GET',
+                path: '/fapi/v1/fundingRate',
+                desc: '资金费率结算历史',
+                auth: false,
+                params: [
+                    { name: 'symbol', type: 'string', required: false, default: 'BTCUSDT', desc: '交易对（不填返回全部）' },
+                    { name: 'startTime', type: 'integer', required: false, default: '', desc: '起始时间（毫秒）' },
+                    { name: 'endTime', type: 'integer', required: false, default: '', desc: '结束时间（毫秒）' },
+                    { name: 'limit', type: 'integer', required: false, default: 100, desc: '返回数量（最大 1000）' }
+                ]
+            },
+            {
+                method: 'GET',
+                path: '/fapi/v1/premiumIndex',
+                desc: '标记价格、指数价格和预测资金费率',
                 auth: false,
                 params: [
-                    { name: 'symbol', type: 'string', required: false, default: 'BTCUSDT', desc: '交易对' }
+                    { name: 'symbol', type: 'string', required: false, default: 'BTCUSDT', desc: '交易对（不填返回全部）' }
                 ]
             }
         ]
@@ -139,8 +182,8 @@ const API_ENDPOINTS = [
             },
             {
                 method: 'GET',
-                path: '/api/orderbook/{symbol}',
-                desc: '获取订单簿数据',
+                path: '/api/dashboard/orderbook/{symbol}',
+                desc: '获取订单簿数据（币安真实盘口前 20 档）',
                 auth: false,
                 params: [
                     { name: 'symbol', type: 'string', required: true, default: 'BTCUSDT', desc: '交易对' }